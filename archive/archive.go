@@ -2,6 +2,7 @@ package archive
 
 import (
 	"fmt"
+	"os"
 	"path"
 	"path/filepath"
 
@@ -24,11 +25,25 @@ func Run(model config.ModelConfig) error {
 		return err
 	}
 
+	// The incremental archive is created by the compressor with the snapshot
+	if mode(model) != "full" {
+		return nil
+	}
+
+	// The remote archive is streamed into the compressor
+	if IsRemote(model) {
+		return nil
+	}
+
 	// Archive + compress with tar in one step if compression is enabled and databases are not empty
 	if model.CompressWith.Type != "" && len(model.Databases) == 0 {
 		return nil
 	}
 
+	if IsNative(model) {
+		return runNative(model)
+	}
+
 	opts, err := options(model)
 	if err != nil {
 		return err
@@ -45,8 +60,7 @@ func options(model config.ModelConfig) ([]string, error) {
 	logger := logger.Tag("Archive")
 	var opts []string
 
-	includes := model.Archive.GetStringSlice("includes")
-	includes = cleanPaths(includes)
+	includes := Includes(model)
 
 	if len(includes) == 0 {
 		return nil, fmt.Errorf("archive.includes have no config")
@@ -71,12 +85,39 @@ func options(model config.ModelConfig) ([]string, error) {
 	for _, exclude := range excludes {
 		opts = append(opts, "--exclude="+filepath.Clean(exclude))
 	}
+	opts = append(opts, ExcludesFromArgs(model)...)
 
 	opts = append(opts, includes...)
 
 	return opts, nil
 }
 
+func runNative(model config.ModelConfig) error {
+	logger := logger.Tag("Archive")
+
+	includes := Includes(model)
+	if len(includes) == 0 {
+		return fmt.Errorf("archive.includes have no config")
+	}
+	logger.Info("=> includes", len(includes), "rules")
+
+	if len(model.Archive.GetStringSlice("additional_arguments")) > 0 {
+		logger.Warn("archive.additional_arguments is ignored by native archive")
+	}
+
+	f, err := os.Create(path.Join(model.DumpPath, "archive.tar"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := Write(f, includes, Excludes(model)); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
 func cleanPaths(paths []string) []string {
 	var results []string
 
@@ -0,0 +1,174 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/itgcloud/gobackup/helper"
+)
+
+// dumpPartSize is the size of each part of the streamed dumps, replaceable for testing
+var dumpPartSize = 16 << 20
+
+// DumpWriter stream the dumps from the stdout of dump tools into the tar in `streaming` mode,
+// so the dumps are not written to the disk. The entries are written before the files of includes.
+//
+// The size of a tar entry must be known before its data, so each dump is buffered and written in parts:
+// a dump fits in one part is written as it is (e.g. app.sql), otherwise it is written into
+// app.sql.part000000, app.sql.part000001, ... which can be joined by `cat app.sql.part* > app.sql`.
+// The parts of the dumps run at the same time may be interleaved.
+type DumpWriter struct {
+	mu sync.Mutex
+	tw *tar.Writer
+}
+
+// NewDumpWriter returns a DumpWriter writes the entries into w, the end of tar is not written,
+// so the other entries can be appended into w after the dumps.
+func NewDumpWriter(w io.Writer) *DumpWriter {
+	return &DumpWriter{tw: tar.NewWriter(w)}
+}
+
+// Create a dump of the path, the data is written into the tar when a part is full or the dump is closed.
+// The dump which is not closed (e.g. the dump tool failed) is incomplete in the tar.
+func (d *DumpWriter) Create(name string) *Dump {
+	return &Dump{writer: d, name: name}
+}
+
+// writeEntry write a part into the tar, the padding is flushed, so the entries of another writer can be appended
+func (d *DumpWriter) writeEntry(name string, data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(name),
+		Mode:     0640,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+		Uid:      os.Getuid(),
+		Gid:      os.Getgid(),
+	}
+	if err := d.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := d.tw.Write(data); err != nil {
+		return err
+	}
+
+	return d.tw.Flush()
+}
+
+// Dump is a file streamed into the tar by DumpWriter
+type Dump struct {
+	writer *DumpWriter
+	name   string
+	buf    bytes.Buffer
+	size   int64
+	parts  int
+}
+
+func (f *Dump) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// The full part is written when there is more data, so a dump in one part keeps its name
+		if f.buf.Len() == dumpPartSize {
+			if err := f.writePart(); err != nil {
+				return written, err
+			}
+		}
+
+		n := min(len(p), dumpPartSize-f.buf.Len())
+		f.buf.Write(p[:n])
+		p = p[n:]
+		written += n
+	}
+	f.size += int64(written)
+
+	return written, nil
+}
+
+func (f *Dump) writePart() error {
+	if err := f.writer.writeEntry(DumpPartName(f.name, f.parts), f.buf.Bytes()); err != nil {
+		return err
+	}
+	f.parts++
+	f.buf.Reset()
+
+	return nil
+}
+
+// Close write the rest of the dump into the tar
+func (f *Dump) Close() error {
+	if f.parts == 0 {
+		return f.writer.writeEntry(f.name, f.buf.Bytes())
+	}
+
+	return f.writePart()
+}
+
+// Size of the dump in bytes
+func (f *Dump) Size() int64 {
+	return f.size
+}
+
+// Parts of the dump, 0 means it is written as it is
+func (f *Dump) Parts() int {
+	return f.parts
+}
+
+// DumpPartName returns the name of the part i of the streamed dump
+func DumpPartName(name string, i int) string {
+	return fmt.Sprintf("%s.part%06d", name, i)
+}
+
+// JoinDumpParts join the parts of the streamed dump into the file of name, the parts are removed after joined.
+// It does nothing if the parts have been joined.
+func JoinDumpParts(name string, parts int) error {
+	if _, err := os.Stat(DumpPartName(name, 0)); os.IsNotExist(err) && helper.IsExistsPath(name) {
+		return nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".join-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	for i := 0; i < parts; i++ {
+		if err := appendFile(f, DumpPartName(name, i)); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+
+	for i := 0; i < parts; i++ {
+		if err := os.Remove(DumpPartName(name, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,90 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestDumpWriter(t *testing.T) {
+	defer func(size int) { dumpPartSize = size }(dumpPartSize)
+	dumpPartSize = 4
+
+	src := t.TempDir()
+	dumpPath := filepath.Join(src, "demo", "mysql", "mysql1")
+	assert.NoError(t, os.MkdirAll(dumpPath, 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(dumpPath, "gobackup_streamed.json"), []byte("[]"), 0640))
+
+	var buf bytes.Buffer
+	w := NewDumpWriter(&buf)
+
+	// The parts of dumps at the same time are interleaved
+	app := w.Create(filepath.Join(dumpPath, "app.sql"))
+	small := w.Create(filepath.Join(dumpPath, "small.sql"))
+	exact := w.Create(filepath.Join(dumpPath, "exact.sql"))
+	for _, s := range []string{"hel", "lo w", "orld"} {
+		_, err := app.Write([]byte(s))
+		assert.NoError(t, err)
+	}
+	_, err := small.Write([]byte("hi"))
+	assert.NoError(t, err)
+	_, err = exact.Write([]byte("1234"))
+	assert.NoError(t, err)
+	assert.NoError(t, small.Close())
+	assert.NoError(t, app.Close())
+	assert.NoError(t, exact.Close())
+
+	assert.Equal(t, int64(11), app.Size())
+	assert.Equal(t, 3, app.Parts())
+	assert.Equal(t, int64(2), small.Size())
+	assert.Equal(t, 0, small.Parts())
+	assert.Equal(t, 0, exact.Parts())
+
+	// The files are appended after the dumps
+	assert.NoError(t, Write(&buf, []string{dumpPath}, nil))
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, strings.TrimPrefix(hdr.Name, filepath.ToSlash(dumpPath)+"/"))
+	}
+	assert.Equal(t, []string{
+		"app.sql.part000000", "app.sql.part000001", "small.sql", "app.sql.part000002", "exact.sql",
+		"", "gobackup_streamed.json",
+	}, names)
+
+	target := t.TempDir()
+	assert.NoError(t, Extract(bytes.NewReader(buf.Bytes()), target))
+	out := filepath.Join(target, dumpPath)
+	assert.NoError(t, JoinDumpParts(filepath.Join(out, "app.sql"), 3))
+
+	for name, expected := range map[string]string{"app.sql": "hello world", "small.sql": "hi", "exact.sql": "1234"} {
+		data, err := os.ReadFile(filepath.Join(out, name))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(data))
+	}
+	_, err = os.Stat(filepath.Join(out, "app.sql.part000000"))
+	assert.True(t, os.IsNotExist(err))
+
+	// The dumps and files are in one tar stream for tar too
+	if _, err := exec.LookPath("tar"); err == nil {
+		cmd := exec.Command("tar", "-tf", "-")
+		cmd.Stdin = bytes.NewReader(buf.Bytes())
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(output))
+		assert.Contains(t, string(output), "app.sql.part000002")
+		assert.Contains(t, string(output), "gobackup_streamed.json")
+	}
+}
@@ -0,0 +1,362 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/manifest"
+)
+
+// Filters of `archive`, the files are selected by them before archiving:
+//
+//   - excludes: glob patterns, `**` matches any directories, e.g.: `**/*.log`, `/data/**/cache`
+//   - exclude_if_present: skip the directories contain the marker file, e.g.: `.nobackup`
+//   - exclude_caches: skip the directories contain a valid `CACHEDIR.TAG`
+//   - max_file_size: skip the files larger than it, e.g.: `100M`
+//   - one_file_system: don't cross the file system boundaries of includes
+//   - files_from: a file lists the paths to include, one per line
+//
+// The includes are walked by Prepare, the excluded paths are written into the temp path for
+// `tar --exclude-from` and the native archive, and the number of excluded files and bytes of each rule
+// is recorded in the manifest.
+const (
+	excludesFile = "gobackup_excludes.list"
+	cacheDirTag  = "CACHEDIR.TAG"
+	// cacheDirSignature is the beginning of a valid CACHEDIR.TAG, https://bford.info/cachedir/
+	cacheDirSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+)
+
+type filter struct {
+	patterns        []string
+	markers         []string
+	excludeCaches   bool
+	maxFileSize     int64
+	maxFileSizeRule string
+	oneFileSystem   bool
+}
+
+func newFilter(archive *viper.Viper) (*filter, error) {
+	f := &filter{
+		patterns:      cleanPaths(archive.GetStringSlice("excludes")),
+		markers:       archive.GetStringSlice("exclude_if_present"),
+		excludeCaches: archive.GetBool("exclude_caches"),
+		oneFileSystem: archive.GetBool("one_file_system"),
+	}
+
+	if size := archive.GetString("max_file_size"); len(size) > 0 {
+		maxFileSize, err := helper.ParseSize(size)
+		if err != nil {
+			return nil, fmt.Errorf("archive.max_file_size: %v", err)
+		}
+		f.maxFileSize = maxFileSize
+		f.maxFileSizeRule = "max_file_size: " + size
+	}
+
+	return f, nil
+}
+
+// rules returns the name of rules in order, they are used in the report
+func (f *filter) rules() (rules []string) {
+	for _, pattern := range f.patterns {
+		rules = append(rules, "excludes: "+pattern)
+	}
+	if f.oneFileSystem {
+		rules = append(rules, "one_file_system")
+	}
+	for _, marker := range f.markers {
+		rules = append(rules, "exclude_if_present: "+marker)
+	}
+	if f.excludeCaches {
+		rules = append(rules, "exclude_caches")
+	}
+	if f.maxFileSize > 0 {
+		rules = append(rules, f.maxFileSizeRule)
+	}
+
+	return
+}
+
+// match returns the rule excludes the path, empty if it is included.
+// rootDev is the device of the include, for `one_file_system`.
+func (f *filter) match(p string, info os.FileInfo, rootDev uint64) string {
+	for _, pattern := range f.patterns {
+		if isExcluded(p, []string{pattern}) {
+			return "excludes: " + pattern
+		}
+	}
+
+	if info.IsDir() {
+		if f.oneFileSystem {
+			if dev, ok := device(info); ok && dev != rootDev {
+				return "one_file_system"
+			}
+		}
+		for _, marker := range f.markers {
+			if helper.IsExistsPath(filepath.Join(p, marker)) {
+				return "exclude_if_present: " + marker
+			}
+		}
+		if f.excludeCaches && isCacheDir(p) {
+			return "exclude_caches"
+		}
+	}
+
+	if f.maxFileSize > 0 && info.Mode().IsRegular() && info.Size() > f.maxFileSize {
+		return f.maxFileSizeRule
+	}
+
+	return ""
+}
+
+// scan walk the includes, returns the excluded paths and the number of excluded files and bytes of each rule.
+// The files in the excluded directories are counted, except for `one_file_system`, which counts the skipped
+// mount points only.
+func (f *filter) scan(includes []string) (excluded []string, report []manifest.ExcludedRule) {
+	logger := logger.Tag("Archive")
+
+	stats := map[string]*manifest.ExcludedRule{}
+	for _, rule := range f.rules() {
+		stats[rule] = &manifest.ExcludedRule{Rule: rule}
+	}
+
+	for _, include := range includes {
+		rootInfo, err := os.Stat(include)
+		if err != nil {
+			logger.Warnf("%s: %v", include, err)
+			continue
+		}
+		rootDev, _ := device(rootInfo)
+
+		_ = filepath.Walk(include, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				// --ignore-failed-read
+				logger.Warnf("%s: %v", p, err)
+				return nil
+			}
+
+			rule := f.match(p, info, rootDev)
+			if len(rule) == 0 {
+				return nil
+			}
+
+			excluded = append(excluded, p)
+			stat := stats[rule]
+			if !info.IsDir() {
+				stat.Files++
+				stat.Bytes += info.Size()
+				return nil
+			}
+
+			if rule == "one_file_system" {
+				stat.Files++
+			} else {
+				files, size := dirUsage(p)
+				stat.Files += files
+				stat.Bytes += size
+			}
+			return filepath.SkipDir
+		})
+	}
+
+	for _, rule := range f.rules() {
+		report = append(report, *stats[rule])
+	}
+
+	return excluded, report
+}
+
+// prepareFilter scan the includes with the filters, and write the excluded paths and report into the temp path
+func prepareFilter(model config.ModelConfig) error {
+	logger := logger.Tag("Archive")
+
+	if model.Archive == nil {
+		return nil
+	}
+
+	if filesFrom := model.Archive.GetString("files_from"); len(filesFrom) > 0 {
+		if _, err := readFilesFrom(filesFrom); err != nil {
+			return err
+		}
+	}
+
+	f, err := newFilter(model.Archive)
+	if err != nil {
+		return err
+	}
+	if len(f.rules()) == 0 {
+		return nil
+	}
+
+	excluded, report := f.scan(Includes(model))
+	for _, rule := range report {
+		logger.Infof("=> excluded by %s: %d files, %d bytes", rule.Rule, rule.Files, rule.Bytes)
+	}
+
+	if err := helper.MkdirP(model.TempPath); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, p := range excluded {
+		if strings.Contains(p, "\n") {
+			logger.Warnf("%q: the path contains newline, it can not be excluded", p)
+			continue
+		}
+		buf.WriteString(p + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(model.TempPath, excludesFile), buf.Bytes(), 0640); err != nil {
+		return err
+	}
+
+	return manifest.WriteExcluded(model.TempPath, report)
+}
+
+// ExcludesFrom returns the file of excluded paths in the temp path for `tar --exclude-from`,
+// empty if there are no filters.
+func ExcludesFrom(model config.ModelConfig) string {
+	excludesPath := filepath.Join(model.TempPath, excludesFile)
+	if !helper.IsExistsPath(excludesPath) {
+		return ""
+	}
+
+	return excludesPath
+}
+
+// ExcludesFromArgs returns the tar options to exclude the paths by filters, the paths are matched literally
+func ExcludesFromArgs(model config.ModelConfig) []string {
+	excludesPath := ExcludesFrom(model)
+	if len(excludesPath) == 0 {
+		return []string{}
+	}
+
+	if helper.IsGnuTar {
+		// --no-wildcards only affects the options after it
+		return []string{"--no-wildcards", "--exclude-from=" + excludesPath}
+	}
+
+	return []string{"--exclude-from=" + excludesPath}
+}
+
+// Excludes returns the exclude patterns and the excluded paths by filters, for the native archive
+func Excludes(model config.ModelConfig) []string {
+	if model.Archive == nil {
+		return []string{}
+	}
+
+	excludes := cleanPaths(model.Archive.GetStringSlice("excludes"))
+
+	excludesPath := ExcludesFrom(model)
+	if len(excludesPath) == 0 {
+		return excludes
+	}
+
+	data, err := os.ReadFile(excludesPath)
+	if err != nil {
+		logger.Tag("Archive").Warnf("Failed to read excluded paths: %v", err)
+		return excludes
+	}
+
+	for _, p := range strings.Split(string(data), "\n") {
+		if len(p) > 0 {
+			excludes = append(excludes, p)
+		}
+	}
+
+	return excludes
+}
+
+// Includes of archive, with the paths listed in `files_from`
+func Includes(model config.ModelConfig) []string {
+	if model.Archive == nil {
+		return nil
+	}
+
+	includes := model.Archive.GetStringSlice("includes")
+	if filesFrom := model.Archive.GetString("files_from"); len(filesFrom) > 0 {
+		paths, err := readFilesFrom(filesFrom)
+		if err != nil {
+			logger.Tag("Archive").Warn(err)
+		}
+		includes = append(includes, paths...)
+	}
+
+	return cleanPaths(includes)
+}
+
+// readFilesFrom read the paths in the list file, the empty lines and lines start with `#` are ignored,
+// and the relative paths are relative to the directory of the list file.
+func readFilesFrom(filesFrom string) ([]string, error) {
+	f, err := os.Open(filesFrom)
+	if err != nil {
+		return nil, fmt.Errorf("archive.files_from: %v", err)
+	}
+	defer f.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(filesFrom), line)
+		}
+		paths = append(paths, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("archive.files_from: %v", err)
+	}
+
+	return paths, nil
+}
+
+func isCacheDir(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, cacheDirTag))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(cacheDirSignature))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return false
+	}
+
+	return string(buf) == cacheDirSignature
+}
+
+// dirUsage returns the number and total size of the regular files in the directory
+func dirUsage(dir string) (files, size int64) {
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return
+}
+
+func device(info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(stat.Dev), true //nolint:unconvert
+}
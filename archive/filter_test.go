@@ -0,0 +1,113 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/manifest"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0750))
+		assert.NoError(t, os.WriteFile(p, []byte(content), 0640))
+	}
+}
+
+func TestFilter_scan(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"a.txt":                 "a",
+		"big.bin":               "0123456789",
+		"app/logs/a.log":        "log",
+		"app/logs/b.txt":        "txt",
+		"skip/.nobackup":        "",
+		"skip/c.txt":            "cc",
+		"cache/CACHEDIR.TAG":    cacheDirSignature + "\n",
+		"cache/d.txt":           "ddd",
+		"notcache/CACHEDIR.TAG": "invalid",
+	})
+
+	archive := viper.New()
+	archive.Set("excludes", []string{"**/logs/*.log"})
+	archive.Set("exclude_if_present", []string{".nobackup"})
+	archive.Set("exclude_caches", true)
+	archive.Set("max_file_size", "8")
+	archive.Set("one_file_system", true)
+
+	f, err := newFilter(archive)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"excludes: **/logs/*.log", "one_file_system", "exclude_if_present: .nobackup", "exclude_caches", "max_file_size: 8"}, f.rules())
+
+	excluded, report := f.scan([]string{src, filepath.Join(src, "not-exist")})
+	assert.Equal(t, []string{
+		filepath.Join(src, "app/logs/a.log"),
+		filepath.Join(src, "big.bin"),
+		filepath.Join(src, "cache"),
+		filepath.Join(src, "skip"),
+	}, excluded)
+	assert.Equal(t, []manifest.ExcludedRule{
+		{Rule: "excludes: **/logs/*.log", Files: 1, Bytes: 3},
+		{Rule: "one_file_system"},
+		{Rule: "exclude_if_present: .nobackup", Files: 2, Bytes: 2},
+		{Rule: "exclude_caches", Files: 2, Bytes: int64(len(cacheDirSignature)) + 4},
+		{Rule: "max_file_size: 8", Files: 1, Bytes: 10},
+	}, report)
+
+	archive.Set("max_file_size", "8X")
+	_, err = newFilter(archive)
+	assert.EqualError(t, err, `archive.max_file_size: invalid size: "8X"`)
+}
+
+func TestIncludes(t *testing.T) {
+	dir := t.TempDir()
+	filesFrom := filepath.Join(dir, "files.txt")
+	assert.NoError(t, os.WriteFile(filesFrom, []byte("# comment\n/etc/nginx/\n\n  data  \n"), 0640))
+
+	archive := viper.New()
+	archive.Set("includes", []string{"/var/www/"})
+	archive.Set("files_from", filesFrom)
+	model := config.ModelConfig{Archive: archive}
+	assert.Equal(t, []string{"/var/www", "/etc/nginx", filepath.Join(dir, "data")}, Includes(model))
+
+	archive.Set("files_from", filepath.Join(dir, "not-exist"))
+	assert.Equal(t, []string{"/var/www"}, Includes(model))
+	assert.Error(t, Prepare(model))
+
+	assert.Nil(t, Includes(config.ModelConfig{}))
+}
+
+func TestPrepare_filter(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"a.txt":          "a",
+		"skip/.nobackup": "",
+		"skip/b.txt":     "b",
+	})
+
+	archive := viper.New()
+	archive.Set("includes", []string{src})
+	archive.Set("excludes", []string{"*.log"})
+	model := config.ModelConfig{Name: "filter", TempPath: t.TempDir(), Archive: archive}
+
+	// Only the patterns, the report is recorded
+	assert.NoError(t, Prepare(model))
+	assert.Equal(t, []manifest.ExcludedRule{{Rule: "excludes: *.log"}}, manifest.ReadExcluded(model.TempPath))
+	assert.Equal(t, []string{"*.log"}, Excludes(model))
+
+	archive.Set("exclude_if_present", []string{".nobackup"})
+	assert.NoError(t, Prepare(model))
+	assert.Equal(t, []string{"*.log", filepath.Join(src, "skip")}, Excludes(model))
+
+	excludesPath := filepath.Join(model.TempPath, excludesFile)
+	assert.Equal(t, excludesPath, ExcludesFrom(model))
+	args := strings.Join(ExcludesFromArgs(model), " ")
+	assert.True(t, strings.HasSuffix(args, "--exclude-from="+excludesPath))
+}
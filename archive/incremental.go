@@ -0,0 +1,193 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/manifest"
+)
+
+// Incremental and differential archive of `archive.includes`
+//
+//   - mode: full (default), incremental or differential
+//   - full_every: 7, take a full archive every N packages
+//
+// The incremental archive contains the files changed since the last package, and the differential archive
+// contains the files changed since the last full package. The snapshots of files are kept in
+// GOBACKUP_DIR/archive/<model>/, and only saved after the package has been stored.
+//
+// GNU tar is used with `--listed-incremental`, so the deleted files are recorded. Otherwise the native archive
+// compares the size and modification time of files, the deleted files are not recorded.
+var incrementalPath = filepath.Join(config.GoBackupDir, "archive")
+
+const (
+	// snapshotName of the snapshot files, with the extension of GNU tar or native archive
+	snapshotName      = "snapshot"
+	gnuSnapshotExt    = ".snar"
+	nativeSnapshotExt = ".json"
+	chainStateFile    = "chain.json"
+)
+
+// chainState of the incremental archive of the model
+type chainState struct {
+	// Full is the package of the last full archive
+	Full string `json:"full"`
+	// Last is the last package
+	Last string `json:"last"`
+	// Runs is the number of packages since the full archive, include the full archive
+	Runs int `json:"runs"`
+}
+
+func mode(model config.ModelConfig) string {
+	if model.Archive == nil {
+		return "full"
+	}
+
+	model.Archive.SetDefault("mode", "full")
+	return model.Archive.GetString("mode")
+}
+
+// Prepare scan the includes with the filters, and decide the level of archive by the chain state,
+// the snapshot of the base package is copied into the temp path for the archive, and the level is written
+// into the temp path for the manifest.
+func Prepare(model config.ModelConfig) error {
+	logger := logger.Tag("Archive")
+
+	// The includes are not local, the filters are passed to the remote tar
+	if IsRemote(model) {
+		if err := checkRemote(model); err != nil {
+			return err
+		}
+	} else if err := prepareFilter(model); err != nil {
+		return err
+	}
+
+	switch mode(model) {
+	case "full":
+		return nil
+	case "incremental", "differential":
+	default:
+		return fmt.Errorf("archive.mode %q is not supported, it should be full, incremental or differential", mode(model))
+	}
+
+	model.Archive.SetDefault("full_every", 7)
+	fullEvery := model.Archive.GetInt("full_every")
+
+	dir := filepath.Join(incrementalPath, model.Name)
+	state := loadChainState(dir)
+
+	level := manifest.ArchiveLevel{Level: "full"}
+	prefix := "last"
+	switch {
+	case len(state.Last) == 0:
+		logger.Info("No previous archive, take a full archive")
+	case fullEvery > 0 && state.Runs >= fullEvery:
+		logger.Infof("%d packages since the full archive, take a full archive", state.Runs)
+	case mode(model) == "incremental":
+		level = manifest.ArchiveLevel{Level: "incremental", Base: state.Last}
+	default:
+		level = manifest.ArchiveLevel{Level: "differential", Base: state.Full}
+		prefix = "full"
+	}
+
+	if err := helper.MkdirP(model.TempPath); err != nil {
+		return err
+	}
+
+	if level.Level != "full" {
+		logger.Infof("=> %s archive based on %s", level.Level, level.Base)
+		for _, ext := range []string{gnuSnapshotExt, nativeSnapshotExt} {
+			src := filepath.Join(dir, prefix+ext)
+			if !helper.IsExistsPath(src) {
+				continue
+			}
+			if err := helper.CopyFile(src, filepath.Join(model.TempPath, snapshotName+ext)); err != nil {
+				return fmt.Errorf("failed to copy snapshot: %v", err)
+			}
+		}
+	}
+
+	return manifest.WriteArchiveLevel(model.TempPath, level)
+}
+
+// Snapshot returns the snapshot file in the temp path for `tar --listed-incremental` or the native archive,
+// empty if the archive is full.
+func Snapshot(model config.ModelConfig, native bool) string {
+	if manifest.ReadArchiveLevel(model.TempPath) == nil {
+		return ""
+	}
+
+	if native {
+		return filepath.Join(model.TempPath, snapshotName+nativeSnapshotExt)
+	}
+
+	return filepath.Join(model.TempPath, snapshotName+gnuSnapshotExt)
+}
+
+// Commit save the snapshot and chain state after the package has been stored, so the next archive will be
+// based on it. The package is named by the archive file name without extension.
+func Commit(model config.ModelConfig, archivePath string) error {
+	level := manifest.ReadArchiveLevel(model.TempPath)
+	if level == nil {
+		return nil
+	}
+
+	dir := filepath.Join(incrementalPath, model.Name)
+	if err := helper.MkdirP(dir); err != nil {
+		return err
+	}
+
+	pkg := manifest.PackageID(archivePath)
+	state := loadChainState(dir)
+	if level.Level == "full" {
+		state = chainState{Full: pkg, Last: pkg, Runs: 1}
+	} else {
+		state.Last = pkg
+		state.Runs++
+	}
+
+	for _, ext := range []string{gnuSnapshotExt, nativeSnapshotExt} {
+		targets := []string{filepath.Join(dir, "last"+ext)}
+		if level.Level == "full" {
+			targets = append(targets, filepath.Join(dir, "full"+ext))
+		}
+
+		src := filepath.Join(model.TempPath, snapshotName+ext)
+		for _, target := range targets {
+			if !helper.IsExistsPath(src) {
+				// The snapshot of the other archive is stale
+				_ = os.Remove(target)
+				continue
+			}
+			if err := helper.CopyFile(src, target); err != nil {
+				return fmt.Errorf("failed to save snapshot: %v", err)
+			}
+		}
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, chainStateFile), data, 0660)
+}
+
+func loadChainState(dir string) (state chainState) {
+	data, err := os.ReadFile(filepath.Join(dir, chainStateFile))
+	if err != nil {
+		return
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		logger.Tag("Archive").Warnf("Invalid chain state in %s, take a full archive: %v", dir, err)
+		return chainState{}
+	}
+
+	return
+}
@@ -0,0 +1,133 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/manifest"
+)
+
+// tarFiles returns the names of regular files in the tar
+func tarFiles(t *testing.T, r io.Reader) (names []string) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return
+		}
+		assert.NoError(t, err)
+		if hdr.Typeflag == tar.TypeReg {
+			names = append(names, filepath.Base(hdr.Name))
+		}
+	}
+}
+
+func TestWriteIncremental(t *testing.T) {
+	src := t.TempDir()
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.json")
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "b.txt"), []byte("b"), 0640))
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteIncremental(&buf, []string{src}, nil, snapshotPath))
+	assert.Equal(t, []string{"a.txt", "b.txt"}, tarFiles(t, &buf))
+
+	buf.Reset()
+	assert.NoError(t, WriteIncremental(&buf, []string{src}, nil, snapshotPath))
+	assert.Nil(t, tarFiles(t, &buf))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "b.txt"), []byte("bb"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "c.txt"), []byte("c"), 0640))
+	buf.Reset()
+	assert.NoError(t, WriteIncremental(&buf, []string{src}, nil, snapshotPath))
+	assert.Equal(t, []string{"b.txt", "c.txt"}, tarFiles(t, &buf))
+}
+
+func TestPrepare_Commit(t *testing.T) {
+	defer func(p string) { incrementalPath = p }(incrementalPath)
+	incrementalPath = t.TempDir()
+
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0640))
+
+	archive := viper.New()
+	archive.Set("includes", []string{src})
+	archive.Set("mode", "incremental")
+	archive.Set("full_every", 3)
+
+	run := func(mode string, pkg string) (*manifest.ArchiveLevel, []string) {
+		archive.Set("mode", mode)
+		model := config.ModelConfig{Name: "demo", TempPath: filepath.Join(t.TempDir(), "tmp"), Archive: archive}
+		assert.NoError(t, Prepare(model))
+
+		var buf bytes.Buffer
+		assert.NoError(t, WriteIncremental(&buf, []string{src}, nil, Snapshot(model, true)))
+		assert.NoError(t, Commit(model, filepath.Join(model.TempPath, pkg+".tar.gz")))
+
+		return manifest.ReadArchiveLevel(model.TempPath), tarFiles(t, &buf)
+	}
+
+	level, files := run("incremental", "demo-1")
+	assert.Equal(t, &manifest.ArchiveLevel{Level: "full"}, level)
+	assert.Equal(t, []string{"a.txt"}, files)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "b.txt"), []byte("b"), 0640))
+	level, files = run("incremental", "demo-2")
+	assert.Equal(t, &manifest.ArchiveLevel{Level: "incremental", Base: "demo-1"}, level)
+	assert.Equal(t, []string{"b.txt"}, files)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "c.txt"), []byte("c"), 0640))
+	level, files = run("incremental", "demo-3")
+	assert.Equal(t, &manifest.ArchiveLevel{Level: "incremental", Base: "demo-2"}, level)
+	assert.Equal(t, []string{"c.txt"}, files)
+
+	// full_every
+	level, files = run("incremental", "demo-4")
+	assert.Equal(t, &manifest.ArchiveLevel{Level: "full"}, level)
+	assert.Equal(t, []string{"a.txt", "b.txt", "c.txt"}, files)
+
+	// differential is always based on the full archive
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "d.txt"), []byte("d"), 0640))
+	level, files = run("differential", "demo-5")
+	assert.Equal(t, &manifest.ArchiveLevel{Level: "differential", Base: "demo-4"}, level)
+	assert.Equal(t, []string{"d.txt"}, files)
+
+	future := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(src, "a.txt"), future, future))
+	level, files = run("differential", "demo-6")
+	assert.Equal(t, &manifest.ArchiveLevel{Level: "differential", Base: "demo-4"}, level)
+	assert.Equal(t, []string{"a.txt", "d.txt"}, files)
+
+	archive.Set("mode", "foo")
+	err := Prepare(config.ModelConfig{Name: "demo", Archive: archive})
+	assert.EqualError(t, err, `archive.mode "foo" is not supported, it should be full, incremental or differential`)
+
+	// full mode does nothing
+	archive.Set("mode", "full")
+	model := config.ModelConfig{Name: "demo", TempPath: t.TempDir(), Archive: archive}
+	assert.NoError(t, Prepare(model))
+	assert.Equal(t, "", Snapshot(model, false))
+	assert.Equal(t, chainState{Full: "demo-4", Last: "demo-6", Runs: 3}, loadChainState(filepath.Join(incrementalPath, "demo")))
+}
+
+func TestCommit_dottedName(t *testing.T) {
+	defer func(p string) { incrementalPath = p }(incrementalPath)
+	incrementalPath = t.TempDir()
+
+	model := config.ModelConfig{Name: "demo.v2", TempPath: t.TempDir()}
+	assert.NoError(t, manifest.WriteArchiveLevel(model.TempPath, manifest.ArchiveLevel{Level: "full"}))
+	assert.NoError(t, Commit(model, filepath.Join(model.TempPath, "demo.v2-2023-01-01-00-00-00.tar.gz")))
+
+	state := loadChainState(filepath.Join(incrementalPath, "demo.v2"))
+	assert.Equal(t, "demo.v2-2023-01-01-00-00-00", state.Full)
+	assert.Equal(t, "demo.v2-2023-01-01-00-00-00", state.Last)
+}
@@ -0,0 +1,377 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/logger"
+)
+
+// IsNative returns true when the archive should be created by Go instead of `tar` command.
+//
+// - compress_with.native: true
+// - `tar` command is not found, e.g.: in distroless container
+// - archive.mode is incremental or differential, but `tar` is not GNU tar
+// - archive.remote, the remote tar stream is appended by Go
+func IsNative(model config.ModelConfig) bool {
+	if model.CompressWith.Viper != nil && model.CompressWith.Viper.GetBool("native") {
+		return true
+	}
+
+	if IsRemote(model) {
+		return true
+	}
+
+	if mode(model) != "full" && !helper.IsGnuTar {
+		return true
+	}
+
+	if _, err := exec.LookPath("tar"); err != nil {
+		return true
+	}
+
+	return false
+}
+
+// Write files of includes into w as tar, like `tar --ignore-failed-read -cP --exclude=... includes...`.
+// The file names are kept as the absolute path.
+func Write(w io.Writer, includes, excludes []string) error {
+	return WriteIncremental(w, includes, excludes, "")
+}
+
+// fileState in the snapshot of native incremental archive
+type fileState struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime"`
+}
+
+// WriteIncremental is Write with the snapshot file, only the files changed since the snapshot are written,
+// the directories are always written. The snapshot is updated after written, like `tar --listed-incremental`.
+// It is the same as Write when snapshotPath is empty.
+func WriteIncremental(w io.Writer, includes, excludes []string, snapshotPath string) error {
+	tw := tar.NewWriter(w)
+	current, err := writeIncremental(tw, includes, excludes, snapshotPath)
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if len(snapshotPath) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(snapshotPath, data, 0640)
+}
+
+// writeIncremental write the files of includes into tw, returns the current state of files for the snapshot
+func writeIncremental(tw *tar.Writer, includes, excludes []string, snapshotPath string) (map[string]fileState, error) {
+	logger := logger.Tag("Archive")
+
+	previous := map[string]fileState{}
+	current := map[string]fileState{}
+	if len(snapshotPath) > 0 {
+		if data, err := os.ReadFile(snapshotPath); err == nil {
+			if err := json.Unmarshal(data, &previous); err != nil {
+				logger.Warnf("Invalid snapshot %s, all files will be archived: %v", snapshotPath, err)
+			}
+		}
+	}
+
+	excluder := newExcluder(excludes)
+	for _, include := range includes {
+		err := filepath.Walk(include, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				// --ignore-failed-read
+				logger.Warnf("%s: %v", p, err)
+				return nil
+			}
+
+			if excluder.match(p) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if len(snapshotPath) > 0 && !info.IsDir() {
+				state := fileState{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+				current[p] = state
+				if last, ok := previous[p]; ok && last == state {
+					return nil
+				}
+			}
+
+			return writeFile(tw, p, info)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return current, nil
+}
+
+func writeFile(tw *tar.Writer, p string, info os.FileInfo) error {
+	logger := logger.Tag("Archive")
+
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(p); err != nil {
+			logger.Warnf("%s: %v", p, err)
+			return nil
+		}
+	}
+
+	// Skip socket, it is not supported by tar
+	if info.Mode()&os.ModeSocket != 0 {
+		logger.Warnf("%s: socket ignored", p)
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		logger.Warnf("%s: %v", p, err)
+		return nil
+	}
+	hdr.Name = filepath.ToSlash(p)
+	if info.IsDir() && !strings.HasSuffix(hdr.Name, "/") {
+		hdr.Name += "/"
+	}
+
+	if !info.Mode().IsRegular() {
+		return tw.WriteHeader(hdr)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		logger.Warnf("%s: %v", p, err)
+		return nil
+	}
+	defer f.Close()
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	n, err := io.CopyN(tw, f, hdr.Size)
+	if err == io.EOF {
+		// File shrank, pad with zeros like GNU tar
+		logger.Warnf("%s: file shrank by %d bytes; padding with zeros", p, hdr.Size-n)
+		_, err = io.CopyN(tw, zeroReader{}, hdr.Size-n)
+	}
+
+	return err
+}
+
+// excluder match the paths with excludes, the absolute paths without glob (e.g.: the excluded paths by filters)
+// are matched by map, so the large number of them will not slow down the archive.
+type excluder struct {
+	paths    map[string]bool
+	patterns []string
+}
+
+func newExcluder(excludes []string) *excluder {
+	e := &excluder{paths: map[string]bool{}}
+	for _, exclude := range excludes {
+		if filepath.IsAbs(exclude) && !strings.ContainsAny(exclude, "*?[\\") {
+			e.paths[exclude] = true
+		} else {
+			e.patterns = append(e.patterns, exclude)
+		}
+	}
+
+	return e
+}
+
+func (e *excluder) match(p string) bool {
+	for dir := p; ; dir = filepath.Dir(dir) {
+		if e.paths[dir] {
+			return true
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	return isExcluded(p, e.patterns)
+}
+
+// isExcluded returns true if the path or its parent is excluded. The absolute patterns match the full path,
+// and the relative patterns match at any depth (e.g.: `*.log`, `logs/*.log`), `**` matches any directories.
+func isExcluded(p string, excludes []string) bool {
+	for _, exclude := range excludes {
+		if p == exclude || strings.HasPrefix(p, exclude+"/") {
+			return true
+		}
+
+		pattern := filepath.ToSlash(exclude)
+		if !strings.HasPrefix(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		if matchGlob(strings.Split(pattern, "/"), strings.Split(filepath.ToSlash(p), "/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchGlob match the parts of path with the parts of pattern, `**` matches zero or more parts.
+// The path is also matched if its parent is matched, like excluding a directory.
+func matchGlob(patterns, parts []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			patterns = patterns[1:]
+			if len(patterns) == 0 {
+				return true
+			}
+			for i := range parts {
+				if matchGlob(patterns, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(patterns[0], parts[0]); !matched {
+			return false
+		}
+		patterns, parts = patterns[1:], parts[1:]
+	}
+
+	return true
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// Extract the tar stream into targetDir, the leading `/` of file names will be removed like `tar -x`.
+// The symlinks are extracted as is, and the entries under them are rejected to avoid writing out of targetDir.
+func Extract(r io.Reader, targetDir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Clean with `/` prefix to avoid the path is out of targetDir
+		target := filepath.Join(targetDir, filepath.Clean("/"+hdr.Name))
+		// The symlinks are extracted as is, so never write through them
+		if err := checkParents(targetDir, target); err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, hdr.FileInfo().Mode().Perm()|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(tr, target, hdr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+				return err
+			}
+			_ = os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source := filepath.Join(targetDir, filepath.Clean("/"+hdr.Linkname))
+			if err := checkParents(targetDir, source); err != nil {
+				return err
+			}
+			_ = os.Remove(target)
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		default:
+			logger.Tag("Archive").Warnf("%s: unsupported type %c, skipped", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+func extractFile(tr *tar.Reader, target string, hdr *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return err
+	}
+
+	// Unlink it first like `tar -x`, the existing file may be a symlink to the outside of targetDir
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, hdr.FileInfo().Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, tr); err != nil {
+		return fmt.Errorf("extract %s: %v", hdr.Name, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+// checkParents returns an error if any parent of target in targetDir is a symlink,
+// e.g.: `foo -> /etc` and then `foo/passwd` in the tar stream.
+func checkParents(targetDir, target string) error {
+	rel, err := filepath.Rel(targetDir, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+
+	p := targetDir
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, name)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("extract %s: the parent %s is a symlink", target, p)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestWrite_Extract(t *testing.T) {
+	src := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "foo", "logs"), 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "foo", "a.txt"), []byte("hello"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "foo", "b.log"), []byte("skip"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "foo", "logs", "c.txt"), []byte("skip"), 0640))
+	assert.NoError(t, os.Symlink("a.txt", filepath.Join(src, "foo", "link")))
+
+	var buf bytes.Buffer
+	excludes := []string{filepath.Join(src, "foo", "logs"), "*.log"}
+	err := Write(&buf, []string{filepath.Join(src, "foo"), filepath.Join(src, "not-exist")}, excludes)
+	assert.NoError(t, err)
+
+	target := t.TempDir()
+	assert.NoError(t, Extract(&buf, target))
+
+	out := filepath.Join(target, src, "foo")
+	data, err := os.ReadFile(filepath.Join(out, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	link, err := os.Readlink(filepath.Join(out, "link"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a.txt", link)
+
+	_, err = os.Stat(filepath.Join(out, "b.log"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(out, "logs"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExtract_symlinkEscape(t *testing.T) {
+	outside := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(outside, "passwd"), []byte("root"), 0640))
+
+	archive := func(headers ...*tar.Header) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range headers {
+			assert.NoError(t, tw.WriteHeader(hdr))
+			if hdr.Typeflag == tar.TypeReg {
+				_, err := tw.Write([]byte("evil"))
+				assert.NoError(t, err)
+			}
+		}
+		assert.NoError(t, tw.Close())
+		return &buf
+	}
+	file := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0640, Size: 4}
+	}
+
+	// Write through the symlink of directory
+	for _, linkname := range []string{outside, "../../" + filepath.Base(outside)} {
+		target := t.TempDir()
+		err := Extract(archive(
+			&tar.Header{Name: "foo/etc", Typeflag: tar.TypeSymlink, Linkname: linkname},
+			file("foo/etc/passwd"),
+		), target)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "is a symlink")
+
+		// The symlink itself is extracted as is
+		link, err := os.Readlink(filepath.Join(target, "foo", "etc"))
+		assert.NoError(t, err)
+		assert.Equal(t, linkname, link)
+	}
+
+	// Overwrite the symlink of file
+	target := t.TempDir()
+	assert.NoError(t, Extract(archive(
+		&tar.Header{Name: "foo/passwd", Typeflag: tar.TypeSymlink, Linkname: filepath.Join(outside, "passwd")},
+		file("foo/passwd"),
+	), target))
+	data, err := os.ReadFile(filepath.Join(target, "foo", "passwd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "evil", string(data))
+
+	// Hard link to the file through the symlink
+	err = Extract(archive(
+		&tar.Header{Name: "foo/etc", Typeflag: tar.TypeSymlink, Linkname: outside},
+		&tar.Header{Name: "foo/passwd", Typeflag: tar.TypeLink, Linkname: "foo/etc/passwd"},
+	), t.TempDir())
+	assert.Error(t, err)
+
+	data, err = os.ReadFile(filepath.Join(outside, "passwd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "root", string(data))
+	entries, err := os.ReadDir(outside)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+// The native archive is a standard tar stream, not byte-identical to `tar`, but it can be extracted by `tar`
+func TestWrite_tarExtract(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar is not found")
+	}
+
+	src := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "foo", "empty"), 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "foo", "a.txt"), []byte("hello"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "foo", strings.Repeat("long", 40)+".txt"), []byte("long name"), 0640))
+	assert.NoError(t, os.Symlink("a.txt", filepath.Join(src, "foo", "link")))
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, []string{filepath.Join(src, "foo")}, nil))
+
+	target := t.TempDir()
+	cmd := exec.Command("tar", "-xf", "-", "-C", target)
+	cmd.Stdin = &buf
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(output))
+
+	out := filepath.Join(target, src, "foo")
+	data, err := os.ReadFile(filepath.Join(out, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	data, err = os.ReadFile(filepath.Join(out, strings.Repeat("long", 40)+".txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "long name", string(data))
+	link, err := os.Readlink(filepath.Join(out, "link"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a.txt", link)
+	fi, err := os.Stat(filepath.Join(out, "empty"))
+	assert.NoError(t, err)
+	assert.True(t, fi.IsDir())
+}
+
+func TestIsExcluded(t *testing.T) {
+	excludes := []string{"/foo/bar", "*.log", "/tmp/*.tmp"}
+
+	assert.True(t, isExcluded("/foo/bar", excludes))
+	assert.True(t, isExcluded("/foo/bar/dar", excludes))
+	assert.True(t, isExcluded("/var/app.log", excludes))
+	assert.True(t, isExcluded("/tmp/a.tmp", excludes))
+	assert.False(t, isExcluded("/foo/barbar", excludes))
+	assert.False(t, isExcluded("/var/app.txt", excludes))
+
+	excludes = []string{"/data/**/cache", "**/tmp/*.bin", "logs/*.log"}
+	assert.True(t, isExcluded("/data/cache", excludes))
+	assert.True(t, isExcluded("/data/a/b/cache", excludes))
+	assert.True(t, isExcluded("/data/a/cache/foo", excludes))
+	assert.True(t, isExcluded("/var/tmp/a.bin", excludes))
+	assert.True(t, isExcluded("/var/app/logs/a.log", excludes))
+	assert.False(t, isExcluded("/data/a/cached", excludes))
+	assert.False(t, isExcluded("/var/tmp/a/b.bin", excludes))
+	assert.False(t, isExcluded("/var/app/logs/a/b.log", excludes))
+}
+
+func TestExcluder(t *testing.T) {
+	e := newExcluder([]string{"/foo/bar", "/foo/[ab].txt", "*.log"})
+	assert.Equal(t, map[string]bool{"/foo/bar": true}, e.paths)
+	assert.Equal(t, []string{"/foo/[ab].txt", "*.log"}, e.patterns)
+
+	assert.True(t, e.match("/foo/bar"))
+	assert.True(t, e.match("/foo/bar/dar"))
+	assert.True(t, e.match("/foo/a.txt"))
+	assert.True(t, e.match("/foo/app.log"))
+	assert.False(t, e.match("/foo/barbar"))
+	assert.False(t, e.match("/"))
+}
@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/storage"
+)
+
+// Remote archive the directories on another server over SSH, gobackup is not required on it:
+//
+//	archive:
+//	  remote:
+//	    host: 192.168.1.2
+//	    port: 22
+//	    username: backup
+//	    private_key: ~/.ssh/id_rsa
+//	    before_script: systemctl stop app
+//	    after_script: systemctl start app
+//	  includes:
+//	    - /var/www
+//
+// The SSH options are the same as the SCP and SFTP storages. The includes are on the remote server, `tar` is
+// executed on it and the tar stream is appended into the local archive (with the dump path) by the native archive.
+// The excludes, exclude_if_present, exclude_caches, one_file_system and additional_arguments are passed to
+// the remote tar, GNU tar is required for them.
+
+// IsRemote returns true when the includes of archive are on the remote server
+func IsRemote(model config.ModelConfig) bool {
+	return model.Archive != nil && model.Archive.Sub("remote") != nil
+}
+
+// checkRemote returns an error if the options are not supported by the remote archive
+func checkRemote(model config.ModelConfig) error {
+	if mode(model) != "full" {
+		return fmt.Errorf("archive.mode %s is not supported with archive.remote", mode(model))
+	}
+	if len(model.Archive.GetString("max_file_size")) > 0 {
+		return fmt.Errorf("archive.max_file_size is not supported with archive.remote")
+	}
+
+	if filesFrom := model.Archive.GetString("files_from"); len(filesFrom) > 0 {
+		if _, err := readFilesFrom(filesFrom); err != nil {
+			return err
+		}
+	}
+	if len(Includes(model)) == 0 {
+		return fmt.Errorf("archive.includes have no config")
+	}
+
+	return nil
+}
+
+// remoteCommand returns the tar command on the remote server, which writes the archive to stdout
+func remoteCommand(model config.ModelConfig) string {
+	args := []string{"tar", "--ignore-failed-read", "-cP"}
+	args = append(args, model.Archive.GetStringSlice("additional_arguments")...)
+	for _, exclude := range cleanPaths(model.Archive.GetStringSlice("excludes")) {
+		args = append(args, "--exclude="+exclude)
+	}
+	for _, marker := range model.Archive.GetStringSlice("exclude_if_present") {
+		args = append(args, "--exclude-tag-all="+marker)
+	}
+	if model.Archive.GetBool("exclude_caches") {
+		args = append(args, "--exclude-caches-all")
+	}
+	if model.Archive.GetBool("one_file_system") {
+		args = append(args, "--one-file-system")
+	}
+	args = append(args, "-f", "-")
+	args = append(args, Includes(model)...)
+
+	for i, arg := range args {
+		args[i] = helper.ShellQuote(arg)
+	}
+
+	return strings.Join(args, " ")
+}
+
+// WriteRemote is Write with the includes of archive on the remote server, the local includes (e.g.: the dump path)
+// are written first, and then the entries of the remote tar stream.
+// The before_script and after_script of remote are executed on the remote server around the remote tar.
+func WriteRemote(w io.Writer, model config.ModelConfig, includes []string) error {
+	logger := logger.Tag("Archive")
+
+	remote := model.Archive.Sub("remote")
+	host := remote.GetString("host")
+
+	client, err := storage.DialSSH(remote)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if script := remote.GetString("before_script"); len(script) > 0 {
+		logger.Infof("Executing before_script on %s...", host)
+		if err := runRemoteScript(client, script); err != nil {
+			logger.Error(err)
+		}
+	}
+	if script := remote.GetString("after_script"); len(script) > 0 {
+		defer func() {
+			logger.Infof("Executing after_script on %s...", host)
+			if err := runRemoteScript(client, script); err != nil {
+				logger.Error(err)
+			}
+		}()
+	}
+
+	tw := tar.NewWriter(w)
+	if _, err := writeIncremental(tw, includes, nil, ""); err != nil {
+		return err
+	}
+
+	logger.Infof("=> remote %s includes %d rules", host, len(Includes(model)))
+	if err := copyRemote(client, tw, remoteCommand(model)); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// copyRemote run the tar command on the remote server, and copy the entries of its stdout into tw
+func copyRemote(client *ssh.Client, tw *tar.Writer, cmd string) error {
+	logger := logger.Tag("Archive")
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("Remote:", cmd)
+	if err := session.Start(cmd); err != nil {
+		return fmt.Errorf("failed to run remote tar: %v", err)
+	}
+
+	if err := appendTar(tw, stdout); err != nil {
+		return fmt.Errorf("remote tar: %v", err)
+	}
+	// Read the rest (padding of the record) to the end, the remote tar will exit after written
+	if _, err := io.Copy(io.Discard, stdout); err != nil {
+		return fmt.Errorf("remote tar: %v", err)
+	}
+
+	if err := session.Wait(); err != nil {
+		return fmt.Errorf("remote tar: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stderr.Len() > 0 {
+		logger.Warn("remote tar:", strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// appendTar copy the entries of the tar stream into tw
+func appendTar(tw *tar.Writer, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// runRemoteScript run the script on the remote server, the output will be logged
+func runRemoteScript(client *ssh.Client, script string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	out, err := session.CombinedOutput(script)
+	if len(out) > 0 {
+		logger.Tag("Archive").Info(strings.TrimSpace(string(out)))
+	}
+	if err != nil {
+		return fmt.Errorf("remote script failed: %v", err)
+	}
+
+	return nil
+}
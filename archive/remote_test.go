@@ -0,0 +1,175 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+)
+
+// startSSHServer start a SSH server which runs the commands by `sh -c` locally, returns the port
+func startSSHServer(t *testing.T) int {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.NoError(t, err)
+
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, serverConfig)
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func serveSSH(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+
+				var payload struct{ Command string }
+				_ = ssh.Unmarshal(req.Payload, &payload)
+				_ = req.Reply(true, nil)
+
+				cmd := exec.Command("sh", "-c", payload.Command)
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+				status := uint32(0)
+				if err := cmd.Run(); err != nil {
+					status = 1
+					var exitErr *exec.ExitError
+					if errors.As(err, &exitErr) {
+						status = uint32(exitErr.ExitCode())
+					}
+				}
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+func newRemoteModel(t *testing.T, port int) config.ModelConfig {
+	archive := viper.New()
+	archive.Set("remote", map[string]any{
+		"host":        "127.0.0.1",
+		"port":        strconv.Itoa(port),
+		"username":    "test",
+		"private_key": filepath.Join(t.TempDir(), "not-exist"),
+	})
+
+	return config.ModelConfig{Name: "remote", TempPath: t.TempDir(), Archive: archive}
+}
+
+func TestRemoteCommand(t *testing.T) {
+	model := newRemoteModel(t, 22)
+	model.Archive.Set("includes", []string{"/var/www/", "/etc/it's"})
+	model.Archive.Set("excludes", []string{"**/*.log"})
+	model.Archive.Set("exclude_if_present", []string{".nobackup"})
+	model.Archive.Set("exclude_caches", true)
+	model.Archive.Set("one_file_system", true)
+	model.Archive.Set("additional_arguments", []string{"-h"})
+
+	assert.True(t, IsRemote(model))
+	assert.False(t, IsRemote(config.ModelConfig{Archive: viper.New()}))
+	assert.True(t, IsNative(model))
+	assert.Equal(t, `'tar' '--ignore-failed-read' '-cP' '-h' '--exclude=**/*.log' '--exclude-tag-all=.nobackup' `+
+		`'--exclude-caches-all' '--one-file-system' '-f' '-' '/var/www' '/etc/it'\''s'`, remoteCommand(model))
+}
+
+func TestPrepare_remote(t *testing.T) {
+	model := newRemoteModel(t, 22)
+	assert.EqualError(t, Prepare(model), "archive.includes have no config")
+
+	model.Archive.Set("includes", []string{"/var/www"})
+	model.Archive.Set("exclude_if_present", []string{".nobackup"})
+	assert.NoError(t, Prepare(model))
+	// The filters are not scanned locally
+	assert.Equal(t, "", ExcludesFrom(model))
+
+	model.Archive.Set("max_file_size", "1M")
+	assert.EqualError(t, Prepare(model), "archive.max_file_size is not supported with archive.remote")
+
+	model.Archive.Set("max_file_size", "")
+	model.Archive.Set("mode", "incremental")
+	assert.EqualError(t, Prepare(model), "archive.mode incremental is not supported with archive.remote")
+}
+
+func TestWriteRemote(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar is not found")
+	}
+
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"a.txt":          "a",
+		"b.log":          "b",
+		"skip/.nobackup": "",
+		"skip/c.txt":     "c",
+	})
+	dumpPath := t.TempDir()
+	writeFiles(t, dumpPath, map[string]string{"mysql/db1/db1.sql": "sql"})
+	scripts := t.TempDir()
+
+	model := newRemoteModel(t, startSSHServer(t))
+	remote := model.Archive.Get("remote").(map[string]any)
+	remote["before_script"] = "echo before > " + filepath.Join(scripts, "before")
+	remote["after_script"] = "echo after > " + filepath.Join(scripts, "after")
+	model.Archive.Set("remote", remote)
+	model.Archive.Set("includes", []string{src})
+	model.Archive.Set("excludes", []string{"*.log"})
+	model.Archive.Set("exclude_if_present", []string{".nobackup"})
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteRemote(&buf, model, []string{dumpPath}))
+	assert.Equal(t, []string{"db1.sql", "a.txt"}, tarFiles(t, &buf))
+
+	assert.True(t, helper.IsExistsPath(filepath.Join(scripts, "before")))
+	assert.True(t, helper.IsExistsPath(filepath.Join(scripts, "after")))
+
+	// The remote tar failed
+	model.Archive.Set("additional_arguments", []string{"--not-exist-option"})
+	err := WriteRemote(&bytes.Buffer{}, model, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "remote tar")
+}
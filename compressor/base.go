@@ -1,14 +1,21 @@
 package compressor
 
 import (
+	"archive/tar"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
 
+	"github.com/itgcloud/gobackup/archive"
 	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
 	"github.com/itgcloud/gobackup/logger"
 )
 
@@ -17,13 +24,18 @@ type Base struct {
 	name            string
 	ext             string
 	parallelProgram string
+	native          bool
 	model           config.ModelConfig
 	viper           *viper.Viper
+	// dumps streams the dumps of databases into the archive before the files
+	dumps func(w *archive.DumpWriter) error
 }
 
 // Compressor
 type Compressor interface {
 	perform() (archivePath string, err error)
+	// stream the archive without writing to the disk
+	stream() (io.ReadCloser, error)
 }
 
 func (c *Base) archiveFilePath(ext string) string {
@@ -37,7 +49,7 @@ func Run(model config.ModelConfig) (string, error) {
 		return "", err
 	}
 
-	c := &Tar{base}
+	c := newCompressor(base)
 
 	logger := logger.Tag("Compressor")
 	logger.Info("=> Compress | " + model.CompressWith.Type)
@@ -56,6 +68,130 @@ func Run(model config.ModelConfig) (string, error) {
 	return archivePath, nil
 }
 
+// Stream compressor, return the archive file name and the reader of archive.
+// When `dumps` is not nil, it is called to stream the dumps of databases into the archive by the native compressor,
+// see CanStreamDumps.
+func Stream(model config.ModelConfig, dumps func(w *archive.DumpWriter) error) (string, io.ReadCloser, error) {
+	base, err := newBase(model)
+	if err != nil {
+		return "", nil, err
+	}
+	if dumps != nil {
+		base.dumps = dumps
+		base.native = true
+	}
+
+	c := newCompressor(base)
+
+	logger := logger.Tag("Compressor")
+	logger.Info("=> Compress | " + model.CompressWith.Type + " (stream)")
+
+	if err := changeWorkDir(model); err != nil {
+		return "", nil, err
+	}
+
+	reader, err := c.stream()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return filepath.Base(base.archiveFilePath(base.ext)), reader, nil
+}
+
+// CanStreamDumps returns true when the dumps of databases can be streamed into the archive in `streaming` mode.
+// The native compressor is required to write them, and a failed dump can not be taken back from the stream,
+// so they are written into the dump path first with `continue_on_error`.
+func CanStreamDumps(model config.ModelConfig) bool {
+	if !model.Streaming || model.ContinueOnError || len(model.Databases) == 0 {
+		return false
+	}
+
+	base, err := newBase(model)
+	if err != nil {
+		return false
+	}
+
+	return slices.Contains(nativeExts, base.ext)
+}
+
+func newCompressor(base *Base) Compressor {
+	if base.native {
+		return &Native{base}
+	}
+
+	return &Tar{base}
+}
+
+// Extract the archive into `targetDir`, the compression will be detected by tar.
+// If `tar` is not found or the archive is lz4, it will be extracted by Go.
+func Extract(archivePath, targetDir string) error {
+	logger := logger.Tag("Compressor")
+
+	if err := helper.MkdirP(targetDir); err != nil {
+		return err
+	}
+
+	logger.Info("=> Extract |", archivePath)
+	if _, err := exec.LookPath("tar"); err != nil || strings.HasSuffix(archivePath, ".tar.lz4") {
+		if err := extractNative(archivePath, targetDir); err != nil {
+			return fmt.Errorf("extract %s failed: %v", archivePath, err)
+		}
+	} else if _, err := helper.Exec("tar", "-xf", archivePath, "-C", targetDir); err != nil {
+		return fmt.Errorf("extract %s failed: %v", archivePath, err)
+	}
+
+	logger.Info("->", targetDir)
+	return nil
+}
+
+// List read through the archive like `tar -t` without extracting, to verify the compression and tar format.
+// All entries will be read, so the checksum of compression will be checked. Returns the number of entries.
+func List(reader io.Reader) (int, error) {
+	zr, err := NewReader(reader)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+
+	entries := 0
+	tr := tar.NewReader(zr)
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return entries, err
+		}
+
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return entries, err
+		}
+		entries++
+	}
+
+	// Read the rest (padding) to the end of compression stream
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+func extractNative(archivePath, targetDir string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return archive.Extract(reader, targetDir)
+}
+
 func newBase(model config.ModelConfig) (*Base, error) {
 	base := &Base{
 		name:  model.Name,
@@ -84,8 +220,14 @@ func newBase(model config.ModelConfig) (*Base, error) {
 		parallelProgram = "pixz"
 	case "zst", "tzst", "tar.zst":
 		ext = ".tar.zst"
+	case "lz4", "tar.lz4":
+		// tar has no builtin lz4 support, always use native compressor
+		ext = ".tar.lz4"
 	case "tar":
 		ext = ".tar"
+	case "repository":
+		// The chunks are compressed by the repository of storages
+		ext = ".tar"
 	case "":
 		ext = ".tar"
 		model.CompressWith.Type = "tar"
@@ -98,6 +240,7 @@ func newBase(model config.ModelConfig) (*Base, error) {
 
 	base.ext = ext
 	base.parallelProgram = parallelProgram
+	base.native = archive.IsNative(model) || ext == ".tar.lz4"
 
 	return base, nil
 }
@@ -107,6 +250,11 @@ func changeWorkDir(model config.ModelConfig) error {
 		return nil
 	}
 
+	// The dump path has not been created when the dumps are streamed
+	if err := helper.MkdirP(model.DumpPath); err != nil {
+		return err
+	}
+
 	if err := os.Chdir(filepath.Join(model.DumpPath, "../")); err != nil {
 		return fmt.Errorf("chdir to dump path: %s: %w", model.DumpPath, err)
 	}
@@ -1,7 +1,11 @@
 package compressor
 
 import (
+	"archive/tar"
+	"io"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -9,6 +13,7 @@ import (
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/itgcloud/gobackup/archive"
 	"github.com/itgcloud/gobackup/config"
 )
 
@@ -49,3 +54,87 @@ func TestBaseInterface(t *testing.T) {
 	assert.Equal(t, result, "aaa")
 	assert.Nil(t, err)
 }
+
+func TestCanStreamDumps(t *testing.T) {
+	model := config.ModelConfig{
+		Name:      "demo",
+		Viper:     viper.New(),
+		Streaming: true,
+		Databases: map[string]config.SubConfig{"db1": {Name: "db1", Type: "mysql"}},
+	}
+	assert.True(t, CanStreamDumps(model))
+
+	model.CompressWith.Type = "zst"
+	assert.True(t, CanStreamDumps(model))
+
+	// Not supported by native compressor
+	model.CompressWith.Type = "lzo"
+	assert.False(t, CanStreamDumps(model))
+
+	model.CompressWith.Type = "gz"
+	model.ContinueOnError = true
+	assert.False(t, CanStreamDumps(model))
+
+	model.ContinueOnError = false
+	model.Streaming = false
+	assert.False(t, CanStreamDumps(model))
+
+	model.Streaming = true
+	model.Databases = nil
+	assert.False(t, CanStreamDumps(model))
+}
+
+func TestStream_dumps(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	defer os.Chdir(wd)
+
+	tempPath := t.TempDir()
+	dumpPath := filepath.Join(tempPath, "demo")
+	dbPath := filepath.Join(dumpPath, "mysql", "db1")
+	assert.NoError(t, os.MkdirAll(dbPath, 0750))
+
+	model := config.ModelConfig{
+		Name:         "demo",
+		Viper:        viper.New(),
+		Streaming:    true,
+		TempPath:     tempPath,
+		DumpPath:     dumpPath,
+		CompressWith: config.SubConfig{Type: "gz", Viper: viper.New()},
+		Databases:    map[string]config.SubConfig{"db1": {Name: "db1", Type: "mysql"}},
+	}
+
+	name, reader, err := Stream(model, func(w *archive.DumpWriter) error {
+		dump := w.Create(filepath.Join(dbPath, "app.sql"))
+		if _, err := dump.Write([]byte("dump of app")); err != nil {
+			return err
+		}
+		if err := dump.Close(); err != nil {
+			return err
+		}
+		// The files written by the dumps are archived after them
+		return os.WriteFile(filepath.Join(dbPath, "gobackup_backup.json"), []byte("{}"), 0640)
+	})
+	assert.NoError(t, err)
+	defer reader.Close()
+	assert.True(t, strings.HasSuffix(name, ".tar.gz"))
+
+	zr, err := NewReader(reader)
+	assert.NoError(t, err)
+	defer zr.Close()
+
+	entries := map[string]string{}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		entries[strings.TrimPrefix(hdr.Name, filepath.ToSlash(dbPath)+"/")] = string(data)
+	}
+	assert.Equal(t, "dump of app", entries["app.sql"])
+	assert.Equal(t, "{}", entries["gobackup_backup.json"])
+}
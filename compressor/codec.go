@@ -0,0 +1,117 @@
+package compressor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// The dictionary size of xz presets -0 ~ -9
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// nativeExts are the archive exts supported by the native compressor
+var nativeExts = []string{".tar", ".tar.gz", ".tar.bz2", ".tar.xz", ".tar.zst", ".tar.lz4"}
+
+// newCodecWriter returns a compress writer by archive ext.
+//
+// level: 0 means the default level of the codec
+// workers: the concurrency for gzip, zstd and lz4
+func newCodecWriter(w io.Writer, ext string, level, workers int) (io.WriteCloser, error) {
+	switch ext {
+	case ".tar":
+		return nopWriteCloser{w}, nil
+	case ".tar.gz":
+		if level == 0 {
+			level = pgzip.DefaultCompression
+		}
+		zw, err := pgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		if err := zw.SetConcurrency(1<<20, workers); err != nil {
+			return nil, err
+		}
+		return zw, nil
+	case ".tar.bz2":
+		return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+	case ".tar.xz":
+		config := xz.WriterConfig{}
+		if level > 0 {
+			config.DictCap = xzDictCaps[min(level, len(xzDictCaps)-1)]
+		}
+		return config.NewWriter(w)
+	case ".tar.zst":
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(workers)}
+		if level > 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	case ".tar.lz4":
+		zw := lz4.NewWriter(w)
+		opts := []lz4.Option{lz4.ConcurrencyOption(workers)}
+		if level > 0 {
+			opts = append(opts, lz4.CompressionLevelOption(lz4.CompressionLevel(1<<(8+min(level, 9)))))
+		}
+		if err := zw.Apply(opts...); err != nil {
+			return nil, err
+		}
+		return zw, nil
+	}
+
+	return nil, fmt.Errorf("compress type %s is not supported by native compressor", ext)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	lz4Magic   = []byte{0x04, 0x22, 0x4d, 0x18}
+)
+
+// NewReader returns a decompress reader, the compression is detected by the magic bytes.
+// The uncompressed tar will be returned as is.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzip2.NewReader(br, nil)
+	case bytes.HasPrefix(magic, xzMagic):
+		zr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(zr), nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, lz4Magic):
+		return io.NopCloser(lz4.NewReader(br)), nil
+	}
+
+	return io.NopCloser(br), nil
+}
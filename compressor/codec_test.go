@@ -0,0 +1,54 @@
+package compressor
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestCodec(t *testing.T) {
+	data := bytes.Repeat([]byte("gobackup native codec\n"), 10000)
+
+	for _, ext := range []string{".tar", ".tar.gz", ".tar.bz2", ".tar.xz", ".tar.zst", ".tar.lz4"} {
+		for _, level := range []int{0, 9} {
+			var buf bytes.Buffer
+			w, err := newCodecWriter(&buf, ext, level, 2)
+			assert.NoError(t, err)
+			_, err = w.Write(data)
+			assert.NoError(t, err)
+			assert.NoError(t, w.Close())
+
+			r, err := NewReader(&buf)
+			assert.NoError(t, err)
+			out, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.NoError(t, r.Close())
+			assert.Equal(t, len(data), len(out), ext)
+			assert.True(t, bytes.Equal(data, out), ext)
+		}
+	}
+
+	_, err := newCodecWriter(io.Discard, ".tar.lzo", 0, 1)
+	assert.EqualError(t, err, "compress type .tar.lzo is not supported by native compressor")
+}
+
+func TestCodec_compatible(t *testing.T) {
+	if _, err := exec.LookPath("gzip"); err != nil {
+		t.Skip("gzip not found")
+	}
+
+	var buf bytes.Buffer
+	w, err := newCodecWriter(&buf, ".tar.gz", 6, 4)
+	assert.NoError(t, err)
+	_, _ = w.Write([]byte("hello world"))
+	assert.NoError(t, w.Close())
+
+	cmd := exec.Command("gzip", "-dc")
+	cmd.Stdin = &buf
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(out))
+}
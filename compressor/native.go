@@ -0,0 +1,93 @@
+package compressor
+
+import (
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/itgcloud/gobackup/archive"
+	"github.com/itgcloud/gobackup/logger"
+)
+
+// Native compressor archive and compress files by Go, without `tar` and other compress programs.
+//
+// type: gz, bz2, xz, zst, lz4, tar
+// level: 1 - 9 (zstd: 1 - 22), default: the default level of each codec
+// workers: the concurrency for gz, zst and lz4, default: number of CPUs
+type Native struct {
+	*Base
+}
+
+func (n *Native) perform() (string, error) {
+	filePath := n.archiveFilePath(n.ext)
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := n.write(f); err != nil {
+		return "", err
+	}
+
+	return filePath, f.Close()
+}
+
+func (n *Native) stream() (io.ReadCloser, error) {
+	if err := (&Tar{n.Base}).checkIncludes(); err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(n.write(pw))
+	}()
+
+	return pr, nil
+}
+
+func (n *Native) write(w io.Writer) error {
+	logger := logger.Tag("Compressor")
+
+	tar := &Tar{n.Base}
+	if err := tar.checkIncludes(); err != nil {
+		return err
+	}
+
+	if len(tar.additionalArgs()) > 0 && !archive.IsRemote(n.model) {
+		logger.Warn("archive.additional_arguments is ignored by native compressor")
+	}
+
+	level, workers := 0, runtime.NumCPU()
+	if n.viper != nil {
+		level = n.viper.GetInt("level")
+		if n.viper.IsSet("workers") {
+			workers = max(n.viper.GetInt("workers"), 1)
+		}
+	}
+
+	zw, err := newCodecWriter(w, n.ext, level, workers)
+	if err != nil {
+		return err
+	}
+
+	if n.dumps != nil {
+		if err := n.dumps(archive.NewDumpWriter(zw)); err != nil {
+			zw.Close()
+			return err
+		}
+	}
+
+	if archive.IsRemote(n.model) {
+		err = archive.WriteRemote(zw, n.model, tar.includesArgs())
+	} else {
+		err = archive.WriteIncremental(zw, tar.includesArgs(), archive.Excludes(n.model), archive.Snapshot(n.model, true))
+	}
+	if err != nil {
+		zw.Close()
+		return err
+	}
+
+	return zw.Close()
+}
@@ -2,9 +2,11 @@ package compressor
 
 import (
 	"fmt"
+	"io"
 	"os/exec"
 	"path/filepath"
 
+	"github.com/itgcloud/gobackup/archive"
 	"github.com/itgcloud/gobackup/helper"
 	"github.com/itgcloud/gobackup/logger"
 )
@@ -26,6 +28,15 @@ func (tar *Tar) perform() (string, error) {
 	return filePath, err
 }
 
+func (tar *Tar) stream() (io.ReadCloser, error) {
+	opts, err := tar.options("-")
+	if err != nil {
+		return nil, err
+	}
+
+	return helper.ExecStream(nil, "tar", opts...)
+}
+
 func (tar *Tar) options(archiveFilePath string) ([]string, error) {
 	var opts []string
 
@@ -33,21 +44,20 @@ func (tar *Tar) options(archiveFilePath string) ([]string, error) {
 		opts = append(opts, "--ignore-failed-read")
 	}
 
-	compressArgs := []string{"-a"}
-	if len(tar.parallelProgram) > 0 {
-		if path, err := exec.LookPath(tar.parallelProgram); err == nil {
-			compressArgs = []string{"--use-compress-program", path}
-		}
-	}
-	opts = append(opts, compressArgs...)
+	opts = append(opts, tar.compressArgs(archiveFilePath)...)
 
 	if err := tar.checkIncludes(); err != nil {
 		return nil, err
 	}
 
+	if snapshot := archive.Snapshot(tar.model, false); len(snapshot) > 0 {
+		opts = append(opts, "--listed-incremental="+snapshot)
+	}
+
 	opts = append(opts, "-cP")
 	opts = append(opts, tar.additionalArgs()...)
 	opts = append(opts, tar.excludesArgs()...)
+	opts = append(opts, archive.ExcludesFromArgs(tar.model)...)
 
 	opts = append(opts, "-f")
 	opts = append(opts, archiveFilePath)
@@ -56,6 +66,41 @@ func (tar *Tar) options(archiveFilePath string) ([]string, error) {
 	return opts, nil
 }
 
+// compressArgs use the parallel program if it exists, otherwise tar will detect the compression by file suffix.
+// When write to stdout, the compression can not be detected, so use the explicit option.
+func (tar *Tar) compressArgs(archiveFilePath string) []string {
+	if len(tar.parallelProgram) > 0 {
+		if path, err := exec.LookPath(tar.parallelProgram); err == nil {
+			return []string{"--use-compress-program", path}
+		}
+	}
+
+	if archiveFilePath != "-" {
+		return []string{"-a"}
+	}
+
+	switch tar.ext {
+	case ".tar.gz":
+		return []string{"-z"}
+	case ".tar.Z":
+		return []string{"-Z"}
+	case ".tar.bz2":
+		return []string{"-j"}
+	case ".tar.lz":
+		return []string{"--lzip"}
+	case ".tar.lzma":
+		return []string{"--lzma"}
+	case ".tar.lzo":
+		return []string{"--lzop"}
+	case ".tar.xz":
+		return []string{"-J"}
+	case ".tar.zst":
+		return []string{"--zstd"}
+	}
+
+	return []string{}
+}
+
 func (tar *Tar) additionalArgs() []string {
 	if tar.model.Archive == nil {
 		return []string{}
@@ -82,7 +127,7 @@ func (tar *Tar) excludesArgs() []string {
 
 func (tar *Tar) checkIncludes() error {
 	if tar.model.Databases == nil {
-		if len(tar.model.Archive.GetStringSlice("includes")) == 0 {
+		if len(archive.Includes(tar.model)) == 0 {
 			return fmt.Errorf("archive.includes have no config")
 		}
 	}
@@ -94,6 +139,27 @@ func (tar *Tar) includesArgs() []string {
 	logger := logger.Tag("Compressor")
 	var includes []string
 
+	// The includes of archive are on the remote server, only the dump path is local
+	if archive.IsRemote(tar.model) {
+		if len(tar.model.Databases) > 0 {
+			includes = cleanPaths([]string{tar.model.DumpPath})
+		}
+		return includes
+	}
+
+	// The archive.tar will not be created in streaming, so include both dump path and archive includes.
+	if tar.model.Streaming {
+		if len(tar.model.Databases) > 0 {
+			includes = append(includes, tar.model.DumpPath)
+		}
+		includes = append(includes, archive.Includes(tar.model)...)
+		includes = cleanPaths(includes)
+
+		logger.Info("=> includes", len(includes), "rules")
+
+		return includes
+	}
+
 	if tar.model.Archive == nil && tar.model.Databases != nil {
 		includes = []string{tar.model.DumpPath}
 		includes = cleanPaths(includes)
@@ -101,8 +167,7 @@ func (tar *Tar) includesArgs() []string {
 		return includes
 	}
 
-	includes = tar.model.Archive.GetStringSlice("includes")
-	includes = cleanPaths(includes)
+	includes = archive.Includes(tar.model)
 
 	logger.Info("=> includes", len(includes), "rules")
 
@@ -1,6 +1,7 @@
 package compressor
 
 import (
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -9,6 +10,7 @@ import (
 
 	"github.com/itgcloud/gobackup/config"
 	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/manifest"
 )
 
 func TestTar_options(t *testing.T) {
@@ -118,3 +120,18 @@ func TestTar_options(t *testing.T) {
 	}
 
 }
+
+func TestTar_optionsIncremental(t *testing.T) {
+	model := config.ModelConfig{
+		TempPath: t.TempDir(),
+		DumpPath: "~/work/dir",
+		Archive:  viper.New(),
+	}
+	model.Archive.Set("includes", []string{"/foo"})
+	assert.NoError(t, manifest.WriteArchiveLevel(model.TempPath, manifest.ArchiveLevel{Level: "incremental", Base: "demo-1"}))
+
+	tar := &Tar{&Base{model: model}}
+	opts, err := tar.options("~/work/dir/archive.tar")
+	assert.NoError(t, err)
+	assert.Contains(t, strings.Join(opts, " "), "--listed-incremental="+filepath.Join(model.TempPath, "snapshot.snar")+" -cP")
+}
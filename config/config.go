@@ -21,6 +21,8 @@ var (
 	Exist bool
 	// Models configs
 	Models []ModelConfig
+	// Version of gobackup, it will be set by main
+	Version string = "master"
 	// gobackup base dir
 	GoBackupDir string = getGoBackupDir()
 
@@ -43,6 +45,9 @@ type WebConfig struct {
 	Password       string
 	BasePath       string
 	DisablePerform bool
+	// DownloadMode is how the files are downloaded in Web UI, `redirect` to the download URL of the storage,
+	// or `proxy` to stream the file from the storage through gobackup
+	DownloadMode string
 }
 
 type ScheduleConfig struct {
@@ -91,6 +96,15 @@ type ModelConfig struct {
 	Viper          *viper.Viper
 	BeforeScript   string
 	AfterScript    string
+	// Streaming chain tar, compress, encrypt and split as stream into storages without intermediate files
+	Streaming bool
+	// MaxParallelStorages is the max number of storages to upload at the same time, 0 is unlimited
+	MaxParallelStorages int
+	// MaxParallelDumps is the max number of databases to dump at the same time, default is 1, 0 is unlimited
+	MaxParallelDumps int
+	// ContinueOnError continue to dump the other databases and store the backup when some databases failed,
+	// the errors will be reported after the backup
+	ContinueOnError bool
 }
 
 func getGoBackupDir() string {
@@ -231,12 +245,14 @@ func loadConfig() error {
 	viper.SetDefault("web.port", 2703)
 	viper.SetDefault("web.base_path", "")
 	viper.SetDefault("web.disable_perform", false)
+	viper.SetDefault("web.download_mode", "redirect")
 	Web.Host = viper.GetString("web.host")
 	Web.Port = viper.GetString("web.port")
 	Web.Username = viper.GetString("web.username")
 	Web.Password = viper.GetString("web.password")
 	Web.BasePath = viper.GetString("web.base_path")
 	Web.DisablePerform = viper.GetBool("web.disable_perform")
+	Web.DownloadMode = viper.GetString("web.download_mode")
 
 	UpdatedAt = time.Now()
 	logger.Infof("Config loaded, found %d models.", len(Models))
@@ -273,8 +289,18 @@ func loadModel(key string) (ModelConfig, error) {
 	model.Archive = model.Viper.Sub("archive")
 	model.Splitter = model.Viper.Sub("split_with")
 
+	// The chunks of repository are deduplicated by the content, so the archive can not be encrypted or split
+	if model.CompressWith.Type == "repository" && (len(model.EncryptWith.Type) > 0 || model.Splitter != nil) {
+		return ModelConfig{}, fmt.Errorf("compress_with repository does not support encrypt_with and split_with in model %s", model.Name)
+	}
+
 	model.BeforeScript = model.Viper.GetString("before_script")
 	model.AfterScript = model.Viper.GetString("after_script")
+	model.Streaming = model.Viper.GetBool("streaming")
+	model.MaxParallelStorages = model.Viper.GetInt("max_parallel_storages")
+	model.Viper.SetDefault("max_parallel_dumps", 1)
+	model.MaxParallelDumps = model.Viper.GetInt("max_parallel_dumps")
+	model.ContinueOnError = model.Viper.GetBool("continue_on_error")
 
 	loadScheduleConfig(&model)
 	loadDatabasesConfig(&model)
@@ -2,15 +2,24 @@ package database
 
 import (
 	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/google/shlex"
 	"github.com/spf13/viper"
 
+	"github.com/itgcloud/gobackup/archive"
 	"github.com/itgcloud/gobackup/config"
 	"github.com/itgcloud/gobackup/helper"
 	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/manifest"
 )
 
 // Base database
@@ -20,6 +29,8 @@ type Base struct {
 	viper    *viper.Viper
 	name     string
 	dumpPath string
+	// dumps streams the stdout of dump tools into the archive in `streaming` mode
+	dumps *archive.DumpWriter
 }
 
 // Database interface
@@ -30,6 +41,12 @@ type Database interface {
 	perform() error
 }
 
+// restorer is implemented by the databases which are able to load the dump back
+type restorer interface {
+	// Restore from the dump files in dumpPath
+	restore(dumpPath string) error
+}
+
 func newBase(model config.ModelConfig, dbConfig config.SubConfig) (base Base) {
 	base = Base{
 		model:    model,
@@ -45,6 +62,128 @@ func newBase(model config.ModelConfig, dbConfig config.SubConfig) (base Base) {
 	return
 }
 
+// splitArgs split the `args` config into argv as shell does, the quoted values are kept as one arg
+func splitArgs(args string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	argv, err := shlex.Split(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid args %q: %v", args, err)
+	}
+
+	return argv, nil
+}
+
+// databaseFilter select the databases to dump in "all databases" mode (`database` is empty)
+// by the shell glob patterns of `include_databases` and `exclude_databases`, e.g. `app_*`
+type databaseFilter struct {
+	includes []string
+	excludes []string
+}
+
+func newDatabaseFilter(v *viper.Viper, defaultExcludes ...string) (databaseFilter, error) {
+	f := databaseFilter{
+		includes: v.GetStringSlice("include_databases"),
+		excludes: append(defaultExcludes, v.GetStringSlice("exclude_databases")...),
+	}
+
+	for _, pattern := range append(f.includes, f.excludes...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return f, fmt.Errorf("invalid database pattern %q: %v", pattern, err)
+		}
+	}
+
+	return f, nil
+}
+
+// filter the lines of database names, all databases are included when `include_databases` is empty
+func (f databaseFilter) filter(out string) (databases []string) {
+	for _, name := range strings.Split(out, "\n") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+
+		if len(f.includes) > 0 && !matchAny(f.includes, name) {
+			continue
+		}
+		if matchAny(f.excludes, name) {
+			continue
+		}
+
+		databases = append(databases, name)
+	}
+
+	return databases
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dumpFileName of the database in "all databases" mode, the name is escaped by `url.PathEscape`
+// so the path separators can not be in it, and it can be reverted by `url.PathUnescape`.
+func dumpFileName(database, ext string) string {
+	return url.PathEscape(database) + ext
+}
+
+// dumpTo run the dump command and stream its stdout into `filename` in the dump path,
+// the incomplete dump file will be removed when the command failed.
+//
+// In `streaming` mode, the stdout is streamed into the archive instead, and recorded in `gobackup_streamed.json`.
+func (base Base) dumpTo(filename string, cmd helper.Command) (string, error) {
+	dumpFilePath := path.Join(base.dumpPath, filename)
+	if base.dumps != nil {
+		return dumpFilePath, base.streamTo(dumpFilePath, filename, cmd)
+	}
+
+	if err := helper.MkdirP(path.Dir(dumpFilePath)); err != nil {
+		return "", err
+	}
+
+	f, err := os.Create(dumpFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create dump file %s: %v", dumpFilePath, err)
+	}
+
+	cmd.Stdout = f
+	if _, err := cmd.Run(); err != nil {
+		f.Close()
+		os.Remove(dumpFilePath)
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	return dumpFilePath, nil
+}
+
+// streamTo run the dump command and stream its stdout into the archive,
+// the incomplete dump in the archive will fail the stream, so the package will not be stored.
+func (base Base) streamTo(dumpFilePath, filename string, cmd helper.Command) error {
+	dump := base.dumps.Create(dumpFilePath)
+	cmd.Stdout = dump
+	if _, err := cmd.Run(); err != nil {
+		return err
+	}
+
+	if err := dump.Close(); err != nil {
+		return err
+	}
+
+	return manifest.AddStreamed(base.dumpPath, manifest.StreamedDump{Name: filename, Size: dump.Size(), Parts: dump.Parts()})
+}
+
 func runHook(action, script string) error {
 	logger := logger.Tag("Database")
 	if len(script) == 0 {
@@ -75,13 +214,8 @@ func runHook(action, script string) error {
 	return nil
 }
 
-// New - initialize Database
-func runModel(model config.ModelConfig, dbConfig config.SubConfig) (err error) {
-	logger := logger.Tag("Database")
-
-	base := newBase(model, dbConfig)
-	var db Database
-	switch dbConfig.Type {
+func newDatabase(base Base) (db Database) {
+	switch base.dbConfig.Type {
 	case "mysql":
 		db = &MySQL{Base: base}
 	case "mariadb":
@@ -100,7 +234,19 @@ func runModel(model config.ModelConfig, dbConfig config.SubConfig) (err error) {
 		db = &InfluxDB2{Base: base}
 	case "etcd":
 		db = &Etcd{Base: base}
-	default:
+	}
+
+	return
+}
+
+// New - initialize Database
+func runModel(model config.ModelConfig, dbConfig config.SubConfig, dumps *archive.DumpWriter) (err error) {
+	logger := logger.Tag("Database")
+
+	base := newBase(model, dbConfig)
+	base.dumps = dumps
+	db := newDatabase(base)
+	if db == nil {
 		logger.Warn(fmt.Errorf("model: %s databases.%s config `type: %s`, but is not implement", model.Name, dbConfig.Name, dbConfig.Type))
 		return
 	}
@@ -154,18 +300,186 @@ func runModel(model config.ModelConfig, dbConfig config.SubConfig) (err error) {
 	return
 }
 
-// Run databases
+// runDatabase is replaceable for testing
+var runDatabase = runModel
+
+// Run databases, `max_parallel_dumps` of them are dumped at the same time.
+//
+// Unless `continue_on_error` is enabled, no more database will be started after a dump failed.
+// The errors of all databases are returned together, and the dump files of the failed databases are removed.
 func Run(model config.ModelConfig) error {
+	return run(model, nil)
+}
+
+// RunStream is Run in `streaming` mode, the stdout of dump tools are streamed into the archive by `dumps`,
+// the other dumps (e.g. Redis, SQLite) are still written into the dump path.
+func RunStream(model config.ModelConfig, dumps *archive.DumpWriter) error {
+	return run(model, dumps)
+}
+
+func run(model config.ModelConfig, dumps *archive.DumpWriter) error {
+	logger := logger.Tag("Database")
+
+	n := len(model.Databases)
+	if n == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, n)
+	for name := range model.Databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parallel := model.MaxParallelDumps
+	if parallel <= 0 || parallel > n {
+		parallel = n
+	}
+
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	results := make([]error, n)
+	sem := make(chan struct{}, parallel)
+
+	started := 0
+	for i, name := range names {
+		sem <- struct{}{}
+		if failed.Load() && !model.ContinueOnError {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		started++
+		go func(i int, dbConfig config.SubConfig) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := runDatabase(model, dbConfig, dumps); err != nil {
+				failed.Store(true)
+				results[i] = err
+
+				dumpPath := path.Join(model.DumpPath, dbConfig.Type, dbConfig.Name)
+				if err := os.RemoveAll(dumpPath); err != nil {
+					logger.Errorf("Failed to remove dump path %s: %v", dumpPath, err)
+				}
+			}
+		}(i, model.Databases[name])
+	}
+	wg.Wait()
+
+	if started < n {
+		logger.Warnf("Skip %d databases because of the dump error, enable `continue_on_error` to dump all of them", n-started)
+	}
+
+	var errors []error
+	for i, err := range results {
+		if err != nil {
+			if n == 1 {
+				return err
+			}
+			errors = append(errors, fmt.Errorf("databases.%s: %v", names[i], err))
+		}
+	}
+
+	if len(errors) != 0 {
+		return fmt.Errorf("Database errors: %v", errors)
+	}
+
+	return nil
+}
+
+// Restore databases from the dump files which have been extracted into `restorePath`
+func Restore(model config.ModelConfig, restorePath string) error {
+	logger := logger.Tag("Database")
+
 	if len(model.Databases) == 0 {
 		return nil
 	}
 
-	for _, dbCfg := range model.Databases {
-		err := runModel(model, dbCfg)
+	names := make([]string, 0, len(model.Databases))
+	for name := range model.Databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errors []error
+	for _, name := range names {
+		dbConfig := model.Databases[name]
+
+		db := newDatabase(newBase(model, dbConfig))
+		r, ok := db.(restorer)
+		if !ok {
+			logger.Warnf("databases.%s `type: %s` is not support restore, skip it", dbConfig.Name, dbConfig.Type)
+			continue
+		}
+
+		dumpPath, err := findDumpPath(restorePath, filepath.Join(model.Name, dbConfig.Type, dbConfig.Name))
 		if err != nil {
-			return err
+			errors = append(errors, fmt.Errorf("databases.%s: %v", dbConfig.Name, err))
+			continue
+		}
+
+		logger.Infof("=> restore | %v: %v", dbConfig.Type, dbConfig.Name)
+		if err := joinStreamed(dumpPath); err != nil {
+			errors = append(errors, fmt.Errorf("databases.%s: %v", dbConfig.Name, err))
+			continue
+		}
+		if err := db.init(); err != nil {
+			errors = append(errors, fmt.Errorf("databases.%s: %v", dbConfig.Name, err))
+			continue
+		}
+
+		if err := r.restore(dumpPath); err != nil {
+			errors = append(errors, fmt.Errorf("databases.%s: %v", dbConfig.Name, err))
+			continue
+		}
+		logger.Info("Restore succeeded")
+	}
+
+	if len(errors) != 0 {
+		return fmt.Errorf("Restore errors: %v", errors)
+	}
+
+	return nil
+}
+
+// joinStreamed join the parts of the dumps which were streamed into the archive
+func joinStreamed(dumpPath string) error {
+	for _, dump := range manifest.ReadStreamed(dumpPath) {
+		if dump.Parts == 0 {
+			continue
+		}
+
+		if err := archive.JoinDumpParts(filepath.Join(dumpPath, dump.Name), dump.Parts); err != nil {
+			return fmt.Errorf("join the parts of %s failed: %v", dump.Name, err)
 		}
 	}
 
 	return nil
 }
+
+// findDumpPath find the directory ends with `suffix` in the extracted files
+func findDumpPath(restorePath, suffix string) (dumpPath string, err error) {
+	err = filepath.WalkDir(restorePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && strings.HasSuffix(p, string(filepath.Separator)+suffix) {
+			dumpPath = p
+			return filepath.SkipAll
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(dumpPath) == 0 {
+		return "", fmt.Errorf("dump files of %s not found in %s", suffix, restorePath)
+	}
+
+	return dumpPath, nil
+}
@@ -1,11 +1,23 @@
 package database
 
 import (
+	"bytes"
 	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/itgcloud/gobackup/archive"
 	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/manifest"
 	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
 )
 
 func init() {
@@ -58,3 +70,193 @@ func TestBase_newBase(t *testing.T) {
 	assert.Equal(t, base.name, "mysql-master")
 	assert.Equal(t, base.dumpPath, "/tmp/gobackup/test/mysql/mysql-master")
 }
+
+func TestBase_findDumpPath(t *testing.T) {
+	restorePath := t.TempDir()
+	dumpPath := filepath.Join(restorePath, "tmp/gobackup123/1670167448676759530/demo/mysql/mysql1")
+	assert.NoError(t, os.MkdirAll(dumpPath, 0750))
+
+	p, err := findDumpPath(restorePath, "demo/mysql/mysql1")
+	assert.NoError(t, err)
+	assert.Equal(t, dumpPath, p)
+
+	_, err = findDumpPath(restorePath, "demo/mysql/mysql2")
+	assert.Error(t, err)
+}
+
+func TestBase_dumpTo(t *testing.T) {
+	base := Base{dumpPath: t.TempDir()}
+
+	dumpFilePath, err := base.dumpTo("foo.sql", helper.Command{Name: "sh", Args: []string{"-c", `echo "$0 $PGPASSWORD"`, "dump of foo"}, Env: []string{"PGPASSWORD=pass word"}})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(base.dumpPath, "foo.sql"), dumpFilePath)
+	data, err := os.ReadFile(dumpFilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "dump of foo pass word\n", string(data))
+
+	// The incomplete dump file is removed
+	_, err = base.dumpTo("bar.sql", helper.Command{Name: "sh", Args: []string{"-c", "echo partial; echo access denied >&2; exit 2"}})
+	assert.EqualError(t, err, "sh exit status 2: access denied")
+	assert.False(t, helper.IsExistsPath(filepath.Join(base.dumpPath, "bar.sql")))
+}
+
+func TestBase_dumpToStream(t *testing.T) {
+	var buf bytes.Buffer
+	base := Base{dumpPath: t.TempDir(), dumps: archive.NewDumpWriter(&buf)}
+
+	dumpFilePath, err := base.dumpTo("globals/globals.sql", helper.Command{Name: "sh", Args: []string{"-c", "echo globals"}})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(base.dumpPath, "globals", "globals.sql"), dumpFilePath)
+	_, err = base.dumpTo("app.sql", helper.Command{Name: "sh", Args: []string{"-c", "echo dump of app"}})
+	assert.NoError(t, err)
+
+	// The dumps are not written into the dump path
+	assert.False(t, helper.IsExistsPath(dumpFilePath))
+	assert.False(t, helper.IsExistsPath(filepath.Join(base.dumpPath, "app.sql")))
+	assert.Equal(t, []manifest.StreamedDump{{Name: "globals/globals.sql", Size: 8}, {Name: "app.sql", Size: 12}}, manifest.ReadStreamed(base.dumpPath))
+
+	_, err = base.dumpTo("bar.sql", helper.Command{Name: "sh", Args: []string{"-c", "echo partial; echo access denied >&2; exit 2"}})
+	assert.EqualError(t, err, "sh exit status 2: access denied")
+	assert.Equal(t, 2, len(manifest.ReadStreamed(base.dumpPath)))
+
+	target := t.TempDir()
+	assert.NoError(t, archive.Extract(&buf, target))
+	data, err := os.ReadFile(filepath.Join(target, base.dumpPath, "app.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, "dump of app\n", string(data))
+	data, err = os.ReadFile(filepath.Join(target, dumpFilePath))
+	assert.NoError(t, err)
+	assert.Equal(t, "globals\n", string(data))
+}
+
+func TestJoinStreamed(t *testing.T) {
+	dumpPath := t.TempDir()
+	assert.NoError(t, joinStreamed(dumpPath))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dumpPath, "small.sql"), []byte("hi"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(dumpPath, "app.sql.part000000"), []byte("hello "), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(dumpPath, "app.sql.part000001"), []byte("world"), 0640))
+	assert.NoError(t, manifest.AddStreamed(dumpPath, manifest.StreamedDump{Name: "small.sql", Size: 2}))
+	assert.NoError(t, manifest.AddStreamed(dumpPath, manifest.StreamedDump{Name: "app.sql", Size: 11, Parts: 2}))
+
+	assert.NoError(t, joinStreamed(dumpPath))
+	data, err := os.ReadFile(filepath.Join(dumpPath, "app.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	assert.False(t, helper.IsExistsPath(filepath.Join(dumpPath, "app.sql.part000000")))
+	data, err = os.ReadFile(filepath.Join(dumpPath, "small.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", string(data))
+
+	// The parts have been joined
+	assert.NoError(t, joinStreamed(dumpPath))
+	data, err = os.ReadFile(filepath.Join(dumpPath, "app.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	// The dump is kept when a part is missing
+	assert.NoError(t, os.WriteFile(filepath.Join(dumpPath, "app.sql.part000000"), []byte("hello "), 0640))
+	assert.EqualError(t, joinStreamed(dumpPath), "join the parts of app.sql failed: open "+filepath.Join(dumpPath, "app.sql.part000001")+": no such file or directory")
+	data, err = os.ReadFile(filepath.Join(dumpPath, "app.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	entries, err := os.ReadDir(dumpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(entries))
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs("")
+	assert.NoError(t, err)
+	assert.Nil(t, args)
+
+	args, err = splitArgs(`--foo  --bar='a b' "c d"`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--foo", "--bar=a b", "c d"}, args)
+}
+
+func TestRun_parallel(t *testing.T) {
+	dumpPath := t.TempDir()
+	model := config.ModelConfig{DumpPath: dumpPath, Databases: map[string]config.SubConfig{}}
+	for _, name := range []string{"db1", "db2", "db3", "db4", "db5"} {
+		model.Databases[name] = config.SubConfig{Name: name, Type: "postgresql"}
+	}
+
+	var running, maxRunning int32
+	var mu sync.Mutex
+	var dumped []string
+	runDatabase = func(model config.ModelConfig, dbConfig config.SubConfig, dumps *archive.DumpWriter) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		dumped = append(dumped, dbConfig.Name)
+		mu.Unlock()
+
+		assert.NoError(t, os.MkdirAll(filepath.Join(dumpPath, dbConfig.Type, dbConfig.Name), 0750))
+		if dbConfig.Name == "db2" || dbConfig.Name == "db4" {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+	t.Cleanup(func() { runDatabase = runModel })
+
+	// Continue on error
+	model.MaxParallelDumps = 2
+	model.ContinueOnError = true
+	err := Run(model)
+	assert.EqualError(t, err, "Database errors: [databases.db2: connection refused databases.db4: connection refused]")
+	assert.Equal(t, int32(2), maxRunning)
+	assert.Equal(t, 5, len(dumped))
+	assert.True(t, helper.IsExistsPath(filepath.Join(dumpPath, "postgresql", "db1")))
+	assert.False(t, helper.IsExistsPath(filepath.Join(dumpPath, "postgresql", "db2")))
+	assert.False(t, helper.IsExistsPath(filepath.Join(dumpPath, "postgresql", "db4")))
+
+	// Stop after the first error, one by one by default
+	dumped = nil
+	model.MaxParallelDumps = 1
+	model.ContinueOnError = false
+	err = Run(model)
+	assert.EqualError(t, err, "Database errors: [databases.db2: connection refused]")
+	assert.Equal(t, []string{"db1", "db2"}, dumped)
+
+	// The error of single database is returned as is
+	model.Databases = map[string]config.SubConfig{"db2": {Name: "db2", Type: "postgresql"}}
+	err = Run(model)
+	assert.EqualError(t, err, "connection refused")
+}
+
+func TestDatabaseFilter(t *testing.T) {
+	v := viper.New()
+	f, err := newDatabaseFilter(v, "sys")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app", "mysql", "app_test"}, f.filter("app\nsys\nmysql\n\napp_test\n"))
+
+	v.Set("include_databases", []string{"app*"})
+	v.Set("exclude_databases", []string{"*_test"})
+	f, err = newDatabaseFilter(v)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app", "app_prod"}, f.filter("app\nsys\napp_prod\napp_test"))
+
+	v.Set("exclude_databases", []string{"[app"})
+	_, err = newDatabaseFilter(v)
+	assert.EqualError(t, err, `invalid database pattern "[app": syntax error in pattern`)
+}
+
+func TestDumpFileName(t *testing.T) {
+	assert.Equal(t, "app.sql", dumpFileName("app", ".sql"))
+	assert.Equal(t, "a%2Fb%5Cc.sql", dumpFileName(`a/b\c`, ".sql"))
+	assert.NotEqual(t, dumpFileName("a/b", ".sql"), dumpFileName("a_b", ".sql"))
+	assert.NotEqual(t, dumpFileName("a%2Fb", ".sql"), dumpFileName("a/b", ".sql"))
+
+	name, err := url.PathUnescape(strings.TrimSuffix(dumpFileName("a/b%c d", ".sql"), ".sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a/b%c d", name)
+}
@@ -2,40 +2,48 @@ package database
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/itgcloud/gobackup/helper"
-	"github.com/itgcloud/gobackup/logger"
 )
 
 // Mariadb database
 //
 // type: mariadb
+// mode: mariabackup
 // host: 127.0.0.1
 // port: 3306
 // socket:
 // database:
 // username: root
 // password:
+// stream: false
+// incremental: false
+// max_incrementals: 6
 // args:
-
+//
+// The physical backup is taken by `mariadb-backup`, see `xtrabackup` for the `stream`,
+// `incremental` and `max_incrementals` options.
 type MariaDB struct {
 	Base
+	mode     string
 	host     string
 	port     string
 	socket   string
 	database string
 	username string
 	password string
-	args     string
+	args     []string
+	physical *xtrabackup
 }
 
 func (db *MariaDB) init() (err error) {
 	viper := db.viper
+	viper.SetDefault("mode", "mariabackup")
 	viper.SetDefault("host", "127.0.0.1")
 	viper.SetDefault("username", "root")
 	viper.SetDefault("port", 3306)
 
+	db.mode = viper.GetString("mode")
 	db.host = viper.GetString("host")
 	db.port = viper.GetString("port")
 	db.socket = viper.GetString("socket")
@@ -43,8 +51,12 @@ func (db *MariaDB) init() (err error) {
 	db.username = viper.GetString("username")
 	db.password = viper.GetString("password")
 
-	if len(viper.GetString("args")) > 0 {
-		db.args = viper.GetString("args")
+	if db.args, err = splitArgs(viper.GetString("args")); err != nil {
+		return err
+	}
+
+	if db.mode != "mariabackup" {
+		return fmt.Errorf("MariaDB mode %q is not supported, it should be mariabackup", db.mode)
 	}
 
 	// socket
@@ -53,46 +65,23 @@ func (db *MariaDB) init() (err error) {
 		db.port = ""
 	}
 
-	return nil
-}
-
-func (db *MariaDB) build() string {
-	dumpArgs := []string{}
-	if len(db.host) > 0 {
-		dumpArgs = append(dumpArgs, "--host", db.host)
-	}
-	if len(db.port) > 0 {
-		dumpArgs = append(dumpArgs, "--port", db.port)
-	}
-	if len(db.socket) > 0 {
-		dumpArgs = append(dumpArgs, "--socket", db.socket)
-	}
-	if len(db.username) > 0 {
-		dumpArgs = append(dumpArgs, "-u", db.username)
-	}
+	db.physical = newXtrabackup(db.Base, "mariadb-backup")
+	db.physical.connectionArgs = xtrabackupConnectionArgs(db.host, db.port, db.socket, db.username)
 	if len(db.password) > 0 {
-		dumpArgs = append(dumpArgs, `-p`+db.password)
+		// pass the password by MYSQL_PWD, so it will not be visible in the process list
+		db.physical.env = []string{"MYSQL_PWD=" + db.password}
 	}
+	db.physical.database = db.database
+	db.physical.args = db.args
 
-	if len(db.args) > 0 {
-		dumpArgs = append(dumpArgs, db.args)
-	}
-	if len(db.database) > 0 {
-		dumpArgs = append(dumpArgs, "--databases="+db.database)
-	}
-	dumpArgs = append(dumpArgs, "--target-dir="+db.dumpPath)
+	return nil
+}
 
-	return "mariadb-backup --backup " + strings.Join(dumpArgs, " ")
+// build the full backup command of mariadb-backup
+func (db *MariaDB) build() helper.Command {
+	return db.physical.build(0)
 }
 
 func (db *MariaDB) perform() error {
-	logger := logger.Tag("MariaDB")
-
-	logger.Info("-> Dumping MariaDB...")
-	_, err := helper.Exec(db.build())
-	if err != nil {
-		return fmt.Errorf("-> Dump error: %s", err)
-	}
-	logger.Info("dump path:", db.dumpPath)
-	return nil
+	return db.physical.perform()
 }
@@ -37,28 +37,39 @@ func TestMariaDB_init(t *testing.T) {
 
 	err := db.init()
 	assert.NoError(t, err)
-	script := db.build()
-	assert.Equal(t, script, "mariadb-backup --backup --host 1.2.3.4 --port 1234 -u user1 -ppass1 --a1 --a2 --a3 --databases=my_db --target-dir=/data/backups/mariadb/mariadb1")
+	cmd := db.build()
+	assert.Equal(t, cmd.String(), "mariadb-backup --backup --host=1.2.3.4 --port=1234 --user=user1 --a1 --a2 --a3 --databases=my_db --target-dir=/data/backups/mariadb/mariadb1")
+	assert.Equal(t, []string{"MYSQL_PWD=pass1"}, cmd.Env)
+
+	viper.Set("mode", "dump")
+	assert.EqualError(t, db.init(), `MariaDB mode "dump" is not supported, it should be mariabackup`)
 }
 
 func TestMariaDB_dumpArgsWithAdditionalOptions(t *testing.T) {
+	viper := viper.New()
+	viper.Set("host", "127.0.0.2")
+	viper.Set("port", "6378")
+	viper.Set("username", "")
+	viper.Set("password", "*&^92'")
+	viper.Set("database", "my_db2")
+	viper.Set("args", "--datadir=/var/lib64/mysql")
+
 	base := newBase(
 		config.ModelConfig{
 			DumpPath: "/data/backups/",
 		},
 		config.SubConfig{
-			Type: "mariadb",
-			Name: "mariadb1",
+			Type:  "mariadb",
+			Name:  "mariadb1",
+			Viper: viper,
 		},
 	)
 	db := &MariaDB{
-		Base:     base,
-		host:     "127.0.0.2",
-		port:     "6378",
-		password: "*&^92'",
-		database: "my_db2",
-		args:     "--datadir=/var/lib64/mysql",
+		Base: base,
 	}
+	assert.NoError(t, db.init())
 
-	assert.Equal(t, db.build(), "mariadb-backup --backup --host 127.0.0.2 --port 6378 -p*&^92' --datadir=/var/lib64/mysql --databases=my_db2 --target-dir=/data/backups/mariadb/mariadb1")
+	cmd := db.build()
+	assert.Equal(t, cmd.String(), "mariadb-backup --backup --host=127.0.0.2 --port=6378 --datadir=/var/lib64/mysql --databases=my_db2 --target-dir=/data/backups/mariadb/mariadb1")
+	assert.Equal(t, []string{"MYSQL_PWD=*&^92'"}, cmd.Env)
 }
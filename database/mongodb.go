@@ -1,7 +1,11 @@
 package database
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/itgcloud/gobackup/helper"
@@ -21,6 +25,9 @@ import (
 // exclude_tables:
 // oplog: false
 // args:
+//
+// The dump is streamed from `mongodump --archive` into `mongodump.archive`, the password and uri
+// are passed by a temporary `--config` file (MongoDB Database Tools 100.3+) instead of the args.
 type MongoDB struct {
 	Base
 	uri           string
@@ -32,13 +39,16 @@ type MongoDB struct {
 	authdb        string
 	excludeTables []string
 	oplog         bool
-	args          string
+	args          []string
 }
 
 var (
-	mongodumpCli = "mongodump"
+	mongodumpCli    = "mongodump"
+	mongorestoreCli = "mongorestore"
 )
 
+const mongodumpArchive = "mongodump.archive"
+
 func (db *MongoDB) init() (err error) {
 	viper := db.viper
 	viper.SetDefault("oplog", false)
@@ -54,57 +64,78 @@ func (db *MongoDB) init() (err error) {
 	db.oplog = viper.GetBool("oplog")
 	db.authdb = viper.GetString("authdb")
 	db.excludeTables = viper.GetStringSlice("exclude_tables")
-	db.args = viper.GetString("args")
+	if db.args, err = splitArgs(viper.GetString("args")); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func (db *MongoDB) build() string {
-	if len(db.uri) > 0 {
-		return mongodumpCli + " " +
-			"--uri=" + db.uri + " " +
-			db.additionOption() + " " +
-			"--out=" + db.dumpPath
-	}
-	return mongodumpCli + " " +
-		db.nameOption() + " " +
-		db.credentialOptions() + " " +
-		db.connectivityOptions() + " " +
-		db.additionOption() + " " +
-		"--out=" + db.dumpPath
+// build the mongodump command, the archive is written to stdout
+func (db *MongoDB) build() helper.Command {
+	var args []string
+	if len(db.uri) == 0 {
+		args = append(args, db.nameOption())
+		args = append(args, db.credentialOptions()...)
+		args = append(args, db.connectivityOptions()...)
+	}
+	args = append(args, db.configOptions()...)
+	args = append(args, db.additionOption()...)
+	args = append(args, "--archive")
+
+	return helper.Command{Name: mongodumpCli, Args: args}
+}
+
+// buildRestore the mongorestore command, the dump of older versions is a directory instead of archive
+func (db *MongoDB) buildRestore(dumpPath string) helper.Command {
+	var args []string
+	if len(db.uri) == 0 {
+		if len(db.database) > 0 {
+			args = append(args, "--nsInclude="+db.database+".*")
+		}
+		args = append(args, db.credentialOptions()...)
+		args = append(args, db.connectivityOptions()...)
+	}
+	args = append(args, db.configOptions()...)
+
+	archivePath := path.Join(dumpPath, mongodumpArchive)
+	if helper.IsExistsPath(archivePath) {
+		args = append(args, "--archive="+archivePath)
+	} else {
+		args = append(args, "--dir="+dumpPath)
+	}
+
+	return helper.Command{Name: mongorestoreCli, Args: args}
 }
 
 func (db *MongoDB) nameOption() string {
 	return "--db=" + db.database
 }
 
-func (db *MongoDB) credentialOptions() string {
+func (db *MongoDB) credentialOptions() []string {
 	opts := []string{}
 	if len(db.username) > 0 {
 		opts = append(opts, "--username="+db.username)
 	}
-	if len(db.password) > 0 {
-		opts = append(opts, `--password=`+db.password)
-	}
 	if len(db.authdb) > 0 {
 		opts = append(opts, "--authenticationDatabase="+db.authdb)
 	}
-	return strings.Join(opts, " ")
+	return opts
 }
 
-func (db *MongoDB) connectivityOptions() string {
+func (db *MongoDB) connectivityOptions() []string {
 	opts := []string{}
 	if len(db.host) > 0 {
-		opts = append(opts, "--host="+db.host+"")
+		opts = append(opts, "--host="+db.host)
 	}
 	if len(db.port) > 0 {
-		opts = append(opts, "--port="+db.port+"")
+		opts = append(opts, "--port="+db.port)
 	}
 
-	return strings.Join(opts, " ")
+	return opts
 }
 
-func (db *MongoDB) additionOption() string {
+func (db *MongoDB) additionOption() []string {
 	opts := []string{}
 	if db.oplog {
 		opts = append(opts, "--oplog")
@@ -114,21 +145,80 @@ func (db *MongoDB) additionOption() string {
 		opts = append(opts, "--excludeCollection="+table)
 	}
 
-	if len(db.args) > 0 {
-		opts = append(opts, db.args)
+	return append(opts, db.args...)
+}
+
+// configPath is the temporary config file for the password and uri, it is outside of the dump path
+func (db *MongoDB) configPath() string {
+	return filepath.Join(db.model.TempPath, "mongodb-"+db.name+".yml")
+}
+
+func (db *MongoDB) configOptions() []string {
+	if len(db.password) == 0 && len(db.uri) == 0 {
+		return nil
+	}
+
+	return []string{"--config=" + db.configPath()}
+}
+
+// writeConfig write the password and uri into the config file, returns a func to remove it
+func (db *MongoDB) writeConfig() (func(), error) {
+	if len(db.configOptions()) == 0 {
+		return func() {}, nil
+	}
+
+	var lines []string
+	if len(db.password) > 0 {
+		// JSON string is a valid double-quoted YAML scalar
+		password, _ := json.Marshal(db.password)
+		lines = append(lines, "password: "+string(password))
+	}
+	if len(db.uri) > 0 {
+		uri, _ := json.Marshal(db.uri)
+		lines = append(lines, "uri: "+string(uri))
 	}
 
-	return strings.Join(opts, " ")
+	configPath := db.configPath()
+	if err := os.WriteFile(configPath, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write config %s: %v", configPath, err)
+	}
+
+	return func() { os.Remove(configPath) }, nil
 }
 
 func (db *MongoDB) perform() error {
 	logger := logger.Tag("MongoDB")
 
-	out, err := helper.Exec(db.build())
+	logger.Info("-> Dumping MongoDB...")
+	removeConfig, err := db.writeConfig()
+	if err != nil {
+		return err
+	}
+	defer removeConfig()
+
+	dumpFilePath, err := db.dumpTo(mongodumpArchive, db.build())
 	if err != nil {
 		return fmt.Errorf("-> Dump error: %s", err)
 	}
+	logger.Info("dump path:", dumpFilePath)
+	return nil
+}
+
+// restore load the dump with mongorestore
+func (db *MongoDB) restore(dumpPath string) error {
+	logger := logger.Tag("MongoDB")
+
+	logger.Info("-> Restoring MongoDB from", dumpPath)
+	removeConfig, err := db.writeConfig()
+	if err != nil {
+		return err
+	}
+	defer removeConfig()
+
+	out, err := db.buildRestore(dumpPath).Run()
+	if err != nil {
+		return fmt.Errorf("-> Restore error: %s", err)
+	}
 	logger.Info(out)
-	logger.Info("dump path:", db.dumpPath)
 	return nil
 }
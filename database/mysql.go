@@ -2,7 +2,9 @@ package database
 
 import (
 	"fmt"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/itgcloud/gobackup/helper"
@@ -12,15 +14,27 @@ import (
 // MySQL database
 //
 // type: mysql
+// mode: dump
 // host: 127.0.0.1
 // port: 3306
 // socket:
 // database:
 // username: root
 // password:
+// tables:
+// exclude_tables:
+// include_databases:
+// exclude_databases:
 // args:
+//
+// When `database` is empty, all databases listed by `SHOW DATABASES` (except the system schemas)
+// are dumped into their own files, and `exclude_tables` should be `database.table`.
+//
+// With `mode: xtrabackup`, the physical backup is taken by Percona XtraBackup, see `xtrabackup` for
+// the `stream`, `incremental` and `max_incrementals` options. The `tables` are not used.
 type MySQL struct {
 	Base
+	mode          string
 	host          string
 	port          string
 	socket        string
@@ -29,15 +43,22 @@ type MySQL struct {
 	password      string
 	tables        []string
 	excludeTables []string
-	args          string
+	filter        databaseFilter
+	args          []string
+	physical      *xtrabackup
 }
 
+// mysqlSystemDatabases are not dumped in "all databases" mode
+var mysqlSystemDatabases = []string{"information_schema", "performance_schema", "sys"}
+
 func (db *MySQL) init() (err error) {
 	viper := db.viper
+	viper.SetDefault("mode", "dump")
 	viper.SetDefault("host", "127.0.0.1")
 	viper.SetDefault("username", "root")
 	viper.SetDefault("port", 3306)
 
+	db.mode = viper.GetString("mode")
 	db.host = viper.GetString("host")
 	db.port = viper.GetString("port")
 	db.socket = viper.GetString("socket")
@@ -48,13 +69,8 @@ func (db *MySQL) init() (err error) {
 	db.tables = viper.GetStringSlice("tables")
 	db.excludeTables = viper.GetStringSlice("exclude_tables")
 
-	if len(viper.GetString("args")) > 0 {
-		db.args = viper.GetString("args")
-	}
-
-	// mysqldump command
-	if len(db.database) == 0 {
-		return fmt.Errorf("mysql database config is required")
+	if db.args, err = splitArgs(viper.GetString("args")); err != nil {
+		return err
 	}
 
 	// socket
@@ -63,10 +79,36 @@ func (db *MySQL) init() (err error) {
 		db.port = ""
 	}
 
+	switch db.mode {
+	case "dump":
+	case "xtrabackup":
+		if len(db.tables) > 0 || len(db.excludeTables) > 0 {
+			return fmt.Errorf("mysql tables and exclude_tables are not supported in xtrabackup mode")
+		}
+		db.physical = newXtrabackup(db.Base, "xtrabackup")
+		db.physical.connectionArgs = xtrabackupConnectionArgs(db.host, db.port, db.socket, db.username)
+		db.physical.env = db.env()
+		db.physical.database = db.database
+		db.physical.args = db.args
+		return nil
+	default:
+		return fmt.Errorf("mysql mode %q is not supported, it should be dump or xtrabackup", db.mode)
+	}
+
+	// all databases
+	if len(db.database) == 0 {
+		if len(db.tables) > 0 {
+			return fmt.Errorf("mysql tables config requires database")
+		}
+		if db.filter, err = newDatabaseFilter(viper, mysqlSystemDatabases...); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (db *MySQL) build() string {
+func (db *MySQL) connectionArgs() []string {
 	dumpArgs := []string{}
 	if len(db.host) > 0 {
 		dumpArgs = append(dumpArgs, "--host", db.host)
@@ -80,37 +122,150 @@ func (db *MySQL) build() string {
 	if len(db.username) > 0 {
 		dumpArgs = append(dumpArgs, "-u", db.username)
 	}
+
+	return dumpArgs
+}
+
+// env pass the password by MYSQL_PWD, so it will not be visible in the process list
+func (db *MySQL) env() []string {
 	if len(db.password) > 0 {
-		dumpArgs = append(dumpArgs, `-p`+db.password)
+		return []string{"MYSQL_PWD=" + db.password}
 	}
 
+	return nil
+}
+
+// build the mysqldump command, the dump is written to stdout
+func (db *MySQL) build() helper.Command {
+	dumpArgs := db.connectionArgs()
+
 	for _, table := range db.excludeTables {
 		dumpArgs = append(dumpArgs, "--ignore-table="+db.database+"."+table)
 	}
 
-	if len(db.args) > 0 {
-		dumpArgs = append(dumpArgs, db.args)
-	}
+	dumpArgs = append(dumpArgs, db.args...)
 
 	dumpArgs = append(dumpArgs, db.database)
-	if len(db.tables) > 0 {
-		dumpArgs = append(dumpArgs, db.tables...)
+	dumpArgs = append(dumpArgs, db.tables...)
+
+	return helper.Command{Name: "mysqldump", Args: dumpArgs, Env: db.env()}
+}
+
+// buildDatabase the mysqldump command for one of all databases, with `--databases` the dump
+// contains `CREATE DATABASE` and `USE`, so it can be restored without the database name.
+func (db *MySQL) buildDatabase(database string) helper.Command {
+	dumpArgs := db.connectionArgs()
+
+	for _, table := range db.excludeTables {
+		if strings.HasPrefix(table, database+".") {
+			dumpArgs = append(dumpArgs, "--ignore-table="+table)
+		}
 	}
 
-	dumpFilePath := path.Join(db.dumpPath, db.database+".sql")
-	dumpArgs = append(dumpArgs, "--result-file="+dumpFilePath)
+	dumpArgs = append(dumpArgs, db.args...)
+	dumpArgs = append(dumpArgs, "--databases", database)
+
+	return helper.Command{Name: "mysqldump", Args: dumpArgs, Env: db.env()}
+}
+
+// listDatabases by `SHOW DATABASES`, filtered by `include_databases` and `exclude_databases`
+func (db *MySQL) listDatabases() ([]string, error) {
+	args := append(db.connectionArgs(), "--batch", "--skip-column-names", "--execute=SHOW DATABASES")
+	out, err := helper.Command{Name: "mysql", Args: args, Env: db.env()}.Run()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %v", err)
+	}
 
-	return "mysqldump" + " " + strings.Join(dumpArgs, " ")
+	return db.filter.filter(out), nil
 }
 
 func (db *MySQL) perform() error {
 	logger := logger.Tag("MySQL")
 
-	logger.Info("-> Dumping MySQL...")
-	_, err := helper.Exec(db.build())
+	if db.physical != nil {
+		return db.physical.perform()
+	}
+
+	if len(db.database) > 0 {
+		logger.Info("-> Dumping MySQL...")
+		dumpFilePath, err := db.dumpTo(db.database+".sql", db.build())
+		if err != nil {
+			return fmt.Errorf("-> Dump error: %s", err)
+		}
+		logger.Info("dump path:", dumpFilePath)
+		return nil
+	}
+
+	databases, err := db.listDatabases()
 	if err != nil {
 		return fmt.Errorf("-> Dump error: %s", err)
 	}
-	logger.Info("dump path:", db.dumpPath)
+	if len(databases) == 0 {
+		return fmt.Errorf("-> Dump error: no database matched")
+	}
+
+	for _, database := range databases {
+		logger.Infof("-> Dumping MySQL database %s...", database)
+		if _, err := db.dumpTo(dumpFileName(database, ".sql"), db.buildDatabase(database)); err != nil {
+			return fmt.Errorf("-> Dump %s error: %s", database, err)
+		}
+	}
+	logger.Infof("dump path: %s (%d databases)", db.dumpPath, len(databases))
 	return nil
 }
+
+// restore load the `.sql` dump with mysql client, all the `.sql` files are loaded in "all databases" mode
+func (db *MySQL) restore(dumpPath string) error {
+	logger := logger.Tag("MySQL")
+
+	if db.physical != nil {
+		return fmt.Errorf("xtrabackup can not be loaded into the running server, prepare %s and restore it by `xtrabackup --copy-back` instead", dumpPath)
+	}
+
+	if len(db.database) == 0 {
+		dumpFilePaths, err := filepath.Glob(filepath.Join(dumpPath, "*.sql"))
+		if err != nil {
+			return err
+		}
+		if len(dumpFilePaths) == 0 {
+			return fmt.Errorf("dump files not found in %s", dumpPath)
+		}
+
+		for _, dumpFilePath := range dumpFilePaths {
+			logger.Info("-> Restoring MySQL from", dumpFilePath)
+			if err := db.load(dumpFilePath, ""); err != nil {
+				return fmt.Errorf("-> Restore error: %s", err)
+			}
+		}
+		return nil
+	}
+
+	dumpFilePath := path.Join(dumpPath, db.database+".sql")
+	if !helper.IsExistsPath(dumpFilePath) {
+		return fmt.Errorf("dump file %s not found", dumpFilePath)
+	}
+
+	logger.Info("-> Restoring MySQL from", dumpFilePath)
+	if err := db.load(dumpFilePath, db.database); err != nil {
+		return fmt.Errorf("-> Restore error: %s", err)
+	}
+
+	return nil
+}
+
+// load the dump file into mysql by stdin, the database is not required when the dump has `USE`
+func (db *MySQL) load(dumpFilePath, database string) error {
+	f, err := os.Open(dumpFilePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	args := db.connectionArgs()
+	if len(database) > 0 {
+		args = append(args, database)
+	}
+
+	_, err = helper.Command{Name: "mysql", Args: args, Env: db.env(), Stdin: f}.Run()
+	return err
+}
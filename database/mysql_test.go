@@ -39,8 +39,9 @@ func TestMySQL_init(t *testing.T) {
 
 	err := db.init()
 	assert.NoError(t, err)
-	script := db.build()
-	assert.Equal(t, script, "mysqldump --host 1.2.3.4 --port 1234 -u user1 -ppass1 --ignore-table=my_db.aa --ignore-table=my_db.bb --a1 --a2 --a3 my_db foo bar --result-file=/data/backups/mysql/mysql1/my_db.sql")
+	cmd := db.build()
+	assert.Equal(t, "mysqldump --host 1.2.3.4 --port 1234 -u user1 --ignore-table=my_db.aa --ignore-table=my_db.bb --a1 --a2 --a3 my_db foo bar", cmd.String())
+	assert.Equal(t, []string{"MYSQL_PWD=pass1"}, cmd.Env)
 }
 
 func TestMySQL_dumpArgsWithAdditionalOptions(t *testing.T) {
@@ -58,9 +59,45 @@ func TestMySQL_dumpArgsWithAdditionalOptions(t *testing.T) {
 		database: "dummy_test",
 		host:     "127.0.0.2",
 		port:     "6378",
-		password: "*&^92'",
-		args:     "--single-transaction --quick",
+		password: "*&^92' pass",
+		tables:   []string{"my table"},
+		args:     []string{"--single-transaction", "--quick"},
 	}
 
-	assert.Equal(t, db.build(), "mysqldump --host 127.0.0.2 --port 6378 -p*&^92' --single-transaction --quick dummy_test --result-file=/data/backups/mysql/mysql1/dummy_test.sql")
+	cmd := db.build()
+	assert.Equal(t, []string{"--host", "127.0.0.2", "--port", "6378", "--single-transaction", "--quick", "dummy_test", "my table"}, cmd.Args)
+	assert.Equal(t, []string{"MYSQL_PWD=*&^92' pass"}, cmd.Env)
+}
+
+func TestMySQL_initArgs(t *testing.T) {
+	viper := viper.New()
+	viper.Set("database", "my_db")
+	viper.Set("args", `--where="id > 10" --quick`)
+
+	db := &MySQL{Base: newBase(config.ModelConfig{DumpPath: t.TempDir()}, config.SubConfig{Type: "mysql", Name: "mysql1", Viper: viper})}
+	assert.NoError(t, db.init())
+	assert.Equal(t, []string{"--where=id > 10", "--quick"}, db.args)
+	assert.Nil(t, db.build().Env)
+
+	viper.Set("args", `--where="id > 10`)
+	assert.EqualError(t, db.init(), `invalid args "--where=\"id > 10": EOF found when expecting closing quote`)
+}
+
+func TestMySQL_allDatabases(t *testing.T) {
+	viper := viper.New()
+	viper.Set("username", "user1")
+	viper.Set("password", "pass1")
+	viper.Set("exclude_tables", []string{"app.logs", "other.logs"})
+	viper.Set("exclude_databases", []string{"mysql"})
+
+	db := &MySQL{Base: newBase(config.ModelConfig{DumpPath: t.TempDir()}, config.SubConfig{Type: "mysql", Name: "mysql1", Viper: viper})}
+	assert.NoError(t, db.init())
+	assert.Equal(t, []string{"app"}, db.filter.filter("information_schema\napp\nmysql\nperformance_schema\nsys"))
+
+	cmd := db.buildDatabase("app")
+	assert.Equal(t, "mysqldump --host 127.0.0.1 --port 3306 -u user1 --ignore-table=app.logs --databases app", cmd.String())
+	assert.Equal(t, []string{"MYSQL_PWD=pass1"}, cmd.Env)
+
+	viper.Set("tables", []string{"foo"})
+	assert.EqualError(t, db.init(), "mysql tables config requires database")
 }
@@ -2,7 +2,6 @@ package database
 
 import (
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -19,6 +18,7 @@ import (
 // # Keys
 //
 //   - type: postgresql
+//   - mode: dump
 //   - host: localhost
 //   - port: 5432
 //   - socket:
@@ -27,9 +27,21 @@ import (
 //   - password:
 //   - tables:
 //   - exclude_tables:
+//   - include_databases:
+//   - exclude_databases:
+//   - globals: true
 //   - args:
+//
+// When `database` is empty, all databases in `pg_database` (except the templates) are dumped into
+// their own files with `--create`, and the roles and tablespaces are dumped by `pg_dumpall --globals-only`
+// into `globals/globals.sql` unless `globals` is false.
+//
+// With `mode: basebackup`, the physical backup of the whole cluster is taken by `pg_basebackup` in tar format
+// (`base.tar`, `pg_wal.tar` and `backup_manifest`), the `database` and `tables` are not used.
+// The WAL segments can be archived into the storages by `gobackup wal-push` for point-in-time recovery.
 type PostgreSQL struct {
 	Base
+	mode          string
 	host          string
 	port          string
 	socket        string
@@ -38,15 +50,26 @@ type PostgreSQL struct {
 	tables        []string
 	excludeTables []string
 	password      string
-	args          string
-	_dumpFilePath string
+	filter        databaseFilter
+	globals       bool
+	args          []string
 }
 
+// pgGlobalsFile is the dump of `pg_dumpall --globals-only` in "all databases" mode,
+// it is in a subdirectory to not collide with the dump of a database named "globals".
+const pgGlobalsFile = "globals/globals.sql"
+
+// pgListDatabasesSQL list the databases can be connected, except the templates
+const pgListDatabasesSQL = "SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname"
+
 func (db *PostgreSQL) init() (err error) {
 	viper := db.viper
+	viper.SetDefault("mode", "dump")
 	viper.SetDefault("host", "localhost")
 	viper.SetDefault("port", 5432)
 
+	db.mode = viper.GetString("mode")
+
 	db.host = viper.GetString("host")
 	db.port = viper.GetString("port")
 	db.socket = viper.GetString("socket")
@@ -55,25 +78,40 @@ func (db *PostgreSQL) init() (err error) {
 	db.password = viper.GetString("password")
 	db.tables = viper.GetStringSlice("tables")
 	db.excludeTables = viper.GetStringSlice("exclude_tables")
-	db.args = viper.GetString("args")
-
-	if len(db.database) == 0 {
-		return fmt.Errorf("PostgreSQL database config is required")
+	if db.args, err = splitArgs(viper.GetString("args")); err != nil {
+		return err
 	}
 
-	db._dumpFilePath = path.Join(db.dumpPath, db.database+".sql")
-
 	// socket
 	if len(db.socket) != 0 {
 		db.host = ""
 		db.port = ""
 	}
 
+	switch db.mode {
+	case "dump":
+	case "basebackup":
+		return nil
+	default:
+		return fmt.Errorf("PostgreSQL mode %q is not supported, it should be dump or basebackup", db.mode)
+	}
+
+	// all databases
+	viper.SetDefault("globals", true)
+	db.globals = viper.GetBool("globals")
+	if len(db.database) == 0 {
+		if len(db.tables) > 0 {
+			return fmt.Errorf("PostgreSQL tables config requires database")
+		}
+		if db.filter, err = newDatabaseFilter(viper); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (db *PostgreSQL) build() string {
-	// pg_dump command
+func (db *PostgreSQL) connectionArgs() []string {
 	var dumpArgs []string
 
 	if len(db.host) > 0 {
@@ -91,37 +129,165 @@ func (db *PostgreSQL) build() string {
 		dumpArgs = append(dumpArgs, "--username="+db.username)
 	}
 
+	return dumpArgs
+}
+
+// env pass the password by PGPASSWORD, so it will not be visible in the process list
+func (db *PostgreSQL) env() []string {
+	if len(db.password) > 0 {
+		return []string{"PGPASSWORD=" + db.password}
+	}
+
+	return nil
+}
+
+// build the pg_dump command, the dump is written to stdout
+func (db *PostgreSQL) build() helper.Command {
+	return db.buildDatabase(db.database)
+}
+
+// buildDatabase the pg_dump command of the database, in "all databases" mode the dump is with
+// `--create`, so it can be restored by connecting to any database.
+func (db *PostgreSQL) buildDatabase(database string) helper.Command {
+	dumpArgs := db.connectionArgs()
+
 	// include / exclude tables
-	if len(db.tables) > 0 {
-		dumpArgs = append(dumpArgs, "--table="+strings.Join(db.tables, " --table="))
+	for _, table := range db.tables {
+		dumpArgs = append(dumpArgs, "--table="+table)
+	}
+	for _, table := range db.excludeTables {
+		dumpArgs = append(dumpArgs, "--exclude-table="+table)
 	}
 
-	if len(db.excludeTables) > 0 {
-		dumpArgs = append(dumpArgs, "--exclude-table="+strings.Join(db.excludeTables, " --exclude-table="))
+	dumpArgs = append(dumpArgs, db.args...)
+	if len(db.database) == 0 {
+		dumpArgs = append(dumpArgs, "--create")
 	}
+	dumpArgs = append(dumpArgs, database)
+
+	return helper.Command{Name: "pg_dump", Args: dumpArgs, Env: db.env()}
+}
+
+// buildGlobals the pg_dumpall command for the roles and tablespaces
+func (db *PostgreSQL) buildGlobals() helper.Command {
+	args := append(db.connectionArgs(), "--globals-only")
+	return helper.Command{Name: "pg_dumpall", Args: args, Env: db.env()}
+}
 
-	if len(db.args) > 0 {
-		dumpArgs = append(dumpArgs, db.args)
+// listDatabases from pg_database, filtered by `include_databases` and `exclude_databases`
+func (db *PostgreSQL) listDatabases() ([]string, error) {
+	args := append(db.connectionArgs(), "--dbname=postgres", "--no-align", "--tuples-only", "--command="+pgListDatabasesSQL)
+	out, err := helper.Command{Name: "psql", Args: args, Env: db.env()}.Run()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %v", err)
 	}
 
-	dumpArgs = append(dumpArgs, db.database)
-	dumpArgs = append(dumpArgs, "-f", db._dumpFilePath)
+	return db.filter.filter(out), nil
+}
+
+// buildBasebackup the pg_basebackup command, the WAL files required by the backup are streamed into pg_wal.tar,
+// the defaults can be overridden by `args`
+func (db *PostgreSQL) buildBasebackup() helper.Command {
+	args := db.connectionArgs()
+	args = append(args, "--pgdata="+db.dumpPath, "--format=tar", "--wal-method=stream", "--checkpoint=fast", "--label=gobackup")
+	args = append(args, db.args...)
 
-	return "pg_dump " + strings.Join(dumpArgs, " ")
+	return helper.Command{Name: "pg_basebackup", Args: args, Env: db.env()}
 }
 
 func (db *PostgreSQL) perform() error {
 	logger := logger.Tag("PostgreSQL")
 
-	logger.Info("-> Dumping PostgreSQL...")
-	if len(db.password) > 0 {
-		os.Setenv("PGPASSWORD", db.password)
+	if db.mode == "basebackup" {
+		logger.Info("-> Running pg_basebackup...")
+		if _, err := db.buildBasebackup().Run(); err != nil {
+			return err
+		}
+		logger.Info("dump path:", db.dumpPath)
+		return nil
 	}
 
-	_, err := helper.Exec(db.build())
+	if len(db.database) > 0 {
+		logger.Info("-> Dumping PostgreSQL...")
+		dumpFilePath, err := db.dumpTo(db.database+".sql", db.build())
+		if err != nil {
+			return err
+		}
+		logger.Info("dump path:", dumpFilePath)
+		return nil
+	}
+
+	databases, err := db.listDatabases()
 	if err != nil {
 		return err
 	}
-	logger.Info("dump path:", db._dumpFilePath)
+	if len(databases) == 0 {
+		return fmt.Errorf("no database matched")
+	}
+
+	if db.globals {
+		logger.Info("-> Dumping PostgreSQL globals...")
+		if _, err := db.dumpTo(pgGlobalsFile, db.buildGlobals()); err != nil {
+			return fmt.Errorf("dump globals error: %v", err)
+		}
+	}
+
+	for _, database := range databases {
+		logger.Infof("-> Dumping PostgreSQL database %s...", database)
+		if _, err := db.dumpTo(dumpFileName(database, ".sql"), db.buildDatabase(database)); err != nil {
+			return fmt.Errorf("dump %s error: %v", database, err)
+		}
+	}
+	logger.Infof("dump path: %s (%d databases)", db.dumpPath, len(databases))
+	return nil
+}
+
+// restore load the `.sql` dump with psql, in "all databases" mode the globals is loaded first,
+// then the other `.sql` files which will create the databases.
+func (db *PostgreSQL) restore(dumpPath string) error {
+	logger := logger.Tag("PostgreSQL")
+
+	if db.mode == "basebackup" {
+		return fmt.Errorf("basebackup can not be loaded into the running server, extract base.tar and pg_wal.tar from %s into an empty data directory instead", dumpPath)
+	}
+
+	if len(db.database) == 0 {
+		dumpFilePaths, err := filepath.Glob(filepath.Join(dumpPath, "*.sql"))
+		if err != nil {
+			return err
+		}
+		if len(dumpFilePaths) == 0 {
+			return fmt.Errorf("dump files not found in %s", dumpPath)
+		}
+
+		if globalsPath := filepath.Join(dumpPath, pgGlobalsFile); helper.IsExistsPath(globalsPath) {
+			dumpFilePaths = append([]string{globalsPath}, dumpFilePaths...)
+		}
+
+		for _, dumpFilePath := range dumpFilePaths {
+			logger.Info("-> Restoring PostgreSQL from", dumpFilePath)
+			if err := db.load(dumpFilePath, "postgres"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	dumpFilePath := path.Join(dumpPath, db.database+".sql")
+	if !helper.IsExistsPath(dumpFilePath) {
+		return fmt.Errorf("dump file %s not found", dumpFilePath)
+	}
+
+	logger.Info("-> Restoring PostgreSQL from", dumpFilePath)
+	return db.load(dumpFilePath, db.database)
+}
+
+func (db *PostgreSQL) load(dumpFilePath, database string) error {
+	args := db.connectionArgs()
+	args = append(args, "--dbname="+database, "--file="+dumpFilePath)
+	if _, err := (helper.Command{Name: "psql", Args: args, Env: db.env()}).Run(); err != nil {
+		return err
+	}
+
 	return nil
 }
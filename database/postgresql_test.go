@@ -1,9 +1,13 @@
 package database
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
 	"github.com/longbridgeapp/assert"
 	"github.com/spf13/viper"
 )
@@ -38,16 +42,85 @@ func TestPostgreSQL_init(t *testing.T) {
 	err := db.init()
 	assert.NoError(t, err)
 
-	assert.Equal(t, db.build(), "pg_dump --host=1.2.3.4 --port=1234 --username=user1 --table=foo --table=bar --exclude-table=aa --exclude-table=bb --foo --bar --dar my_db -f /data/backups/postgresql/postgresql1/my_db.sql")
+	cmd := db.build()
+	assert.Equal(t, "pg_dump --host=1.2.3.4 --port=1234 --username=user1 --table=foo --table=bar --exclude-table=aa --exclude-table=bb --foo --bar --dar my_db", cmd.String())
+	assert.Equal(t, []string{"PGPASSWORD=pass1"}, cmd.Env)
 }
 
 func Test_PostgreSQL_prepareForSocket(t *testing.T) {
 	db := &PostgreSQL{
-		database:      "foo",
-		socket:        "/var/run/postgresql/pg.5432",
-		args:          "--foo",
-		_dumpFilePath: "/tmp/foo.sql",
+		database: "foo",
+		socket:   "/var/run/postgresql/pg.5432",
+		args:     []string{"--foo"},
 	}
 
-	assert.Equal(t, db.build(), "pg_dump --host=/var/run/postgresql --port=5432 --foo foo -f /tmp/foo.sql")
+	cmd := db.build()
+	assert.Equal(t, "pg_dump --host=/var/run/postgresql --port=5432 --foo foo", cmd.String())
+	assert.Nil(t, cmd.Env)
+}
+
+func TestPostgreSQL_allDatabases(t *testing.T) {
+	binPath := t.TempDir()
+	scripts := map[string]string{
+		"psql":       `case "$*" in *--file=*) for a in "$@"; do last="$a"; done; echo "${last#--file=}" >> "$(dirname "$0")/restored";; *) printf 'app\npostgres\nglobals\napp_test\n';; esac`,
+		"pg_dump":    `for a in "$@"; do last="$a"; done; echo "dump $last $PGPASSWORD"`,
+		"pg_dumpall": `echo "globals $*"`,
+	}
+	for name, script := range scripts {
+		assert.NoError(t, os.WriteFile(filepath.Join(binPath, name), []byte("#!/bin/sh\n"+script+"\n"), 0750))
+	}
+	t.Setenv("PATH", binPath+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	viper := viper.New()
+	viper.Set("username", "user1")
+	viper.Set("password", "pass1")
+	viper.Set("exclude_databases", []string{"*_test"})
+
+	dumpPath := t.TempDir()
+	db := &PostgreSQL{Base: newBase(config.ModelConfig{DumpPath: dumpPath}, config.SubConfig{Type: "postgresql", Name: "pg1", Viper: viper})}
+	assert.NoError(t, db.init())
+	assert.Equal(t, "pg_dump --host=localhost --port=5432 --username=user1 --create app", db.buildDatabase("app").String())
+
+	assert.NoError(t, db.perform())
+	for file, expected := range map[string]string{
+		"globals/globals.sql": "globals --host=localhost --port=5432 --username=user1 --globals-only\n",
+		"globals.sql":         "dump globals pass1\n",
+		"app.sql":             "dump app pass1\n",
+		"postgres.sql":        "dump postgres pass1\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(db.dumpPath, file))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(data))
+	}
+	assert.False(t, helper.IsExistsPath(filepath.Join(db.dumpPath, "app_test.sql")))
+
+	assert.NoError(t, db.restore(db.dumpPath))
+	restored, err := os.ReadFile(filepath.Join(binPath, "restored"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"globals/globals.sql", "app.sql", "globals.sql", "postgres.sql"}, strings.Split(strings.ReplaceAll(strings.TrimSpace(string(restored)), db.dumpPath+"/", ""), "\n"))
+
+	viper.Set("tables", []string{"foo"})
+	assert.EqualError(t, db.init(), "PostgreSQL tables config requires database")
+}
+
+func TestPostgreSQL_basebackup(t *testing.T) {
+	viper := viper.New()
+	viper.Set("mode", "basebackup")
+	viper.Set("username", "replicator")
+	viper.Set("password", "pass1")
+	viper.Set("args", "--checkpoint=spread --max-rate=100M")
+
+	db := &PostgreSQL{Base: newBase(config.ModelConfig{DumpPath: "/data/backups"}, config.SubConfig{Type: "postgresql", Name: "pg1", Viper: viper})}
+	assert.NoError(t, db.init())
+
+	cmd := db.buildBasebackup()
+	assert.Equal(t, "pg_basebackup --host=localhost --port=5432 --username=replicator --pgdata=/data/backups/postgresql/pg1 --format=tar --wal-method=stream --checkpoint=fast --label=gobackup --checkpoint=spread --max-rate=100M", cmd.String())
+	assert.Equal(t, []string{"PGPASSWORD=pass1"}, cmd.Env)
+
+	err := db.restore("/tmp/restore/postgresql/pg1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "basebackup can not be loaded into the running server")
+
+	viper.Set("mode", "logical")
+	assert.EqualError(t, db.init(), `PostgreSQL mode "logical" is not supported, it should be dump or basebackup`)
 }
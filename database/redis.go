@@ -165,6 +165,25 @@ func (db *Redis) sync() error {
 	return nil
 }
 
+// restore copy the RDB file back to `rdb_path`, the Redis server must be stopped before restore,
+// otherwise it will be overwritten by the server on shutdown.
+func (db *Redis) restore(dumpPath string) error {
+	logger := logger.Tag("Redis")
+
+	dumpFilePath := path.Join(dumpPath, "dump.rdb")
+	if !helper.IsExistsPath(dumpFilePath) {
+		return fmt.Errorf("dump file %s not found", dumpFilePath)
+	}
+
+	logger.Warn("Please make sure the Redis server has been stopped before restore")
+	logger.Info("Copying redis dump to", db.rdbPath)
+	if _, err := helper.Exec("cp", dumpFilePath, db.rdbPath); err != nil {
+		return fmt.Errorf("copy redis dump file error: %s", err)
+	}
+
+	return nil
+}
+
 func (db *Redis) copy() error {
 	logger := logger.Tag("Redis")
 
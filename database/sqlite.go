@@ -57,3 +57,24 @@ func (db *SQLite) perform() error {
 	logger.Info("dump path:", db._dumpFilePath)
 	return nil
 }
+
+// restore load the `.sql` dump into a new database file at `path`
+func (db *SQLite) restore(dumpPath string) error {
+	logger := logger.Tag("SQLite")
+
+	dumpFilePath := filepath.Join(dumpPath, db.database+".sql")
+	if !helper.IsExistsPath(dumpFilePath) {
+		return fmt.Errorf("dump file %s not found", dumpFilePath)
+	}
+
+	if helper.IsExistsPath(db.path) {
+		return fmt.Errorf("SQLite database %s already exists, please move it away before restore", db.path)
+	}
+
+	logger.Info("-> Restoring SQLite from", dumpFilePath)
+	if _, err := helper.Exec("sqlite3", db.path, ".read "+dumpFilePath); err != nil {
+		return err
+	}
+
+	return nil
+}
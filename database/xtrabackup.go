@@ -0,0 +1,220 @@
+package database
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/manifest"
+	"github.com/itgcloud/gobackup/storage"
+)
+
+// xtrabackup is the physical backup of MySQL by `xtrabackup` and MariaDB by `mariadb-backup`,
+// both of them have the same options.
+//
+//   - The backup is taken into the dump path and prepared, it can be restored by `--copy-back` directly.
+//   - With `stream: true`, the backup is streamed from `--stream=xbstream` into `backup.xbstream`,
+//     it should be extracted by `xbstream -x` (`mbstream -x` for MariaDB) and prepared before restoring.
+//     In `streaming` mode, it flows into the archive by `dumpTo` without written into the dump path.
+//   - With `incremental: true`, the backup is chained off the `to_lsn` of the previous package in the default storage,
+//     and a full backup is taken after `max_incrementals` incremental backups. The backups are not prepared,
+//     they should be prepared with `--apply-log-only` in order when restoring.
+//
+// The LSN, chain and base package are written into `gobackup_backup.json`, and recorded in the manifest,
+// so the retention of storages keeps the packages which the incremental backups are chained off.
+type xtrabackup struct {
+	Base
+	cli             string
+	connectionArgs  []string
+	env             []string
+	database        string
+	args            []string
+	stream          bool
+	incremental     bool
+	maxIncrementals int
+}
+
+const xbstreamFile = "backup.xbstream"
+
+// lastBackupInfo of the database in the latest package of the default storage, and the ID of the package.
+// Replaceable for testing.
+var lastBackupInfo = func(model config.ModelConfig, name string) (*manifest.BackupInfo, string, error) {
+	pkg, err := storage.FindPackage(model, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	m, err := storage.ReadManifest(model, pkg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, db := range m.Databases {
+		if db.Name == name {
+			return db.Backup, manifest.PackageID(pkg.FileKey), nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+func newXtrabackup(base Base, cli string) *xtrabackup {
+	viper := base.viper
+	viper.SetDefault("max_incrementals", 6)
+
+	return &xtrabackup{
+		Base:            base,
+		cli:             cli,
+		stream:          viper.GetBool("stream"),
+		incremental:     viper.GetBool("incremental"),
+		maxIncrementals: viper.GetInt("max_incrementals"),
+	}
+}
+
+// xtrabackupConnectionArgs for xtrabackup and mariadb-backup, the password is passed by MYSQL_PWD
+func xtrabackupConnectionArgs(host, port, socket, username string) []string {
+	var args []string
+	if len(host) > 0 {
+		args = append(args, "--host="+host)
+	}
+	if len(port) > 0 {
+		args = append(args, "--port="+port)
+	}
+	if len(socket) > 0 {
+		args = append(args, "--socket="+socket)
+	}
+	if len(username) > 0 {
+		args = append(args, "--user="+username)
+	}
+
+	return args
+}
+
+// build the backup command, it is incremental when `incrementalLSN` > 0
+func (x *xtrabackup) build(incrementalLSN uint64) helper.Command {
+	args := []string{"--backup"}
+	args = append(args, x.connectionArgs...)
+	args = append(args, x.args...)
+	if len(x.database) > 0 {
+		args = append(args, "--databases="+x.database)
+	}
+	args = append(args, "--target-dir="+x.dumpPath)
+	if x.stream {
+		// Keep xtrabackup_checkpoints in the dump path for the LSN
+		args = append(args, "--stream=xbstream", "--extra-lsndir="+x.dumpPath)
+	}
+	if incrementalLSN > 0 {
+		args = append(args, "--incremental-lsn="+strconv.FormatUint(incrementalLSN, 10))
+	}
+
+	return helper.Command{Name: x.cli, Args: args, Env: x.env}
+}
+
+func (x *xtrabackup) buildPrepare() helper.Command {
+	return helper.Command{Name: x.cli, Args: []string{"--prepare", "--target-dir=" + x.dumpPath}}
+}
+
+// nextBackup decide to take a full or incremental backup by the previous backup
+func (x *xtrabackup) nextBackup() manifest.BackupInfo {
+	logger := logger.Tag(x.cli)
+
+	if !x.incremental {
+		return manifest.BackupInfo{Type: "full"}
+	}
+
+	last, base, err := lastBackupInfo(x.model, x.name)
+	if err != nil {
+		logger.Infof("No previous backup found (%v), take a full backup", err)
+		return manifest.BackupInfo{Type: "full"}
+	}
+	if last == nil || last.ToLSN == 0 {
+		logger.Info("No LSN in the previous backup, take a full backup")
+		return manifest.BackupInfo{Type: "full"}
+	}
+	if x.maxIncrementals > 0 && last.Chain >= x.maxIncrementals {
+		logger.Infof("%d incremental backups since the full backup, take a full backup", last.Chain)
+		return manifest.BackupInfo{Type: "full"}
+	}
+
+	return manifest.BackupInfo{Type: "incremental", FromLSN: last.ToLSN, Chain: last.Chain + 1, Base: base}
+}
+
+func (x *xtrabackup) perform() error {
+	logger := logger.Tag(x.cli)
+
+	info := x.nextBackup()
+	cmd := x.build(info.FromLSN)
+
+	logger.Infof("-> Running %s %s backup...", x.cli, info.Type)
+	if x.stream {
+		if _, err := x.dumpTo(xbstreamFile, cmd); err != nil {
+			return fmt.Errorf("-> Backup error: %s", err)
+		}
+	} else if _, err := cmd.Run(); err != nil {
+		return fmt.Errorf("-> Backup error: %s", err)
+	}
+
+	fromLSN, toLSN, err := readCheckpoints(filepath.Join(x.dumpPath, "xtrabackup_checkpoints"))
+	if err != nil {
+		return err
+	}
+	info.FromLSN, info.ToLSN = fromLSN, toLSN
+
+	// The base of incremental backups must not be prepared without --apply-log-only
+	if !x.stream && !x.incremental {
+		logger.Info("-> Preparing...")
+		if _, err := x.buildPrepare().Run(); err != nil {
+			return fmt.Errorf("-> Prepare error: %s", err)
+		}
+	}
+
+	if err := manifest.WriteBackupInfo(x.dumpPath, info); err != nil {
+		return err
+	}
+	logger.Infof("dump path: %s (%s, LSN %d - %d)", x.dumpPath, info.Type, info.FromLSN, info.ToLSN)
+
+	return nil
+}
+
+// readCheckpoints parse the `from_lsn` and `to_lsn` in xtrabackup_checkpoints
+//
+//	backup_type = full-backuped
+//	from_lsn = 0
+//	to_lsn = 18513421
+func readCheckpoints(checkpointsPath string) (fromLSN, toLSN uint64, err error) {
+	f, err := os.Open(checkpointsPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read checkpoints: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+
+		switch strings.TrimSpace(key) {
+		case "from_lsn":
+			fromLSN, err = strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		case "to_lsn":
+			toLSN, err = strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid checkpoints %s: %v", checkpointsPath, err)
+		}
+	}
+
+	if toLSN == 0 {
+		return 0, 0, fmt.Errorf("to_lsn not found in %s", checkpointsPath)
+	}
+
+	return fromLSN, toLSN, scanner.Err()
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/archive"
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/manifest"
+)
+
+// fakeXtrabackup write a xtrabackup script into PATH, it records the args into `xtrabackup.args`
+// and writes xtrabackup_checkpoints into the `--target-dir` or `--extra-lsndir`
+func fakeXtrabackup(t *testing.T) string {
+	binPath := t.TempDir()
+	script := `#!/bin/sh
+dir=""
+for arg in "$@"; do
+	case "$arg" in
+		--target-dir=*) [ -z "$dir" ] && dir="${arg#--target-dir=}" ;;
+		--extra-lsndir=*) dir="${arg#--extra-lsndir=}" ;;
+	esac
+done
+echo "$@" >> "` + binPath + `/xtrabackup.args"
+case "$1" in
+	--backup)
+		printf 'backup_type = full-backuped\nfrom_lsn = 0\nto_lsn = 2000\n' > "$dir/xtrabackup_checkpoints"
+		echo "xbstream"
+		;;
+esac
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(binPath, "xtrabackup"), []byte(script), 0750))
+	t.Setenv("PATH", binPath+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return filepath.Join(binPath, "xtrabackup.args")
+}
+
+func newTestXtrabackup(t *testing.T, viper *viper.Viper) *MySQL {
+	viper.Set("mode", "xtrabackup")
+	viper.Set("password", "pass1")
+
+	db := &MySQL{Base: newBase(config.ModelConfig{DumpPath: t.TempDir()}, config.SubConfig{Type: "mysql", Name: "mysql1", Viper: viper})}
+	assert.NoError(t, db.init())
+
+	return db
+}
+
+func TestXtrabackup_build(t *testing.T) {
+	v := viper.New()
+	v.Set("database", "app")
+	db := newTestXtrabackup(t, v)
+	dumpPath := db.dumpPath
+
+	cmd := db.physical.build(0)
+	assert.Equal(t, "xtrabackup --backup --host=127.0.0.1 --port=3306 --user=root --databases=app --target-dir="+dumpPath, cmd.String())
+	assert.Equal(t, []string{"MYSQL_PWD=pass1"}, cmd.Env)
+
+	db.physical.stream = true
+	cmd = db.physical.build(1234)
+	assert.Equal(t, "xtrabackup --backup --host=127.0.0.1 --port=3306 --user=root --databases=app --target-dir="+dumpPath+" --stream=xbstream --extra-lsndir="+dumpPath+" --incremental-lsn=1234", cmd.String())
+
+	assert.Equal(t, "xtrabackup --prepare --target-dir="+dumpPath, db.physical.buildPrepare().String())
+
+	v.Set("tables", []string{"foo"})
+	assert.EqualError(t, db.init(), "mysql tables and exclude_tables are not supported in xtrabackup mode")
+	v.Set("tables", nil)
+	v.Set("mode", "foo")
+	assert.EqualError(t, db.init(), `mysql mode "foo" is not supported, it should be dump or xtrabackup`)
+}
+
+func TestXtrabackup_nextBackup(t *testing.T) {
+	var last *manifest.BackupInfo
+	var lastErr error
+	defer func(fn func(config.ModelConfig, string) (*manifest.BackupInfo, string, error)) { lastBackupInfo = fn }(lastBackupInfo)
+	lastBackupInfo = func(model config.ModelConfig, name string) (*manifest.BackupInfo, string, error) {
+		assert.Equal(t, "mysql1", name)
+		return last, "demo-2006-01-02-15-04-05", lastErr
+	}
+
+	v := viper.New()
+	v.Set("max_incrementals", 2)
+	db := newTestXtrabackup(t, v)
+	assert.Equal(t, manifest.BackupInfo{Type: "full"}, db.physical.nextBackup())
+
+	db.physical.incremental = true
+	lastErr = fmt.Errorf("no package found")
+	assert.Equal(t, manifest.BackupInfo{Type: "full"}, db.physical.nextBackup())
+
+	lastErr = nil
+	assert.Equal(t, manifest.BackupInfo{Type: "full"}, db.physical.nextBackup())
+
+	last = &manifest.BackupInfo{Type: "full", ToLSN: 100}
+	assert.Equal(t, manifest.BackupInfo{Type: "incremental", FromLSN: 100, Chain: 1, Base: "demo-2006-01-02-15-04-05"}, db.physical.nextBackup())
+
+	last = &manifest.BackupInfo{Type: "incremental", FromLSN: 100, ToLSN: 200, Chain: 1}
+	assert.Equal(t, manifest.BackupInfo{Type: "incremental", FromLSN: 200, Chain: 2, Base: "demo-2006-01-02-15-04-05"}, db.physical.nextBackup())
+
+	last = &manifest.BackupInfo{Type: "incremental", FromLSN: 200, ToLSN: 300, Chain: 2}
+	assert.Equal(t, manifest.BackupInfo{Type: "full"}, db.physical.nextBackup())
+}
+
+func TestXtrabackup_perform(t *testing.T) {
+	argsPath := fakeXtrabackup(t)
+
+	db := newTestXtrabackup(t, viper.New())
+	assert.NoError(t, db.perform())
+	assert.Equal(t, &manifest.BackupInfo{Type: "full", ToLSN: 2000}, manifest.ReadBackupInfo(db.dumpPath))
+
+	args, err := os.ReadFile(argsPath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(args)), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Equal(t, "--prepare --target-dir="+db.dumpPath, lines[1])
+
+	// incremental backups are streamed and not prepared
+	defer func(fn func(config.ModelConfig, string) (*manifest.BackupInfo, string, error)) { lastBackupInfo = fn }(lastBackupInfo)
+	lastBackupInfo = func(model config.ModelConfig, name string) (*manifest.BackupInfo, string, error) {
+		return &manifest.BackupInfo{Type: "full", ToLSN: 1500}, "demo-2006-01-02-15-04-05", nil
+	}
+
+	v := viper.New()
+	v.Set("stream", true)
+	v.Set("incremental", true)
+	db = newTestXtrabackup(t, v)
+	assert.NoError(t, os.Remove(argsPath))
+	assert.NoError(t, db.perform())
+	assert.Equal(t, &manifest.BackupInfo{Type: "incremental", FromLSN: 0, ToLSN: 2000, Chain: 1, Base: "demo-2006-01-02-15-04-05"}, manifest.ReadBackupInfo(db.dumpPath))
+
+	data, err := os.ReadFile(filepath.Join(db.dumpPath, xbstreamFile))
+	assert.NoError(t, err)
+	assert.Equal(t, "xbstream\n", string(data))
+
+	args, err = os.ReadFile(argsPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(args), "--incremental-lsn=1500")
+	assert.NotContains(t, string(args), "--prepare")
+
+	assert.EqualError(t, db.restore(db.dumpPath), "xtrabackup can not be loaded into the running server, prepare "+db.dumpPath+" and restore it by `xtrabackup --copy-back` instead")
+}
+
+func TestXtrabackup_performStreaming(t *testing.T) {
+	fakeXtrabackup(t)
+
+	v := viper.New()
+	v.Set("stream", true)
+	db := newTestXtrabackup(t, v)
+
+	var buf bytes.Buffer
+	db.physical.dumps = archive.NewDumpWriter(&buf)
+	assert.NoError(t, db.perform())
+
+	// The xbstream flows into the archive, only the checkpoints and backup info are in the dump path
+	assert.False(t, helper.IsExistsPath(filepath.Join(db.dumpPath, xbstreamFile)))
+	assert.Equal(t, &manifest.BackupInfo{Type: "full", ToLSN: 2000}, manifest.ReadBackupInfo(db.dumpPath))
+	assert.Equal(t, []manifest.StreamedDump{{Name: xbstreamFile, Size: 9}}, manifest.ReadStreamed(db.dumpPath))
+
+	target := t.TempDir()
+	assert.NoError(t, archive.Extract(&buf, target))
+	data, err := os.ReadFile(filepath.Join(target, db.dumpPath, xbstreamFile))
+	assert.NoError(t, err)
+	assert.Equal(t, "xbstream\n", string(data))
+}
+
+func TestReadCheckpoints(t *testing.T) {
+	checkpointsPath := filepath.Join(t.TempDir(), "xtrabackup_checkpoints")
+
+	_, _, err := readCheckpoints(checkpointsPath)
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(checkpointsPath, []byte("backup_type = incremental\nfrom_lsn = 100\nto_lsn = 200\nlast_lsn = 210\n"), 0640))
+	fromLSN, toLSN, err := readCheckpoints(checkpointsPath)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), fromLSN)
+	assert.Equal(t, uint64(200), toLSN)
+
+	assert.NoError(t, os.WriteFile(checkpointsPath, []byte("backup_type = full-backuped\n"), 0640))
+	_, _, err = readCheckpoints(checkpointsPath)
+	assert.EqualError(t, err, "to_lsn not found in "+checkpointsPath)
+}
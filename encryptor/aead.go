@@ -0,0 +1,500 @@
+package encryptor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/itgcloud/gobackup/helper"
+)
+
+// AEAD encryptor for authenticated streaming encryption.
+//
+// Each archive is encrypted with a random data key in chunks, and the data key is wrapped by the master key.
+// The wrapped data key is saved in the header, so the master key can be rotated by KMS or other tools outside.
+//
+// - cipher: aes-256-gcm (default), chacha20-poly1305
+// - key_file: the master key file, 32 bytes in raw, hex or base64
+// - key_command: the command to print the master key, e.g.: `vault kv get -field=key secret/gobackup`
+// - chunk_size: 64KiB (default)
+type AEAD struct {
+	Base
+	cipher      string
+	keyFile     string
+	keyCommand  string
+	chunkSize   string
+	encryptPath string
+}
+
+const (
+	aeadMagic   = "GOBACKUP"
+	aeadVersion = 1
+
+	aeadKeySize          = 32
+	aeadKeyIDSize        = 8
+	aeadNoncePrefixSize  = 7
+	aeadMaxChunkSize     = 64 << 20
+	aeadDefaultChunkSize = 64 << 10
+)
+
+var aeadCiphers = map[string]byte{
+	"aes-256-gcm":       1,
+	"chacha20-poly1305": 2,
+}
+
+var errAEADAuthFailed = errors.New("message authentication failed, the backup has been tampered or truncated")
+
+func NewAEAD(base *Base) *AEAD {
+	base.viper.SetDefault("cipher", "aes-256-gcm")
+
+	return &AEAD{
+		Base:        *base,
+		cipher:      base.viper.GetString("cipher"),
+		keyFile:     base.viper.GetString("key_file"),
+		keyCommand:  base.viper.GetString("key_command"),
+		chunkSize:   base.viper.GetString("chunk_size"),
+		encryptPath: base.archivePath + ".enc",
+	}
+}
+
+func (enc *AEAD) perform() (encryptPath string, err error) {
+	if err := transformFile(enc.archivePath, enc.encryptPath, enc.encrypt); err != nil {
+		return "", fmt.Errorf("AEAD encrypt failed: %v", err)
+	}
+
+	return enc.encryptPath, nil
+}
+
+func (enc *AEAD) stream(reader io.Reader) (io.ReadCloser, error) {
+	// Load the master key first to return the config error immediately
+	if _, err := enc.masterKey(); err != nil {
+		return nil, err
+	}
+
+	return transformStream(reader, enc.encrypt), nil
+}
+
+func (enc *AEAD) decrypt() (decryptPath string, err error) {
+	decryptPath = enc.decryptPath()
+	if err := transformFile(enc.archivePath, decryptPath, enc.decryptTo); err != nil {
+		return "", fmt.Errorf("AEAD decrypt failed: %v", err)
+	}
+
+	return decryptPath, nil
+}
+
+func (enc *AEAD) decryptStream(reader io.Reader) (io.ReadCloser, error) {
+	return transformStream(reader, enc.decryptTo), nil
+}
+
+func (enc *AEAD) encrypt(w io.Writer, r io.Reader) error {
+	cipherID, ok := aeadCiphers[enc.cipher]
+	if !ok {
+		return fmt.Errorf("unsupported cipher: %s", enc.cipher)
+	}
+
+	chunkSize := int64(aeadDefaultChunkSize)
+	if len(enc.chunkSize) > 0 {
+		var err error
+		if chunkSize, err = helper.ParseSize(enc.chunkSize); err != nil {
+			return fmt.Errorf("invalid chunk_size: %v", err)
+		}
+		if chunkSize <= 0 || chunkSize > aeadMaxChunkSize {
+			return fmt.Errorf("chunk_size must be between 1B and 64MiB")
+		}
+	}
+
+	masterKey, err := enc.masterKey()
+	if err != nil {
+		return err
+	}
+
+	ew, err := newAEADWriter(w, masterKey, cipherID, int(chunkSize))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(ew, r); err != nil {
+		return err
+	}
+
+	return ew.Close()
+}
+
+func (enc *AEAD) decryptTo(w io.Writer, r io.Reader) error {
+	masterKey, err := enc.masterKey()
+	if err != nil {
+		return err
+	}
+
+	dr, err := newAEADReader(r, masterKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, dr)
+	return err
+}
+
+// masterKey load the master key from key_file or key_command
+func (enc *AEAD) masterKey() ([]byte, error) {
+	var data []byte
+	switch {
+	case len(enc.keyFile) > 0:
+		var err error
+		if data, err = os.ReadFile(helper.AbsolutePath(enc.keyFile)); err != nil {
+			return nil, err
+		}
+	case len(enc.keyCommand) > 0:
+		output, err := helper.Exec("sh", "-c", enc.keyCommand)
+		if err != nil {
+			return nil, fmt.Errorf("key_command failed: %s", strings.TrimSpace(err.Error()))
+		}
+		data = []byte(output)
+	default:
+		return nil, fmt.Errorf("key_file or key_command option is required")
+	}
+
+	return parseKey(data)
+}
+
+// parseKey parse the key in raw, hex or base64
+func parseKey(data []byte) ([]byte, error) {
+	if len(data) == aeadKeySize {
+		return data, nil
+	}
+
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == aeadKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == aeadKeySize {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("master key must be %d bytes in raw, hex or base64", aeadKeySize)
+}
+
+func newAEAD(cipherID byte, key []byte) (cipher.AEAD, error) {
+	switch cipherID {
+	case aeadCiphers["aes-256-gcm"]:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case aeadCiphers["chacha20-poly1305"]:
+		return chacha20poly1305.New(key)
+	}
+
+	return nil, fmt.Errorf("unsupported cipher id: %d", cipherID)
+}
+
+func keyID(masterKey []byte) []byte {
+	sum := sha256.Sum256(masterKey)
+	return sum[:aeadKeyIDSize]
+}
+
+// The header of the encrypted file, it is also the additional data of each chunk:
+//
+//	magic (8) | version (1) | cipher (1) | chunk size (4) | key id (8) | wrapped key length (2) | wrapped key | nonce prefix (7)
+//
+// The nonce of each chunk is: nonce prefix (7) | counter (4) | last chunk flag (1), which is the STREAM construction.
+type aeadHeader struct {
+	cipherID    byte
+	chunkSize   int
+	keyID       []byte
+	wrappedKey  []byte
+	noncePrefix []byte
+}
+
+func (h *aeadHeader) bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(aeadMagic)
+	buf.WriteByte(aeadVersion)
+	buf.WriteByte(h.cipherID)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(h.chunkSize))
+	buf.Write(h.keyID)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(h.wrappedKey)))
+	buf.Write(h.wrappedKey)
+	buf.Write(h.noncePrefix)
+	return buf.Bytes()
+}
+
+// wrapAAD returns the additional data for wrap the data key
+func (h *aeadHeader) wrapAAD() []byte {
+	return append([]byte(aeadMagic), aeadVersion, h.cipherID)
+}
+
+func readAEADHeader(r io.Reader) (*aeadHeader, []byte, error) {
+	var raw bytes.Buffer
+	r = io.TeeReader(r, &raw)
+
+	fixed := make([]byte, len(aeadMagic)+1+1+4+aeadKeyIDSize+2)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, nil, fmt.Errorf("read header: %v", err)
+	}
+	if string(fixed[:len(aeadMagic)]) != aeadMagic {
+		return nil, nil, fmt.Errorf("invalid file, it is not encrypted by AEAD encryptor")
+	}
+	fixed = fixed[len(aeadMagic):]
+	if fixed[0] != aeadVersion {
+		return nil, nil, fmt.Errorf("unsupported version: %d", fixed[0])
+	}
+
+	h := &aeadHeader{
+		cipherID:  fixed[1],
+		chunkSize: int(binary.BigEndian.Uint32(fixed[2:6])),
+		keyID:     fixed[6 : 6+aeadKeyIDSize],
+	}
+	if h.chunkSize <= 0 || h.chunkSize > aeadMaxChunkSize {
+		return nil, nil, fmt.Errorf("invalid chunk size: %d", h.chunkSize)
+	}
+
+	rest := make([]byte, int(binary.BigEndian.Uint16(fixed[6+aeadKeyIDSize:]))+aeadNoncePrefixSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, fmt.Errorf("read header: %v", err)
+	}
+	h.wrappedKey = rest[:len(rest)-aeadNoncePrefixSize]
+	h.noncePrefix = rest[len(rest)-aeadNoncePrefixSize:]
+
+	return h, raw.Bytes(), nil
+}
+
+type aeadWriter struct {
+	w           io.Writer
+	aead        cipher.AEAD
+	header      []byte
+	noncePrefix []byte
+	counter     uint32
+	buf         []byte
+	chunkSize   int
+	closed      bool
+}
+
+// newAEADWriter write the header with the wrapped data key, and returns a writer to encrypt the data in chunks.
+// Close must be called to write the last chunk.
+func newAEADWriter(w io.Writer, masterKey []byte, cipherID byte, chunkSize int) (io.WriteCloser, error) {
+	dataKey := make([]byte, aeadKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	h := &aeadHeader{
+		cipherID:    cipherID,
+		chunkSize:   chunkSize,
+		keyID:       keyID(masterKey),
+		noncePrefix: make([]byte, aeadNoncePrefixSize),
+	}
+	if _, err := rand.Read(h.noncePrefix); err != nil {
+		return nil, err
+	}
+
+	// Wrap the data key by the master key
+	kek, err := newAEAD(cipherID, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	h.wrappedKey = kek.Seal(nonce, nonce, dataKey, h.wrapAAD())
+
+	dek, err := newAEAD(cipherID, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := h.bytes()
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &aeadWriter{
+		w:           w,
+		aead:        dek,
+		header:      header,
+		noncePrefix: h.noncePrefix,
+		buf:         make([]byte, 0, chunkSize),
+		chunkSize:   chunkSize,
+	}, nil
+}
+
+func (aw *aeadWriter) Write(p []byte) (int, error) {
+	if aw.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// Only flush the full chunk when there is more data, the last chunk is written in Close
+		if len(aw.buf) == aw.chunkSize {
+			if err := aw.flush(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(aw.buf[len(aw.buf):aw.chunkSize], p)
+		aw.buf = aw.buf[:len(aw.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (aw *aeadWriter) Close() error {
+	if aw.closed {
+		return nil
+	}
+	aw.closed = true
+
+	return aw.flush(true)
+}
+
+func (aw *aeadWriter) flush(last bool) error {
+	nonce, err := chunkNonce(aw.noncePrefix, aw.counter, last)
+	if err != nil {
+		return err
+	}
+
+	if _, err := aw.w.Write(aw.aead.Seal(nil, nonce, aw.buf, aw.header)); err != nil {
+		return err
+	}
+
+	aw.counter++
+	aw.buf = aw.buf[:0]
+	return nil
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) ([]byte, error) {
+	if counter == ^uint32(0) {
+		return nil, fmt.Errorf("too many chunks, please increase chunk_size")
+	}
+
+	nonce := make([]byte, 0, aeadNoncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1), nil
+	}
+
+	return append(nonce, 0), nil
+}
+
+type aeadReader struct {
+	r           *bufio.Reader
+	aead        cipher.AEAD
+	header      []byte
+	noncePrefix []byte
+	counter     uint32
+	buf         []byte
+	plain       []byte
+	done        bool
+}
+
+// newAEADReader read the header and unwrap the data key, and returns a reader to decrypt and verify the chunks.
+// The error will be returned by Read if any chunk has been tampered, reordered or truncated.
+func newAEADReader(r io.Reader, masterKey []byte) (io.Reader, error) {
+	h, header, err := readAEADHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(h.keyID, keyID(masterKey)) {
+		return nil, fmt.Errorf("the master key does not match the key of the encrypted file")
+	}
+
+	kek, err := newAEAD(h.cipherID, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.wrappedKey) < kek.NonceSize() {
+		return nil, errAEADAuthFailed
+	}
+	nonce, wrapped := h.wrappedKey[:kek.NonceSize()], h.wrappedKey[kek.NonceSize():]
+	dataKey, err := kek.Open(nil, nonce, wrapped, h.wrapAAD())
+	if err != nil {
+		return nil, errAEADAuthFailed
+	}
+
+	dek, err := newAEAD(h.cipherID, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &aeadReader{
+		r:           bufio.NewReader(r),
+		aead:        dek,
+		header:      header,
+		noncePrefix: h.noncePrefix,
+		buf:         make([]byte, h.chunkSize+dek.Overhead()),
+	}, nil
+}
+
+func (ar *aeadReader) Read(p []byte) (int, error) {
+	for len(ar.plain) == 0 {
+		if ar.done {
+			return 0, io.EOF
+		}
+
+		if err := ar.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, ar.plain)
+	ar.plain = ar.plain[n:]
+	return n, nil
+}
+
+func (ar *aeadReader) readChunk() error {
+	n, err := io.ReadFull(ar.r, ar.buf)
+	last := false
+	switch err {
+	case nil:
+		// The last chunk may be full, check if there is more data
+		if _, err := ar.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+
+	if n < ar.aead.Overhead() {
+		return errAEADAuthFailed
+	}
+
+	nonce, err := chunkNonce(ar.noncePrefix, ar.counter, last)
+	if err != nil {
+		return err
+	}
+
+	plain, err := ar.aead.Open(ar.buf[:0], nonce, ar.buf[:n], ar.header)
+	if err != nil {
+		return errAEADAuthFailed
+	}
+
+	ar.counter++
+	ar.plain = plain
+	ar.done = last
+	return nil
+}
@@ -0,0 +1,161 @@
+package encryptor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+)
+
+func aeadEncrypt(t *testing.T, masterKey []byte, cipherID byte, chunkSize int, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := newAEADWriter(&buf, masterKey, cipherID, chunkSize)
+	assert.NoError(t, err)
+	_, err = w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func aeadDecrypt(masterKey []byte, encrypted []byte) ([]byte, error) {
+	r, err := newAEADReader(bytes.NewReader(encrypted), masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestAEAD_roundtrip(t *testing.T) {
+	masterKey := make([]byte, 32)
+	_, _ = rand.Read(masterKey)
+
+	for _, cipherID := range aeadCiphers {
+		for _, size := range []int{0, 1, 16, 17, 48, 100} {
+			data := make([]byte, size)
+			_, _ = rand.Read(data)
+
+			out, err := aeadDecrypt(masterKey, aeadEncrypt(t, masterKey, cipherID, 16, data))
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(data, out))
+		}
+	}
+}
+
+func TestAEAD_tampered(t *testing.T) {
+	masterKey := make([]byte, 32)
+	_, _ = rand.Read(masterKey)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	encrypted := aeadEncrypt(t, masterKey, aeadCiphers["aes-256-gcm"], 16, data)
+	chunkLen := 16 + 16
+	// the last full chunk is flushed with the last flag, there is no empty chunk
+	headerLen := len(encrypted) - 4*chunkLen
+
+	// flip a byte in each chunk
+	for i := headerLen; i < len(encrypted); i += chunkLen {
+		tampered := bytes.Clone(encrypted)
+		tampered[i] ^= 0x01
+		_, err := aeadDecrypt(masterKey, tampered)
+		assert.Equal(t, errAEADAuthFailed, err)
+	}
+
+	// flip the nonce prefix in header
+	tampered := bytes.Clone(encrypted)
+	tampered[headerLen-1] ^= 0x01
+	_, err := aeadDecrypt(masterKey, tampered)
+	assert.Equal(t, errAEADAuthFailed, err)
+
+	// truncated at chunk boundary
+	_, err = aeadDecrypt(masterKey, encrypted[:headerLen+2*chunkLen])
+	assert.Equal(t, errAEADAuthFailed, err)
+
+	// appended data
+	_, err = aeadDecrypt(masterKey, append(bytes.Clone(encrypted), encrypted[headerLen:headerLen+chunkLen]...))
+	assert.Equal(t, errAEADAuthFailed, err)
+
+	// swap chunks
+	tampered = bytes.Clone(encrypted)
+	copy(tampered[headerLen:], encrypted[headerLen+chunkLen:headerLen+2*chunkLen])
+	copy(tampered[headerLen+chunkLen:], encrypted[headerLen:headerLen+chunkLen])
+	_, err = aeadDecrypt(masterKey, tampered)
+	assert.Equal(t, errAEADAuthFailed, err)
+
+	// wrong master key
+	otherKey := make([]byte, 32)
+	_, err = aeadDecrypt(otherKey, encrypted)
+	assert.EqualError(t, err, "the master key does not match the key of the encrypted file")
+
+	// not encrypted
+	_, err = aeadDecrypt(masterKey, data)
+	assert.EqualError(t, err, "invalid file, it is not encrypted by AEAD encryptor")
+}
+
+func TestParseKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, 32)
+
+	out, err := parseKey(key)
+	assert.NoError(t, err)
+	assert.Equal(t, key, out)
+
+	out, err = parseKey([]byte(hex.EncodeToString(key) + "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, key, out)
+
+	out, err = parseKey([]byte("q6urq6urq6urq6urq6urq6urq6urq6urq6urq6urq6s="))
+	assert.NoError(t, err)
+	assert.Equal(t, key, out)
+
+	_, err = parseKey([]byte("short"))
+	assert.EqualError(t, err, "master key must be 32 bytes in raw, hex or base64")
+}
+
+func TestAEAD(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "master.key")
+	assert.NoError(t, os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)), 0600))
+
+	archivePath := filepath.Join(dir, "foo.tar.gz")
+	assert.NoError(t, os.WriteFile(archivePath, []byte("hello aead"), 0600))
+
+	base := &Base{viper: viper.New(), archivePath: archivePath}
+	_, err := NewAEAD(base).perform()
+	assert.EqualError(t, err, "AEAD encrypt failed: key_file or key_command option is required")
+
+	base.viper.Set("cipher", "chacha20-poly1305")
+	base.viper.Set("chunk_size", "4KiB")
+	base.viper.Set("key_file", keyFile)
+	encryptPath, err := NewAEAD(base).perform()
+	assert.NoError(t, err)
+	assert.Equal(t, archivePath+".enc", encryptPath)
+
+	// decrypt with key_command
+	assert.NoError(t, os.Remove(archivePath))
+	base = &Base{viper: viper.New(), archivePath: encryptPath}
+	base.viper.Set("key_command", "cat "+keyFile)
+	decryptPath, err := NewAEAD(base).decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, archivePath, decryptPath)
+	data, err := os.ReadFile(decryptPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello aead", string(data))
+
+	// stream
+	reader, err := NewAEAD(base).stream(strings.NewReader("hello stream"))
+	assert.NoError(t, err)
+	encrypted, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	out, err := aeadDecrypt(bytes.Repeat([]byte{0xab}, 32), encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello stream", string(out))
+
+	base.viper.Set("key_command", "false")
+	_, err = NewAEAD(base).stream(strings.NewReader(""))
+	assert.Error(t, err)
+}
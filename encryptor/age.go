@@ -0,0 +1,130 @@
+package encryptor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/itgcloud/gobackup/helper"
+)
+
+// Age encryptor for use age (https://age-encryption.org) with X25519 recipients
+//
+// - recipients: the public keys, e.g.: age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+// - recipients_file: the file of public keys, one per line
+// - identity_file: the private key file for decrypt, only required on the restore side
+type Age struct {
+	Base
+	recipients     []string
+	recipientsFile string
+	identityFile   string
+	encryptPath    string
+}
+
+func NewAge(base *Base) *Age {
+	return &Age{
+		Base:           *base,
+		recipients:     base.viper.GetStringSlice("recipients"),
+		recipientsFile: base.viper.GetString("recipients_file"),
+		identityFile:   base.viper.GetString("identity_file"),
+		encryptPath:    base.archivePath + ".enc",
+	}
+}
+
+func (enc *Age) perform() (encryptPath string, err error) {
+	if err := transformFile(enc.archivePath, enc.encryptPath, enc.encrypt); err != nil {
+		return "", fmt.Errorf("age encrypt failed: %v", err)
+	}
+
+	return enc.encryptPath, nil
+}
+
+func (enc *Age) stream(reader io.Reader) (io.ReadCloser, error) {
+	// Parse recipients first to return the config error immediately
+	if _, err := enc.parseRecipients(); err != nil {
+		return nil, err
+	}
+
+	return transformStream(reader, enc.encrypt), nil
+}
+
+func (enc *Age) decrypt() (decryptPath string, err error) {
+	decryptPath = enc.decryptPath()
+	if err := transformFile(enc.archivePath, decryptPath, enc.decryptTo); err != nil {
+		return "", fmt.Errorf("age decrypt failed: %v", err)
+	}
+
+	return decryptPath, nil
+}
+
+func (enc *Age) decryptStream(reader io.Reader) (io.ReadCloser, error) {
+	return transformStream(reader, enc.decryptTo), nil
+}
+
+func (enc *Age) encrypt(w io.Writer, r io.Reader) error {
+	recipients, err := enc.parseRecipients()
+	if err != nil {
+		return err
+	}
+
+	ew, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(ew, r); err != nil {
+		return err
+	}
+
+	return ew.Close()
+}
+
+func (enc *Age) decryptTo(w io.Writer, r io.Reader) error {
+	if len(enc.identityFile) == 0 {
+		return fmt.Errorf("identity_file option is required")
+	}
+
+	f, err := os.Open(helper.AbsolutePath(enc.identityFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return fmt.Errorf("parse identity_file: %v", err)
+	}
+
+	dr, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, dr)
+	return err
+}
+
+func (enc *Age) parseRecipients() ([]age.Recipient, error) {
+	lines := enc.recipients
+	if len(enc.recipientsFile) > 0 {
+		data, err := os.ReadFile(helper.AbsolutePath(enc.recipientsFile))
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, string(data))
+	}
+
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("recipients or recipients_file option is required")
+	}
+
+	recipients, err := age.ParseRecipients(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		return nil, fmt.Errorf("parse recipients: %v", err)
+	}
+
+	return recipients, nil
+}
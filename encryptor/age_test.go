@@ -0,0 +1,65 @@
+package encryptor
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+)
+
+func TestAge(t *testing.T) {
+	dir := t.TempDir()
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+
+	identityFile := filepath.Join(dir, "key.txt")
+	assert.NoError(t, os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600))
+
+	archivePath := filepath.Join(dir, "foo.tar.gz")
+	assert.NoError(t, os.WriteFile(archivePath, []byte("hello age"), 0600))
+
+	base := &Base{viper: viper.New(), archivePath: archivePath}
+	enc := NewAge(base)
+	_, err = enc.perform()
+	assert.EqualError(t, err, "age encrypt failed: recipients or recipients_file option is required")
+
+	base.viper.Set("recipients", []string{identity.Recipient().String()})
+	enc = NewAge(base)
+	encryptPath, err := enc.perform()
+	assert.NoError(t, err)
+	assert.Equal(t, archivePath+".enc", encryptPath)
+
+	// decrypt on the restore side
+	assert.NoError(t, os.Remove(archivePath))
+	base = &Base{viper: viper.New(), archivePath: encryptPath}
+	enc = NewAge(base)
+	_, err = enc.decrypt()
+	assert.EqualError(t, err, "age decrypt failed: identity_file option is required")
+
+	base.viper.Set("identity_file", identityFile)
+	enc = NewAge(base)
+	decryptPath, err := enc.decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, archivePath, decryptPath)
+	data, err := os.ReadFile(decryptPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello age", string(data))
+
+	// stream
+	recipientsFile := filepath.Join(dir, "recipients.txt")
+	assert.NoError(t, os.WriteFile(recipientsFile, []byte("# backup\n"+identity.Recipient().String()+"\n"), 0600))
+	base = &Base{viper: viper.New()}
+	base.viper.Set("recipients_file", recipientsFile)
+	reader, err := NewAge(base).stream(strings.NewReader("hello stream"))
+	assert.NoError(t, err)
+	dr, err := age.Decrypt(reader, identity)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(dr)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello stream", string(data))
+}
@@ -1,6 +1,10 @@
 package encryptor
 
 import (
+	"io"
+	"os"
+	"strings"
+
 	"github.com/itgcloud/gobackup/config"
 	"github.com/itgcloud/gobackup/logger"
 	"github.com/spf13/viper"
@@ -16,6 +20,11 @@ type Base struct {
 // Encryptor interface
 type Encryptor interface {
 	perform() (encryptPath string, err error)
+	decrypt() (decryptPath string, err error)
+	// stream encrypt the reader without writing to the disk
+	stream(reader io.Reader) (io.ReadCloser, error)
+	// decryptStream decrypt the reader without writing to the disk
+	decryptStream(reader io.Reader) (io.ReadCloser, error)
 }
 
 func newBase(archivePath string, model config.ModelConfig) (base *Base) {
@@ -27,16 +36,75 @@ func newBase(archivePath string, model config.ModelConfig) (base *Base) {
 	return
 }
 
+// newEncryptor returns nil if the encrypt_with type is not supported
+func newEncryptor(base *Base) Encryptor {
+	switch base.model.EncryptWith.Type {
+	case "openssl":
+		return NewOpenSSL(base)
+	case "age":
+		return NewAge(base)
+	case "gpg":
+		return NewGPG(base)
+	case "aead":
+		return NewAEAD(base)
+	}
+
+	return nil
+}
+
+// decryptPath remove the `.enc` suffix from archivePath, or append `.dec` if there is no `.enc` suffix
+func (b *Base) decryptPath() string {
+	decryptPath := strings.TrimSuffix(b.archivePath, ".enc")
+	if decryptPath == b.archivePath {
+		decryptPath = b.archivePath + ".dec"
+	}
+
+	return decryptPath
+}
+
+// transformFile read the src file and write into dst file with the transform function
+func transformFile(src, dst string, transform func(w io.Writer, r io.Reader) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := transform(out, in); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return out.Close()
+}
+
+// transformStream returns a reader of the transformed reader
+func transformStream(reader io.Reader, transform func(w io.Writer, r io.Reader) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(transform(pw, reader))
+	}()
+
+	return pr
+}
+
+// Enabled returns true if the `encrypt_with` type is supported, the encrypted file has `.enc` suffix
+func Enabled(model config.ModelConfig) bool {
+	return newEncryptor(newBase("", model)) != nil
+}
+
 // Run compressor
 func Run(archivePath string, model config.ModelConfig) (encryptPath string, err error) {
 	logger := logger.Tag("Encryptor")
 
-	base := newBase(archivePath, model)
-	var enc Encryptor
-	switch model.EncryptWith.Type {
-	case "openssl":
-		enc = NewOpenSSL(base)
-	default:
+	enc := newEncryptor(newBase(archivePath, model))
+	if enc == nil {
 		encryptPath = archivePath
 		return
 	}
@@ -53,3 +121,54 @@ func Run(archivePath string, model config.ModelConfig) (encryptPath string, err
 
 	return
 }
+
+// Stream encryptor, return the encrypted file name and the reader
+func Stream(archiveName string, reader io.Reader, model config.ModelConfig) (string, io.ReadCloser, error) {
+	logger := logger.Tag("Encryptor")
+
+	enc := newEncryptor(newBase(archiveName, model))
+	if enc == nil {
+		return archiveName, io.NopCloser(reader), nil
+	}
+
+	logger.Info("encrypt | " + model.EncryptWith.Type + " (stream)")
+	encReader, err := enc.stream(reader)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// save Extension
+	model.Viper.Set("Ext", model.Viper.GetString("Ext")+".enc")
+
+	return archiveName + ".enc", encReader, nil
+}
+
+// Decrypt the encrypted file with the `encrypt_with` config, return decrypted path
+func Decrypt(encryptPath string, model config.ModelConfig) (decryptPath string, err error) {
+	logger := logger.Tag("Encryptor")
+
+	enc := newEncryptor(newBase(encryptPath, model))
+	if enc == nil {
+		decryptPath = encryptPath
+		return
+	}
+
+	logger.Info("decrypt | " + model.EncryptWith.Type)
+	decryptPath, err = enc.decrypt()
+	if err != nil {
+		return
+	}
+	logger.Info("decrypted:", decryptPath)
+
+	return
+}
+
+// DecryptStream decrypt the reader with the `encrypt_with` config, the reader will be returned as is if no encryptor
+func DecryptStream(reader io.Reader, model config.ModelConfig) (io.ReadCloser, error) {
+	enc := newEncryptor(newBase("", model))
+	if enc == nil {
+		return io.NopCloser(reader), nil
+	}
+
+	return enc.decryptStream(reader)
+}
@@ -0,0 +1,156 @@
+package encryptor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+
+	"github.com/itgcloud/gobackup/helper"
+)
+
+// GPG encryptor for use OpenPGP public keys, the encrypted file can be decrypted by `gpg -d`
+//
+// - public_key: the armored public key
+// - public_key_file: the public key file, armored or binary
+// - private_key_file: the private key file for decrypt, only required on the restore side
+// - passphrase: the passphrase of the private key
+type GPG struct {
+	Base
+	publicKey      string
+	publicKeyFile  string
+	privateKeyFile string
+	passphrase     string
+	encryptPath    string
+}
+
+func NewGPG(base *Base) *GPG {
+	return &GPG{
+		Base:           *base,
+		publicKey:      base.viper.GetString("public_key"),
+		publicKeyFile:  base.viper.GetString("public_key_file"),
+		privateKeyFile: base.viper.GetString("private_key_file"),
+		passphrase:     base.viper.GetString("passphrase"),
+		encryptPath:    base.archivePath + ".enc",
+	}
+}
+
+func (enc *GPG) perform() (encryptPath string, err error) {
+	if err := transformFile(enc.archivePath, enc.encryptPath, enc.encrypt); err != nil {
+		return "", fmt.Errorf("GPG encrypt failed: %v", err)
+	}
+
+	return enc.encryptPath, nil
+}
+
+func (enc *GPG) stream(reader io.Reader) (io.ReadCloser, error) {
+	// Read public keys first to return the config error immediately
+	if _, err := enc.publicKeys(); err != nil {
+		return nil, err
+	}
+
+	return transformStream(reader, enc.encrypt), nil
+}
+
+func (enc *GPG) decrypt() (decryptPath string, err error) {
+	decryptPath = enc.decryptPath()
+	if err := transformFile(enc.archivePath, decryptPath, enc.decryptTo); err != nil {
+		return "", fmt.Errorf("GPG decrypt failed: %v", err)
+	}
+
+	return decryptPath, nil
+}
+
+func (enc *GPG) decryptStream(reader io.Reader) (io.ReadCloser, error) {
+	return transformStream(reader, enc.decryptTo), nil
+}
+
+func (enc *GPG) encrypt(w io.Writer, r io.Reader) error {
+	keys, err := enc.publicKeys()
+	if err != nil {
+		return err
+	}
+
+	// The archive has been compressed, so disable the compression of OpenPGP
+	config := &packet.Config{
+		DefaultCipher:          packet.CipherAES256,
+		DefaultCompressionAlgo: packet.CompressionNone,
+	}
+
+	ew, err := openpgp.Encrypt(w, keys, nil, &openpgp.FileHints{IsBinary: true}, config)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(ew, r); err != nil {
+		return err
+	}
+
+	return ew.Close()
+}
+
+func (enc *GPG) decryptTo(w io.Writer, r io.Reader) error {
+	if len(enc.privateKeyFile) == 0 {
+		return fmt.Errorf("private_key_file option is required")
+	}
+
+	data, err := os.ReadFile(helper.AbsolutePath(enc.privateKeyFile))
+	if err != nil {
+		return err
+	}
+
+	keys, err := readKeyRing(data)
+	if err != nil {
+		return fmt.Errorf("read private_key_file: %v", err)
+	}
+
+	if len(enc.passphrase) > 0 {
+		for _, key := range keys {
+			if err := key.DecryptPrivateKeys([]byte(enc.passphrase)); err != nil {
+				return fmt.Errorf("decrypt private key: %v", err)
+			}
+		}
+	}
+
+	md, err := openpgp.ReadMessage(r, keys, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	// The integrity will be checked when read to the EOF
+	_, err = io.Copy(w, md.UnverifiedBody)
+	return err
+}
+
+func (enc *GPG) publicKeys() (openpgp.EntityList, error) {
+	data := []byte(enc.publicKey)
+	if len(enc.publicKeyFile) > 0 {
+		var err error
+		if data, err = os.ReadFile(helper.AbsolutePath(enc.publicKeyFile)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("public_key or public_key_file option is required")
+	}
+
+	keys, err := readKeyRing(data)
+	if err != nil {
+		return nil, fmt.Errorf("read public key: %v", err)
+	}
+
+	return keys, nil
+}
+
+// readKeyRing read the armored or binary keys
+func readKeyRing(data []byte) (openpgp.EntityList, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
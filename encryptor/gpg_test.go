@@ -0,0 +1,96 @@
+package encryptor
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+)
+
+func generateGPGKeys(t *testing.T, dir, passphrase string) (publicKey string, privateKeyFile string) {
+	entity, err := openpgp.NewEntity("gobackup", "", "gobackup@example.com", nil)
+	assert.NoError(t, err)
+
+	var pub bytes.Buffer
+	w, err := armor.Encode(&pub, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, entity.Serialize(w))
+	assert.NoError(t, w.Close())
+
+	assert.NoError(t, entity.EncryptPrivateKeys([]byte(passphrase), nil))
+	var priv bytes.Buffer
+	assert.NoError(t, entity.SerializePrivateWithoutSigning(&priv, nil))
+
+	privateKeyFile = filepath.Join(dir, "private.gpg")
+	assert.NoError(t, os.WriteFile(privateKeyFile, priv.Bytes(), 0600))
+
+	return pub.String(), privateKeyFile
+}
+
+func TestGPG(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, privateKeyFile := generateGPGKeys(t, dir, "secret")
+
+	archivePath := filepath.Join(dir, "foo.tar.gz")
+	assert.NoError(t, os.WriteFile(archivePath, []byte("hello gpg"), 0600))
+
+	base := &Base{viper: viper.New(), archivePath: archivePath}
+	_, err := NewGPG(base).perform()
+	assert.EqualError(t, err, "GPG encrypt failed: public_key or public_key_file option is required")
+
+	base.viper.Set("public_key", publicKey)
+	encryptPath, err := NewGPG(base).perform()
+	assert.NoError(t, err)
+	assert.Equal(t, archivePath+".enc", encryptPath)
+
+	// decrypt on the restore side
+	assert.NoError(t, os.Remove(archivePath))
+	base = &Base{viper: viper.New(), archivePath: encryptPath}
+	base.viper.Set("private_key_file", privateKeyFile)
+	_, err = NewGPG(base).decrypt()
+	assert.Error(t, err)
+
+	base.viper.Set("passphrase", "secret")
+	decryptPath, err := NewGPG(base).decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, archivePath, decryptPath)
+	data, err := os.ReadFile(decryptPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello gpg", string(data))
+
+	// stream, and the output can be decrypted by gpg command
+	publicKeyFile := filepath.Join(dir, "public.asc")
+	assert.NoError(t, os.WriteFile(publicKeyFile, []byte(publicKey), 0600))
+	base = &Base{viper: viper.New()}
+	base.viper.Set("public_key_file", publicKeyFile)
+	reader, err := NewGPG(base).stream(strings.NewReader("hello stream"))
+	assert.NoError(t, err)
+
+	var encrypted bytes.Buffer
+	_, err = encrypted.ReadFrom(reader)
+	assert.NoError(t, err)
+
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return
+	}
+
+	home := filepath.Join(dir, "gnupg")
+	assert.NoError(t, os.Mkdir(home, 0700))
+	gpg := func(stdin []byte, args ...string) ([]byte, error) {
+		cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--pinentry-mode", "loopback", "--passphrase", "secret"}, args...)...)
+		cmd.Stdin = bytes.NewReader(stdin)
+		return cmd.Output()
+	}
+	_, err = gpg(nil, "--import", privateKeyFile)
+	assert.NoError(t, err)
+	out, err := gpg(encrypted.Bytes(), "-d")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello stream", string(out))
+}
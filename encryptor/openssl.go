@@ -2,6 +2,7 @@ package encryptor
 
 import (
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/itgcloud/gobackup/helper"
@@ -57,6 +58,41 @@ func (enc *OpenSSL) perform() (encryptPath string, err error) {
 	return enc.encryptPath, nil
 }
 
+func (enc *OpenSSL) stream(reader io.Reader) (io.ReadCloser, error) {
+	if len(enc.password) == 0 {
+		return nil, fmt.Errorf("password option is required")
+	}
+
+	return helper.ExecStream(reader, "openssl", enc.options()...)
+}
+
+func (enc *OpenSSL) decrypt() (decryptPath string, err error) {
+	if len(enc.password) == 0 {
+		err = fmt.Errorf("password option is required")
+		return
+	}
+
+	decryptPath = enc.decryptPath()
+
+	opts := enc.options()
+	opts = append(opts, "-d", "-in", enc.archivePath, "-out", decryptPath)
+	_, err = helper.Exec("openssl", opts...)
+	if err != nil {
+		err = fmt.Errorf("OpenSSL decrypt failed: %s", strings.TrimSpace(err.Error()))
+		return "", err
+	}
+
+	return decryptPath, nil
+}
+
+func (enc *OpenSSL) decryptStream(reader io.Reader) (io.ReadCloser, error) {
+	if len(enc.password) == 0 {
+		return nil, fmt.Errorf("password option is required")
+	}
+
+	return helper.ExecStream(reader, "openssl", append(enc.options(), "-d")...)
+}
+
 func (enc *OpenSSL) options() (opts []string) {
 	opts = append(opts, enc.chiper)
 	if enc.base64 {
@@ -0,0 +1,192 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Bandwidth limits the rate of uploading, the limit can be changed by the time of day
+type Bandwidth struct {
+	// limit in bytes/s, 0 is unlimited
+	limit    int64
+	schedule []BandwidthWindow
+
+	mu      sync.Mutex
+	limiter *rate.Limiter
+	current int64
+	now     func() time.Time
+}
+
+// BandwidthWindow is the limit between Start and End of a day, it can cross midnight, e.g. 22:00-06:00
+type BandwidthWindow struct {
+	Start time.Duration
+	End   time.Duration
+	Limit int64
+}
+
+// NewBandwidth returns a Bandwidth with the limit like `20MB/s`, and the schedule like `08:00-18:00 5MB/s`.
+// It returns nil when there is no limit.
+func NewBandwidth(limit string, schedule []string) (*Bandwidth, error) {
+	b := &Bandwidth{now: time.Now}
+
+	var err error
+	if b.limit, err = ParseRate(limit); err != nil {
+		return nil, err
+	}
+
+	for _, s := range schedule {
+		window, err := parseBandwidthWindow(s)
+		if err != nil {
+			return nil, err
+		}
+		b.schedule = append(b.schedule, window)
+	}
+
+	if b.limit == 0 && len(b.schedule) == 0 {
+		return nil, nil
+	}
+
+	return b, nil
+}
+
+// ParseRate parse the rate like `20MB/s`, `512K`, the units are the same as ParseSize.
+// Empty, `0`, `off` and `unlimited` are unlimited.
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "", "0", "off", "unlimited":
+		return 0, nil
+	}
+
+	n, err := ParseSize(strings.TrimSuffix(s, "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth: %q", s)
+	}
+
+	return n, nil
+}
+
+// parseBandwidthWindow parse `HH:MM-HH:MM <rate>`
+func parseBandwidthWindow(s string) (window BandwidthWindow, err error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return window, fmt.Errorf("invalid bandwidth schedule: %q, it should be like `08:00-18:00 5MB/s`", s)
+	}
+
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return window, fmt.Errorf("invalid bandwidth schedule: %q, it should be like `08:00-18:00 5MB/s`", s)
+	}
+	if window.Start, err = parseTimeOfDay(start); err != nil {
+		return window, fmt.Errorf("invalid bandwidth schedule: %q, %v", s, err)
+	}
+	if window.End, err = parseTimeOfDay(end); err != nil {
+		return window, fmt.Errorf("invalid bandwidth schedule: %q, %v", s, err)
+	}
+	if window.Limit, err = ParseRate(fields[1]); err != nil {
+		return window, fmt.Errorf("invalid bandwidth schedule: %q, %v", s, err)
+	}
+
+	return window, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w BandwidthWindow) contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+
+	// Cross midnight
+	return offset >= w.Start || offset < w.End
+}
+
+// Limit returns the limit at the time, the first matched window of schedule wins
+func (b *Bandwidth) Limit(t time.Time) int64 {
+	for _, window := range b.schedule {
+		if window.contains(t) {
+			return window.Limit
+		}
+	}
+
+	return b.limit
+}
+
+// Wait until n bytes are allowed to upload
+func (b *Bandwidth) Wait(n int64) {
+	if b == nil {
+		return
+	}
+
+	for n > 0 {
+		limiter := b.currentLimiter()
+		if limiter == nil {
+			return
+		}
+
+		chunk := min(n, int64(limiter.Burst()))
+		// The context is never canceled, and chunk <= burst
+		_ = limiter.WaitN(context.Background(), int(chunk))
+		n -= chunk
+	}
+}
+
+// currentLimiter returns nil when it is unlimited now
+func (b *Bandwidth) currentLimiter() *rate.Limiter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	limit := b.Limit(b.now())
+	if limit == 0 {
+		return nil
+	}
+
+	if b.limiter == nil {
+		b.limiter = rate.NewLimiter(rate.Limit(limit), int(limit))
+	} else if limit != b.current {
+		b.limiter.SetLimit(rate.Limit(limit))
+		b.limiter.SetBurst(int(limit))
+	}
+	b.current = limit
+
+	return b.limiter
+}
+
+// Reader returns a reader limited by the bandwidth, the reader is returned as is if b is nil
+func (b *Bandwidth) Reader(r io.Reader) io.Reader {
+	if b == nil {
+		return r
+	}
+
+	return &bandwidthReader{r, b}
+}
+
+type bandwidthReader struct {
+	reader    io.Reader
+	bandwidth *Bandwidth
+}
+
+func (r *bandwidthReader) Read(p []byte) (int, error) {
+	// Do not read more than 1 second of data at once, to keep the rate smooth
+	if limiter := r.bandwidth.currentLimiter(); limiter != nil && len(p) > limiter.Burst() {
+		p = p[:limiter.Burst()]
+	}
+
+	n, err := r.reader.Read(p)
+	r.bandwidth.Wait(int64(n))
+	return n, err
+}
@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	cases := map[string]int64{
+		"":          0,
+		"0":         0,
+		"off":       0,
+		"unlimited": 0,
+		"20MB/s":    20 * 1000 * 1000,
+		"20M/s":     20 * 1024 * 1024,
+		"512K":      512 * 1024,
+		"1GiB/s":    1024 * 1024 * 1024,
+	}
+	for s, expected := range cases {
+		n, err := ParseRate(s)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, n, s)
+	}
+
+	_, err := ParseRate("fast")
+	assert.EqualError(t, err, `invalid bandwidth: "fast"`)
+}
+
+func TestNewBandwidth(t *testing.T) {
+	b, err := NewBandwidth("", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+
+	// nil is unlimited
+	r := bytes.NewReader([]byte("foo"))
+	assert.Equal(t, io.Reader(r), b.Reader(r))
+	b.Wait(100)
+
+	b, err = NewBandwidth("20MB/s", []string{"08:00-18:00 5MB/s", "22:00-06:00 off"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20*1000*1000), b.limit)
+	assert.Equal(t, []BandwidthWindow{
+		{Start: 8 * time.Hour, End: 18 * time.Hour, Limit: 5 * 1000 * 1000},
+		{Start: 22 * time.Hour, End: 6 * time.Hour, Limit: 0},
+	}, b.schedule)
+
+	day := func(hour, min int) time.Time {
+		return time.Date(2023, 1, 1, hour, min, 0, 0, time.Local)
+	}
+	assert.Equal(t, int64(20*1000*1000), b.Limit(day(7, 59)))
+	assert.Equal(t, int64(5*1000*1000), b.Limit(day(8, 0)))
+	assert.Equal(t, int64(5*1000*1000), b.Limit(day(17, 59)))
+	assert.Equal(t, int64(20*1000*1000), b.Limit(day(18, 0)))
+	assert.Equal(t, int64(0), b.Limit(day(23, 0)))
+	assert.Equal(t, int64(0), b.Limit(day(0, 30)))
+	assert.Equal(t, int64(20*1000*1000), b.Limit(day(6, 0)))
+
+	// Only the schedule
+	b, err = NewBandwidth("", []string{"08:00-18:00 5MB/s"})
+	assert.NoError(t, err)
+	assert.NotNil(t, b)
+	assert.Equal(t, int64(0), b.Limit(day(7, 0)))
+
+	_, err = NewBandwidth("", []string{"08:00 5MB/s"})
+	assert.EqualError(t, err, "invalid bandwidth schedule: \"08:00 5MB/s\", it should be like `08:00-18:00 5MB/s`")
+	_, err = NewBandwidth("", []string{"08:00-25:00 5MB/s"})
+	assert.EqualError(t, err, `invalid bandwidth schedule: "08:00-25:00 5MB/s", invalid time "25:00"`)
+}
+
+func TestBandwidth_Reader(t *testing.T) {
+	b, err := NewBandwidth("100K/s", nil)
+	assert.NoError(t, err)
+
+	// The first second is the burst
+	data := bytes.Repeat([]byte("a"), 150*1024)
+	start := time.Now()
+	out, err := io.ReadAll(b.Reader(bytes.NewReader(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, data, out)
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 400*time.Millisecond, elapsed)
+	assert.True(t, elapsed < 2*time.Second, elapsed)
+
+	// Unlimited by the schedule now
+	b, err = NewBandwidth("1K/s", []string{"08:00-18:00 off"})
+	assert.NoError(t, err)
+	b.now = func() time.Time { return time.Date(2023, 1, 1, 9, 0, 0, 0, time.Local) }
+	start = time.Now()
+	out, err = io.ReadAll(b.Reader(bytes.NewReader(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, data, out)
+	assert.True(t, time.Since(start) < time.Second)
+}
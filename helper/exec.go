@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"regexp"
@@ -59,3 +60,158 @@ func ExecWithStdio(command string, stdout bool, args ...string) (output string,
 
 	return
 }
+
+// ExecStream run command with stdin and return the stdout as a reader for streaming.
+// The command error will be returned by Read after the stdout is EOF.
+func ExecStream(stdin io.Reader, command string, args ...string) (io.ReadCloser, error) {
+	commands := spaceRegexp.Split(command, -1)
+	command = commands[0]
+	commandArgs := []string{}
+	if len(commands) > 1 {
+		commandArgs = commands[1:]
+	}
+	if len(args) > 0 {
+		commandArgs = append(commandArgs, args...)
+	}
+
+	fullCommand, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("%s cannot be found", command)
+	}
+
+	cmd := exec.Command(fullCommand, commandArgs...)
+	cmd.Env = os.Environ()
+	cmd.Stdin = stdin
+
+	stdOut, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &execReader{cmd: cmd, stdOut: stdOut}
+	cmd.Stderr = &r.stdErr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+type execReader struct {
+	cmd    *exec.Cmd
+	stdOut io.Reader
+	stdErr bytes.Buffer
+	done   bool
+}
+
+func (r *execReader) Read(p []byte) (int, error) {
+	n, err := r.stdOut.Read(p)
+	if err == io.EOF && !r.done {
+		r.done = true
+		if werr := r.cmd.Wait(); werr != nil {
+			logger.Debug(r.cmd.Path, " ", strings.Join(r.cmd.Args[1:], " "))
+			if r.stdErr.Len() > 0 {
+				return n, errors.New(r.stdErr.String())
+			}
+			return n, werr
+		}
+	}
+
+	return n, err
+}
+
+// Close kill the command if it is still running
+func (r *execReader) Close() error {
+	if r.done {
+		return nil
+	}
+
+	r.done = true
+	_ = r.cmd.Process.Kill()
+	_ = r.cmd.Wait()
+	return nil
+}
+
+// Command is a command to be executed with the args as is, they will not be split by spaces,
+// so the values contain spaces (e.g. password, table name) are safe.
+type Command struct {
+	Name string
+	Args []string
+	// Env is appended to the environment of gobackup, e.g. `MYSQL_PWD=secret`,
+	// use it to pass the credentials instead of Args.
+	Env []string
+	// Stdin of the command, optional
+	Stdin io.Reader
+	// Stdout of the command, when it is nil the output will be returned by Run
+	Stdout io.Writer
+}
+
+// String returns the command line without Env, it is safe to be logged
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Run the command and wait for it to exit, the tail of stderr is returned in the error.
+func (c Command) Run() (output string, err error) {
+	fullCommand, err := exec.LookPath(c.Name)
+	if err != nil {
+		return "", fmt.Errorf("%s cannot be found", c.Name)
+	}
+
+	cmd := exec.Command(fullCommand, c.Args...)
+	cmd.Env = append(os.Environ(), c.Env...)
+	cmd.Stdin = c.Stdin
+
+	var stdOut bytes.Buffer
+	if c.Stdout != nil {
+		cmd.Stdout = c.Stdout
+	} else {
+		cmd.Stdout = &stdOut
+	}
+
+	stdErr := &tailBuffer{max: 4096}
+	cmd.Stderr = stdErr
+
+	logger.Debug(c.String())
+	if err := cmd.Run(); err != nil {
+		if stdErr.Len() > 0 {
+			return "", fmt.Errorf("%s %v: %s", c.Name, err, stdErr.String())
+		}
+		return "", fmt.Errorf("%s %v", c.Name, err)
+	}
+
+	if stdErr.Len() > 0 {
+		logger.Debug(stdErr.String())
+	}
+
+	return strings.Trim(stdOut.String(), "\n"), nil
+}
+
+// tailBuffer keeps the last `max` bytes written
+type tailBuffer struct {
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) Len() int {
+	return len(b.buf)
+}
+
+func (b *tailBuffer) String() string {
+	s := strings.TrimSpace(string(b.buf))
+	if b.truncated {
+		return "..." + s
+	}
+	return s
+}
@@ -1,6 +1,8 @@
 package helper
 
 import (
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/longbridgeapp/assert"
@@ -38,3 +40,52 @@ func TestExecWithStdio(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Empty(t, out)
 }
+
+func TestExecStream(t *testing.T) {
+	r, err := ExecStream(strings.NewReader("hello\nworld\n"), "head -n1")
+	assert.Nil(t, err)
+	out, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\n", string(out))
+	assert.Nil(t, r.Close())
+
+	r, err = ExecStream(nil, "cat", "./not-found-file")
+	assert.Nil(t, err)
+	_, err = io.ReadAll(r)
+	assert.NotNil(t, err)
+	assert.Nil(t, r.Close())
+
+	_, err = ExecStream(nil, "not-found-command")
+	assert.NotNil(t, err)
+}
+
+func TestCommand(t *testing.T) {
+	out, err := Command{Name: "sh", Args: []string{"-c", `echo "$1 $FOO"`, "sh", "hello world"}, Env: []string{"FOO=bar baz"}}.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world bar baz", out)
+
+	var buf strings.Builder
+	out, err = Command{Name: "cat", Stdin: strings.NewReader("foo\n"), Stdout: &buf}.Run()
+	assert.Nil(t, err)
+	assert.Empty(t, out)
+	assert.Equal(t, "foo\n", buf.String())
+
+	_, err = Command{Name: "sh", Args: []string{"-c", "echo oops >&2; exit 3"}}.Run()
+	assert.EqualError(t, err, "sh exit status 3: oops")
+
+	_, err = Command{Name: "sh", Args: []string{"-c", "exit 1"}}.Run()
+	assert.EqualError(t, err, "sh exit status 1")
+
+	_, err = Command{Name: "not-found-command"}.Run()
+	assert.EqualError(t, err, "not-found-command cannot be found")
+
+	assert.Equal(t, "mysqldump --host 127.0.0.1 my db", Command{Name: "mysqldump", Args: []string{"--host", "127.0.0.1", "my db"}, Env: []string{"MYSQL_PWD=secret"}}.String())
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 5}
+	b.Write([]byte("abc"))
+	assert.Equal(t, "abc", b.String())
+	b.Write([]byte("defgh"))
+	assert.Equal(t, "...defgh", b.String())
+}
@@ -1,6 +1,7 @@
 package helper
 
 import (
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -54,3 +55,25 @@ func AbsolutePath(path string) string {
 
 	return path
 }
+
+// CopyFile copy the file from src to dst, dst will be overwritten if exists
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return out.Close()
+}
@@ -3,6 +3,7 @@ package helper
 import (
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
 
 	"github.com/longbridgeapp/assert"
@@ -61,3 +62,18 @@ func TestAbsolutePath(t *testing.T) {
 	newPath = AbsolutePath("~/foo/bar/dar")
 	assert.Equal(t, newPath, path.Join(os.Getenv("HOME"), "/foo/bar/dar"))
 }
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	assert.NoError(t, os.WriteFile(src, []byte("hello"), 0640))
+	assert.NoError(t, os.WriteFile(dst, []byte("old content"), 0640))
+
+	assert.NoError(t, CopyFile(src, dst))
+	data, err := os.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	assert.Error(t, CopyFile(filepath.Join(dir, "not-found"), dst))
+}
@@ -14,7 +14,8 @@ import (
 )
 
 const (
-	progressbarTemplate = `{{string . "time"}} {{string . "prefix"}}{{bar . "[" "=" "=" "-" "]"}} {{percent .}} ({{speed .}})`
+	progressbarTemplate       = `{{string . "time"}} {{string . "prefix"}}{{bar . "[" "=" "=" "-" "]"}} {{percent .}} ({{speed .}})`
+	streamProgressbarTemplate = `{{string . "time"}} {{string . "prefix"}}{{counters . }} ({{speed .}})`
 )
 
 type ProgressBar struct {
@@ -23,28 +24,70 @@ type ProgressBar struct {
 	Reader     io.Reader
 	logger     logger.Logger
 	startTime  time.Time
+	// label is the storage name, to tell the progress of storages uploading at the same time
+	label string
 }
 
-func NewProgressBar(myLogger logger.Logger, reader *os.File) ProgressBar {
+// NewProgressBar for the file, the label will be prefixed to the bar and logs
+func NewProgressBar(myLogger logger.Logger, reader *os.File, label string) ProgressBar {
 	info, _ := reader.Stat()
 	fileLength := info.Size()
 
 	bar := pb.ProgressBarTemplate(progressbarTemplate).Start64(fileLength)
 	bar.SetWidth(100)
 	bar.Set("time", time.Now().Format(logger.TimeFormat))
+	setLabel(bar, label)
 
 	multiReader := bar.NewProxyReader(reader)
 
-	progressBar := ProgressBar{bar, fileLength, multiReader, myLogger, time.Now()}
+	progressBar := ProgressBar{bar, fileLength, multiReader, myLogger, time.Now(), label}
 	progressBar.start()
 
 	return progressBar
 }
 
+// NewStreamProgressBar for the reader which the length is unknown
+func NewStreamProgressBar(myLogger logger.Logger, reader io.Reader, label string) ProgressBar {
+	bar := pb.ProgressBarTemplate(streamProgressbarTemplate).Start64(0)
+	bar.Set(pb.Bytes, true)
+	bar.Set("time", time.Now().Format(logger.TimeFormat))
+	setLabel(bar, label)
+
+	multiReader := bar.NewProxyReader(reader)
+
+	progressBar := ProgressBar{bar, 0, multiReader, myLogger, time.Now(), label}
+	progressBar.start()
+
+	return progressBar
+}
+
+func setLabel(bar *pb.ProgressBar, label string) {
+	if len(label) > 0 {
+		bar.Set("prefix", fmt.Sprintf("[%s] ", label))
+	}
+}
+
+// prefix returns the label for logs
+func (p ProgressBar) prefix() string {
+	if len(p.label) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("[%s] ", p.label)
+}
+
 func (p ProgressBar) start() {
 	logger := p.logger
 
-	logger.Infof("-> Uploading (%s)...", humanize.Bytes(uint64(p.FileLength)))
+	if p.FileLength == 0 {
+		logger.Infof("-> %sUploading (stream)...", p.prefix())
+		return
+	}
+	logger.Infof("-> %sUploading (%s)...", p.prefix(), humanize.Bytes(uint64(p.FileLength)))
+}
+
+// Add the bytes have been uploaded before, e.g. the parts of a resumed upload
+func (p ProgressBar) Add(n int64) {
+	p.bar.Add64(n)
 }
 
 func (p ProgressBar) Errorf(format string, err ...any) error {
@@ -60,5 +103,5 @@ func (p ProgressBar) Done(url string) {
 	t := time.Now()
 	elapsed := t.Sub(p.startTime)
 
-	logger.Info(fmt.Sprintf("Uploaded: %s (Duration %v)", url, durafmt.Parse(elapsed).LimitFirstN(2).String()))
+	logger.Info(fmt.Sprintf("%sUploaded: %s (Duration %v)", p.prefix(), url, durafmt.Parse(elapsed).LimitFirstN(2).String()))
 }
@@ -1,12 +1,17 @@
 package helper
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
 var (
 	// IsGnuTar show tar type
 	IsGnuTar = false
+
+	sizeRegexp = regexp.MustCompile(`^(?i)\s*([0-9]+)\s*([kmgtp]?)(i?b?)\s*$`)
 )
 
 func init() {
@@ -36,3 +41,41 @@ func FormatEndpoint(endpoint string) string {
 
 	return endpoint
 }
+
+// ParseSize parse size like the `split -b` option: 100, 100K, 100M, 1G, 1GiB are 1024-based, 100KB, 100MB are 1000-based
+func ParseSize(size string) (int64, error) {
+	matches := sizeRegexp.FindStringSubmatch(size)
+	if matches == nil {
+		return 0, fmt.Errorf("invalid size: %q", size)
+	}
+
+	n, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %q", size)
+	}
+
+	unit := int64(1024)
+	if strings.EqualFold(matches[3], "b") {
+		unit = 1000
+	}
+
+	switch strings.ToLower(matches[2]) {
+	case "k":
+		n *= unit
+	case "m":
+		n *= unit * unit
+	case "g":
+		n *= unit * unit * unit
+	case "t":
+		n *= unit * unit * unit * unit
+	case "p":
+		n *= unit * unit * unit * unit * unit
+	}
+
+	return n, nil
+}
+
+// ShellQuote quote the string for POSIX shell
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -30,3 +30,32 @@ func TestFormatEndpoint(t *testing.T) {
 	assert.Equal(t, "https://foo.bar.com", FormatEndpoint("https://foo.bar.com"))
 	assert.Equal(t, "https://foo.bar.com", FormatEndpoint("https://foo.bar.com"))
 }
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"100":   100,
+		"100k":  100 * 1024,
+		"100K":  100 * 1024,
+		"100KB": 100 * 1000,
+		"2M":    2 * 1024 * 1024,
+		"2MiB":  2 * 1024 * 1024,
+		"1G":    1024 * 1024 * 1024,
+		"1gb":   1000 * 1000 * 1000,
+	}
+
+	for size, expected := range cases {
+		n, err := ParseSize(size)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, n, size)
+	}
+
+	_, err := ParseSize("1X")
+	assert.Error(t, err)
+	_, err = ParseSize("")
+	assert.Error(t, err)
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `'/data/backups'`, ShellQuote("/data/backups"))
+	assert.Equal(t, `'/data/it'\''s backups'`, ShellQuote("/data/it's backups"))
+}
@@ -60,6 +60,7 @@ func main() {
 	app := cli.NewApp()
 
 	app.Version = version
+	config.Version = version
 	app.Name = "gobackup"
 	app.Usage = usage
 
@@ -87,6 +88,141 @@ func main() {
 				return perform(modelNames)
 			},
 		},
+		{
+			Name:  "restore",
+			Usage: "Restore a package from the default storage of model",
+			Flags: buildFlags([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "model",
+					Aliases:  []string{"m"},
+					Usage:    "Model name that you want restore",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:    "package",
+					Aliases: []string{"p"},
+					Usage:   "File key of the package in storage, default is the latest one",
+				},
+				&cli.StringFlag{
+					Name:    "target",
+					Aliases: []string{"t"},
+					Usage:   "Directory to extract the package, default is ./restore/<model>",
+				},
+				&cli.BoolFlag{
+					Name:  "databases",
+					Usage: "Load the dumps back into the configured databases",
+				},
+			}),
+			Action: func(ctx *cli.Context) error {
+				err := initApplication()
+				if err != nil {
+					return err
+				}
+
+				return restore(ctx.String("model"), model.RestoreOptions{
+					FileKey:    ctx.String("package"),
+					TargetPath: ctx.String("target"),
+					Databases:  ctx.Bool("databases"),
+				})
+			},
+		},
+		{
+			Name:  "verify",
+			Usage: "Verify a package in the default storage of model without extracting",
+			Flags: buildFlags([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "model",
+					Aliases:  []string{"m"},
+					Usage:    "Model name that you want verify",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:    "package",
+					Aliases: []string{"p"},
+					Usage:   "File key of the package in storage, default is the latest one",
+				},
+			}),
+			Action: func(ctx *cli.Context) error {
+				err := initApplication()
+				if err != nil {
+					return err
+				}
+
+				return verify(ctx.String("model"), ctx.String("package"))
+			},
+		},
+		{
+			Name:      "wal-push",
+			Usage:     "Archive a WAL segment of PostgreSQL into the storages of model, for archive_command",
+			ArgsUsage: "<wal path (%p)>",
+			Flags: buildFlags([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "model",
+					Aliases:  []string{"m"},
+					Usage:    "Model name of the PostgreSQL database",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "database",
+					Aliases:  []string{"d"},
+					Usage:    "Name of the PostgreSQL database in the model",
+					Required: true,
+				},
+			}),
+			Action: func(ctx *cli.Context) error {
+				if ctx.NArg() != 1 {
+					return fmt.Errorf("WAL path is required")
+				}
+
+				err := initApplication()
+				if err != nil {
+					return err
+				}
+
+				m := model.GetModelByName(ctx.String("model"))
+				if m == nil {
+					return fmt.Errorf("model %s not found in %s", ctx.String("model"), viper.ConfigFileUsed())
+				}
+
+				return m.PushWAL(ctx.String("database"), ctx.Args().Get(0))
+			},
+		},
+		{
+			Name:      "wal-fetch",
+			Usage:     "Fetch a WAL segment of PostgreSQL from the default storage of model, for restore_command",
+			ArgsUsage: "<wal name (%f)> <target path (%p)>",
+			Flags: buildFlags([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "model",
+					Aliases:  []string{"m"},
+					Usage:    "Model name of the PostgreSQL database",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "database",
+					Aliases:  []string{"d"},
+					Usage:    "Name of the PostgreSQL database in the model",
+					Required: true,
+				},
+			}),
+			Action: func(ctx *cli.Context) error {
+				if ctx.NArg() != 2 {
+					return fmt.Errorf("WAL name and target path are required")
+				}
+
+				err := initApplication()
+				if err != nil {
+					return err
+				}
+
+				m := model.GetModelByName(ctx.String("model"))
+				if m == nil {
+					return fmt.Errorf("model %s not found in %s", ctx.String("model"), viper.ConfigFileUsed())
+				}
+
+				return m.FetchWAL(ctx.String("database"), ctx.Args().Get(0), ctx.Args().Get(1))
+			},
+		},
 		{
 			Name:  "start",
 			Usage: "Start as daemon",
@@ -182,3 +318,21 @@ func perform(modelNames []string) error {
 
 	return nil
 }
+
+func restore(modelName string, opts model.RestoreOptions) error {
+	m := model.GetModelByName(modelName)
+	if m == nil {
+		return fmt.Errorf("model %s not found in %s", modelName, viper.ConfigFileUsed())
+	}
+
+	return m.Restore(opts)
+}
+
+func verify(modelName string, fileKey string) error {
+	m := model.GetModelByName(modelName)
+	if m == nil {
+		return fmt.Errorf("model %s not found in %s", modelName, viper.ConfigFileUsed())
+	}
+
+	return m.Verify(fileKey)
+}
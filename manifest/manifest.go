@@ -0,0 +1,372 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itgcloud/gobackup/config"
+)
+
+// FileName of the manifest in the directory of chunks
+const FileName = "manifest.json"
+
+// Manifest describe what a package contains, it will be uploaded alongside the package
+type Manifest struct {
+	Model   string `json:"model"`
+	Version string `json:"version"`
+	// Package is the file key of the package, it is a directory when it has been split
+	Package     string     `json:"package"`
+	Databases   []Database `json:"databases,omitempty"`
+	Archive     *Archive   `json:"archive,omitempty"`
+	Compression string     `json:"compression"`
+	Encryption  string     `json:"encryption,omitempty"`
+	// Files of the package, the name is the file key relative to the storage path
+	Files      []File    `json:"files"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+type Database struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Size of the dump files in bytes
+	Size int64 `json:"size"`
+	// Backup is the info of physical backup (xtrabackup / mariabackup)
+	Backup *BackupInfo `json:"backup,omitempty"`
+}
+
+// BackupInfoFile is written into the dump path by the physical backups, it will be recorded in the manifest
+const BackupInfoFile = "gobackup_backup.json"
+
+// BackupInfo of the physical backup, the incremental backup is chained off the `to_lsn` of the previous one
+type BackupInfo struct {
+	// Type is full or incremental
+	Type    string `json:"type"`
+	FromLSN uint64 `json:"from_lsn"`
+	ToLSN   uint64 `json:"to_lsn"`
+	// Chain is the number of incremental backups since the last full backup
+	Chain int `json:"chain"`
+	// Base is the package which the incremental backup is chained off
+	Base string `json:"base,omitempty"`
+}
+
+// StreamedFile is written into the dump path by the dumps streamed into the archive, the size of them
+// will be recorded in the manifest, and the parts of them will be joined when restoring
+const StreamedFile = "gobackup_streamed.json"
+
+// StreamedDump is a dump streamed into the archive in parts, instead of written into the dump path
+type StreamedDump struct {
+	// Name of the dump relative to the dump path
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Parts is the number of parts, 0 means the dump is in one entry with the name
+	Parts int `json:"parts"`
+}
+
+type Archive struct {
+	// Remote is the host of `archive.remote`, the includes are on it
+	Remote   string   `json:"remote,omitempty"`
+	Includes []string `json:"includes,omitempty"`
+	Excludes []string `json:"excludes,omitempty"`
+	// Level is full, incremental or differential when `archive.mode` is not full
+	Level string `json:"level,omitempty"`
+	// Base is the package which the incremental or differential archive depends on
+	Base string `json:"base,omitempty"`
+	// Excluded files and bytes by each rule of archive filters
+	Excluded []ExcludedRule `json:"excluded,omitempty"`
+}
+
+// ExcludedFile is written into the temp path by the archive filters, it will be recorded in the manifest
+const ExcludedFile = "gobackup_excluded.json"
+
+// ExcludedRule is the number of files and bytes excluded by a rule of archive filters
+type ExcludedRule struct {
+	Rule  string `json:"rule"`
+	Files int64  `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// ArchiveLevelFile is written into the temp path by the incremental archive, it will be recorded in the manifest
+const ArchiveLevelFile = "gobackup_archive.json"
+
+// ArchiveLevel of the incremental or differential archive
+type ArchiveLevel struct {
+	Level string `json:"level"`
+	Base  string `json:"base,omitempty"`
+}
+
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// New manifest of the model, the dump size of databases will be calculated from the dump path
+func New(model config.ModelConfig, startedAt time.Time) *Manifest {
+	m := &Manifest{
+		Model:       model.Name,
+		Version:     config.Version,
+		Compression: model.CompressWith.Type,
+		Encryption:  model.EncryptWith.Type,
+		Files:       []File{},
+		StartedAt:   startedAt,
+	}
+
+	if len(m.Compression) == 0 {
+		m.Compression = "tar"
+	}
+
+	for _, dbConfig := range model.Databases {
+		dumpPath := filepath.Join(model.DumpPath, dbConfig.Type, dbConfig.Name)
+		db := Database{
+			Name:   dbConfig.Name,
+			Type:   dbConfig.Type,
+			Size:   dirSize(dumpPath),
+			Backup: ReadBackupInfo(dumpPath),
+		}
+		for _, dump := range ReadStreamed(dumpPath) {
+			db.Size += dump.Size
+		}
+		m.Databases = append(m.Databases, db)
+	}
+	sort.Slice(m.Databases, func(i, j int) bool {
+		return m.Databases[i].Name < m.Databases[j].Name
+	})
+
+	if model.Archive != nil {
+		m.Archive = &Archive{
+			Includes: model.Archive.GetStringSlice("includes"),
+			Excludes: model.Archive.GetStringSlice("excludes"),
+		}
+		if remote := model.Archive.Sub("remote"); remote != nil {
+			m.Archive.Remote = remote.GetString("host")
+		}
+		if level := ReadArchiveLevel(model.TempPath); level != nil {
+			m.Archive.Level = level.Level
+			m.Archive.Base = level.Base
+		}
+		m.Archive.Excluded = ReadExcluded(model.TempPath)
+	}
+
+	return m
+}
+
+// ReadArchiveLevel from the temp path, returns nil if it is not exist or invalid
+func ReadArchiveLevel(tempPath string) *ArchiveLevel {
+	data, err := os.ReadFile(filepath.Join(tempPath, ArchiveLevelFile))
+	if err != nil {
+		return nil
+	}
+
+	level := &ArchiveLevel{}
+	if err := json.Unmarshal(data, level); err != nil {
+		return nil
+	}
+
+	return level
+}
+
+// WriteArchiveLevel into the temp path
+func WriteArchiveLevel(tempPath string, level ArchiveLevel) error {
+	data, err := json.Marshal(level)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(tempPath, ArchiveLevelFile), data, 0640)
+}
+
+// ReadExcluded rules from the temp path, returns nil if it is not exist or invalid
+func ReadExcluded(tempPath string) []ExcludedRule {
+	data, err := os.ReadFile(filepath.Join(tempPath, ExcludedFile))
+	if err != nil {
+		return nil
+	}
+
+	var rules []ExcludedRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil
+	}
+
+	return rules
+}
+
+// WriteExcluded rules into the temp path
+func WriteExcluded(tempPath string, rules []ExcludedRule) error {
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(tempPath, ExcludedFile), data, 0640)
+}
+
+// ReadBackupInfo from the dump path, returns nil if it is not exist or invalid
+func ReadBackupInfo(dumpPath string) *BackupInfo {
+	data, err := os.ReadFile(filepath.Join(dumpPath, BackupInfoFile))
+	if err != nil {
+		return nil
+	}
+
+	info := &BackupInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil
+	}
+
+	return info
+}
+
+// WriteBackupInfo into the dump path
+func WriteBackupInfo(dumpPath string, info BackupInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dumpPath, BackupInfoFile), data, 0640)
+}
+
+// ReadStreamed dumps from the dump path, returns nil if it is not exist or invalid
+func ReadStreamed(dumpPath string) []StreamedDump {
+	data, err := os.ReadFile(filepath.Join(dumpPath, StreamedFile))
+	if err != nil {
+		return nil
+	}
+
+	var dumps []StreamedDump
+	if err := json.Unmarshal(data, &dumps); err != nil {
+		return nil
+	}
+
+	return dumps
+}
+
+// AddStreamed dump into the dump path
+func AddStreamed(dumpPath string, dump StreamedDump) error {
+	data, err := json.Marshal(append(ReadStreamed(dumpPath), dump))
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dumpPath, StreamedFile), data, 0640)
+}
+
+// Key returns the file key of manifest for the package.
+//
+//	foo.tar.gz -> foo.tar.gz.manifest.json
+//	foo (split) -> foo/manifest.json
+func Key(fileKey string, split bool) string {
+	fileKey = strings.TrimSuffix(fileKey, "/")
+	if split {
+		return path.Join(fileKey, FileName)
+	}
+
+	return fileKey + "." + FileName
+}
+
+// packageIDPattern matches the package name <model>-2006-01-02-15-04-05 with the extensions
+var packageIDPattern = regexp.MustCompile(`^(.+-\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2})(\..*)?$`)
+
+// PackageID returns the file key without the path and extensions, the model name may contain dots.
+//
+//	backups/foo.bar-2006-01-02-15-04-05.tar.gz -> foo.bar-2006-01-02-15-04-05
+//	backups/foo.bar-2006-01-02-15-04-05/ (split) -> foo.bar-2006-01-02-15-04-05
+func PackageID(fileKey string) string {
+	name := path.Base(strings.TrimSuffix(fileKey, "/"))
+	if matches := packageIDPattern.FindStringSubmatch(name); matches != nil {
+		return matches[1]
+	}
+
+	return strings.SplitN(name, ".", 2)[0]
+}
+
+// Clone a copy of manifest with empty files
+func (m *Manifest) Clone() *Manifest {
+	c := *m
+	c.Files = []File{}
+	return &c
+}
+
+// AddLocalFile calculate the SHA-256 of the local file and add it with the file key
+func (m *Manifest) AddLocalFile(localPath, fileKey string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := NewHashReader(f)
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return err
+	}
+
+	m.Files = append(m.Files, reader.File(fileKey))
+	return nil
+}
+
+// JSON returns the indented JSON of manifest
+func (m *Manifest) JSON() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// Read manifest from JSON
+func Read(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// HashReader calculate the size and SHA-256 while reading
+type HashReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int64
+}
+
+func NewHashReader(reader io.Reader) *HashReader {
+	return &HashReader{reader: reader, hash: sha256.New()}
+}
+
+func (r *HashReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.size += int64(n)
+	r.hash.Write(p[:n])
+	return n, err
+}
+
+// File returns the file info of data has been read
+func (r *HashReader) File(name string) File {
+	return File{
+		Name:   name,
+		Size:   r.size,
+		SHA256: hex.EncodeToString(r.hash.Sum(nil)),
+	}
+}
+
+func dirSize(dirPath string) (size int64) {
+	_ = filepath.WalkDir(dirPath, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return
+}
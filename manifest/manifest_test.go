@@ -0,0 +1,124 @@
+package manifest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+)
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "foo.tar.gz.manifest.json", Key("foo.tar.gz", false))
+	assert.Equal(t, "foo/manifest.json", Key("foo", true))
+	assert.Equal(t, "foo/manifest.json", Key("foo/", true))
+}
+
+func TestPackageID(t *testing.T) {
+	assert.Equal(t, "foo-2006-01-02-15-04-05", PackageID("backups/foo-2006-01-02-15-04-05.tar.gz"))
+	assert.Equal(t, "foo.bar-2006-01-02-15-04-05", PackageID("backups/foo.bar-2006-01-02-15-04-05.tar.gz.enc"))
+	assert.Equal(t, "foo.bar-2006-01-02-15-04-05", PackageID("backups/foo.bar-2006-01-02-15-04-05/"))
+	assert.Equal(t, "foo.bar-2006-01-02-15-04-05", PackageID("/tmp/foo.bar-2006-01-02-15-04-05"))
+	assert.Equal(t, "demo-1", PackageID("demo-1.tar.gz"))
+}
+
+func TestNew(t *testing.T) {
+	dumpPath := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dumpPath, "mysql", "db1"), 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(dumpPath, "mysql", "db1", "db1.sql"), []byte("hello"), 0640))
+
+	archive := viper.New()
+	archive.Set("includes", []string{"/etc/nginx"})
+	archive.Set("excludes", []string{"/etc/nginx/logs"})
+
+	startedAt := time.Now()
+	m := New(config.ModelConfig{
+		Name:     "foo",
+		DumpPath: dumpPath,
+		Archive:  archive,
+		Databases: map[string]config.SubConfig{
+			"redis1": {Name: "redis1", Type: "redis"},
+			"db1":    {Name: "db1", Type: "mysql"},
+		},
+		EncryptWith: config.SubConfig{Type: "age"},
+	}, startedAt)
+
+	assert.Equal(t, "foo", m.Model)
+	assert.Equal(t, config.Version, m.Version)
+	assert.Equal(t, "tar", m.Compression)
+	assert.Equal(t, "age", m.Encryption)
+	assert.Equal(t, startedAt, m.StartedAt)
+	assert.Equal(t, []Database{{Name: "db1", Type: "mysql", Size: 5}, {Name: "redis1", Type: "redis", Size: 0}}, m.Databases)
+	assert.Equal(t, &Archive{Includes: []string{"/etc/nginx"}, Excludes: []string{"/etc/nginx/logs"}}, m.Archive)
+}
+
+func TestManifest_AddLocalFile(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "foo.tar")
+	assert.NoError(t, os.WriteFile(localPath, []byte("hello world"), 0640))
+
+	m := &Manifest{Model: "foo"}
+	assert.NoError(t, m.AddLocalFile(localPath, "bar/foo.tar"))
+	assert.Equal(t, []File{{
+		Name:   "bar/foo.tar",
+		Size:   11,
+		SHA256: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+	}}, m.Files)
+
+	data, err := m.JSON()
+	assert.NoError(t, err)
+	m1, err := Read(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, m.Files, m1.Files)
+	assert.Equal(t, 0, len(m1.Clone().Files))
+}
+
+func TestBackupInfo(t *testing.T) {
+	dumpPath := t.TempDir()
+	assert.Nil(t, ReadBackupInfo(dumpPath))
+
+	info := BackupInfo{Type: "incremental", FromLSN: 100, ToLSN: 200, Chain: 2}
+	assert.NoError(t, WriteBackupInfo(dumpPath, info))
+	assert.Equal(t, &info, ReadBackupInfo(dumpPath))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dumpPath, BackupInfoFile), []byte("bad"), 0640))
+	assert.Nil(t, ReadBackupInfo(dumpPath))
+}
+
+func TestExcluded(t *testing.T) {
+	tempPath := t.TempDir()
+	assert.Nil(t, ReadExcluded(tempPath))
+
+	rules := []ExcludedRule{{Rule: "excludes: *.log", Files: 2, Bytes: 10}, {Rule: "exclude_caches"}}
+	assert.NoError(t, WriteExcluded(tempPath, rules))
+	assert.Equal(t, rules, ReadExcluded(tempPath))
+
+	archive := viper.New()
+	archive.Set("includes", []string{"/etc/nginx"})
+	m := New(config.ModelConfig{Name: "foo", TempPath: tempPath, Archive: archive}, time.Now())
+	assert.Equal(t, rules, m.Archive.Excluded)
+}
+
+func TestStreamed(t *testing.T) {
+	dumpPath := t.TempDir()
+	assert.Nil(t, ReadStreamed(dumpPath))
+
+	assert.NoError(t, AddStreamed(dumpPath, StreamedDump{Name: "app.sql", Size: 100, Parts: 2}))
+	assert.NoError(t, AddStreamed(dumpPath, StreamedDump{Name: "globals/globals.sql", Size: 10}))
+	assert.Equal(t, []StreamedDump{{Name: "app.sql", Size: 100, Parts: 2}, {Name: "globals/globals.sql", Size: 10}}, ReadStreamed(dumpPath))
+
+	dbPath := filepath.Join(dumpPath, "postgresql", "pg1")
+	assert.NoError(t, os.MkdirAll(dbPath, 0750))
+	assert.NoError(t, os.Rename(filepath.Join(dumpPath, StreamedFile), filepath.Join(dbPath, StreamedFile)))
+	info, err := os.Stat(filepath.Join(dbPath, StreamedFile))
+	assert.NoError(t, err)
+
+	m := New(config.ModelConfig{Name: "foo", DumpPath: dumpPath, Databases: map[string]config.SubConfig{
+		"pg1": {Name: "pg1", Type: "postgresql"},
+	}}, time.Now())
+	assert.Equal(t, info.Size()+110, m.Databases[0].Size)
+}
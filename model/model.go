@@ -3,6 +3,7 @@ package model
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/viper"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/itgcloud/gobackup/encryptor"
 	"github.com/itgcloud/gobackup/helper"
 	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/manifest"
 	"github.com/itgcloud/gobackup/notifier"
 	"github.com/itgcloud/gobackup/splitter"
 	"github.com/itgcloud/gobackup/storage"
@@ -39,6 +41,7 @@ func (m Model) Perform() (err error) {
 	}()
 
 	logger.Info("WorkDir:", m.Config.DumpPath)
+	startedAt := time.Now()
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -49,10 +52,30 @@ func (m Model) Perform() (err error) {
 		m.after()
 	}()
 
-	if err = database.Run(m.Config); err != nil {
+	// The dump errors are returned after the backup has been stored when `continue_on_error`,
+	// the dumps are run by the compressor when they are streamed into the archive.
+	var dumpErr error
+	if !compressor.CanStreamDumps(m.Config) {
+		dumpErr = database.Run(m.Config)
+		if dumpErr != nil {
+			if !m.Config.ContinueOnError {
+				return dumpErr
+			}
+			logger.Errorf("%v, continue on error", dumpErr)
+		}
+	}
+
+	if err = archive.Prepare(m.Config); err != nil {
 		return
 	}
 
+	if m.Config.Streaming {
+		if err = m.stream(startedAt); err != nil {
+			return
+		}
+		return dumpErr
+	}
+
 	if err = archive.Run(m.Config); err != nil {
 		return
 	}
@@ -73,10 +96,51 @@ func (m Model) Perform() (err error) {
 		return
 	}
 
-	err = storage.Run(m.Config, archivePath)
+	err = storage.Run(m.Config, archivePath, manifest.New(m.Config, startedAt))
 	if err != nil {
 		return
 	}
+	m.commitArchive(archivePath)
+
+	return dumpErr
+}
+
+// commitArchive save the snapshot of incremental archive after stored, the next archive will be based on
+// the previous snapshot if failed, which contains more files but is still complete.
+func (m Model) commitArchive(archivePath string) {
+	if err := archive.Commit(m.Config, archivePath); err != nil {
+		logger.Tag("Archive").Warnf("Failed to save the snapshot, the next archive will contain more files: %v", err)
+	}
+}
+
+// stream chain the databases, compressor, encryptor, splitter into storages without intermediate files
+func (m Model) stream(startedAt time.Time) error {
+	var dumps func(w *archive.DumpWriter) error
+	if compressor.CanStreamDumps(m.Config) {
+		dumps = func(w *archive.DumpWriter) error {
+			return database.RunStream(m.Config, w)
+		}
+	}
+
+	archiveName, reader, err := compressor.Stream(m.Config, dumps)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	archiveName, encReader, err := encryptor.Stream(archiveName, reader, m.Config)
+	if err != nil {
+		return err
+	}
+	defer encReader.Close()
+
+	newManifest := func() *manifest.Manifest {
+		return manifest.New(m.Config, startedAt)
+	}
+	if err := storage.RunStream(m.Config, archiveName, encReader, newManifest); err != nil {
+		return err
+	}
+	m.commitArchive(archiveName)
 
 	return nil
 }
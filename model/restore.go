@@ -0,0 +1,87 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/itgcloud/gobackup/compressor"
+	"github.com/itgcloud/gobackup/database"
+	"github.com/itgcloud/gobackup/encryptor"
+	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/splitter"
+	"github.com/itgcloud/gobackup/storage"
+)
+
+var (
+	// foo.tar.gz-000 -> foo.tar.gz
+	chunkSuffixRegexp = regexp.MustCompile(`-[0-9a-z]+$`)
+)
+
+// RestoreOptions for restore a package
+type RestoreOptions struct {
+	// FileKey of the package in the default storage, the latest package will be used when it is empty
+	FileKey string
+	// TargetPath to extract the package
+	TargetPath string
+	// Databases to load the dumps back into the databases
+	Databases bool
+}
+
+// Restore reverse the pipeline: fetch -> join -> decrypt -> extract -> load databases
+func (m Model) Restore(opts RestoreOptions) (err error) {
+	logger := logger.Tag(fmt.Sprintf("Model: %s", m.Config.Name))
+
+	defer func() {
+		logger.Infof("Cleanup temp: %s/", m.Config.TempPath)
+		if err := os.RemoveAll(m.Config.TempPath); err != nil {
+			logger.Errorf("Cleanup temp dir %s error: %v", m.Config.TempPath, err)
+		}
+	}()
+
+	pkg, err := storage.FindPackage(m.Config, opts.FileKey)
+	if err != nil {
+		return err
+	}
+	logger.Info("Restore package:", pkg.FileKey)
+
+	paths, err := storage.Fetch(m.Config, pkg, filepath.Join(m.Config.TempPath, "fetch"))
+	if err != nil {
+		return err
+	}
+
+	archivePath := paths[0]
+	if len(pkg.FileKeys) != 0 {
+		archivePath = filepath.Join(m.Config.TempPath, chunkSuffixRegexp.ReplaceAllString(filepath.Base(paths[0]), ""))
+		if err = splitter.Join(paths, archivePath); err != nil {
+			return err
+		}
+	}
+
+	if strings.HasSuffix(archivePath, ".enc") {
+		archivePath, err = encryptor.Decrypt(archivePath, m.Config)
+		if err != nil {
+			return err
+		}
+	}
+
+	targetPath := opts.TargetPath
+	if len(targetPath) == 0 {
+		targetPath = filepath.Join(m.Config.WorkDir, "restore", m.Config.Name)
+	}
+
+	if err = compressor.Extract(archivePath, targetPath); err != nil {
+		return err
+	}
+
+	if opts.Databases {
+		if err = database.Restore(m.Config, targetPath); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Restored to", targetPath)
+	return nil
+}
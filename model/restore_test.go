@@ -0,0 +1,94 @@
+package model
+
+import (
+	"crypto/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+)
+
+func TestModel_Restore(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl not found")
+	}
+	setTempHome(t)
+
+	src := t.TempDir()
+	// Random data is not compressed, so the package is split into several chunks
+	data := make([]byte, 10*1024)
+	_, err := rand.Read(data)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "foo.bin"), data, 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "bar.txt"), []byte("v1"), 0640))
+
+	archive := viper.New()
+	archive.Set("includes", []string{src})
+	encryptWith := viper.New()
+	encryptWith.Set("password", "gobackup-123")
+	splitter := viper.New()
+	splitter.Set("chunk_size", "4k")
+	storagePath := t.TempDir()
+	v := viper.New()
+	v.Set("path", storagePath)
+
+	tempPath := filepath.Join(t.TempDir(), "tmp")
+	m := Model{Config: config.ModelConfig{
+		Name:           "test_restore",
+		WorkDir:        t.TempDir(),
+		TempPath:       tempPath,
+		DumpPath:       filepath.Join(tempPath, "test_restore"),
+		CompressWith:   config.SubConfig{Type: "tgz", Viper: viper.New()},
+		EncryptWith:    config.SubConfig{Type: "openssl", Viper: encryptWith},
+		Archive:        archive,
+		Splitter:       splitter,
+		Viper:          viper.New(),
+		DefaultStorage: "local",
+		Storages: map[string]config.SubConfig{
+			"local": {Name: "local", Type: "local", Viper: v},
+		},
+	}}
+
+	assert.NoError(t, m.Perform())
+	entries, err := os.ReadDir(storagePath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	first := entries[0].Name()
+	chunks, err := filepath.Glob(filepath.Join(storagePath, first, first+".tar.gz.enc-*"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(chunks))
+
+	// The package names are in seconds
+	time.Sleep(time.Second)
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "bar.txt"), []byte("v2"), 0640))
+	assert.NoError(t, m.Perform())
+
+	// The chunks are joined and decrypted
+	targetPath := t.TempDir()
+	assert.NoError(t, m.Restore(RestoreOptions{FileKey: first, TargetPath: targetPath}))
+	restored := filepath.Join(targetPath, src)
+	for name, expected := range map[string][]byte{"foo.bin": data, "bar.txt": []byte("v1")} {
+		actual, err := os.ReadFile(filepath.Join(restored, name))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+	assert.False(t, helper.IsExistsPath(tempPath))
+
+	// The latest package is restored into the work dir by default
+	assert.NoError(t, m.Restore(RestoreOptions{}))
+	restored = filepath.Join(m.Config.WorkDir, "restore", "test_restore", src)
+	actual, err := os.ReadFile(filepath.Join(restored, "bar.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", string(actual))
+	actual, err = os.ReadFile(filepath.Join(restored, "foo.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, data, actual)
+	assert.False(t, helper.IsExistsPath(tempPath))
+}
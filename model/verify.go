@@ -0,0 +1,142 @@
+package model
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/itgcloud/gobackup/compressor"
+	"github.com/itgcloud/gobackup/encryptor"
+	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/manifest"
+	"github.com/itgcloud/gobackup/notifier"
+	"github.com/itgcloud/gobackup/storage"
+)
+
+// The compressions can not be listed by Go, they will be skipped in verify
+var unlistableExts = []string{".tar.Z", ".tar.lz", ".tar.lzma", ".tar.lzo"}
+
+// Verify re-download the package from the default storage without writing to the disk:
+//
+// - check the size and SHA-256 of each file with the manifest
+// - test decrypt and decompress the archive, and read through all entries of tar
+//
+// The failure will be sent by notifiers.
+func (m Model) Verify(fileKey string) (err error) {
+	logger := logger.Tag(fmt.Sprintf("Model: %s", m.Config.Name))
+
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("Verify failed: %v", err)
+			notifier.Failure(m.Config, err.Error())
+		}
+	}()
+
+	pkg, err := storage.FindPackage(m.Config, fileKey)
+	if err != nil {
+		return err
+	}
+	logger.Info("Verify package:", pkg.FileKey)
+
+	mf, err := storage.ReadManifest(m.Config, pkg)
+	if err != nil {
+		// Packages before manifest was introduced
+		logger.Warnf("%v, skip checksums", err)
+		mf = nil
+	}
+
+	reader, err := storage.OpenPackage(m.Config, pkg)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// /foo/bar.tar.gz.enc-000 -> bar.tar.gz.enc
+	archiveName := pkg.FileKey
+	if len(pkg.FileKeys) > 0 {
+		archiveName = chunkSuffixRegexp.ReplaceAllString(pkg.FileKeys[0], "")
+	}
+	archiveName = path.Base(archiveName)
+
+	entries, archiveErr := m.verifyArchive(archiveName, reader)
+
+	// Read the rest to calculate the checksums, even if the archive is broken
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return err
+	}
+
+	var errs []string
+	if mf != nil {
+		errs = compareFiles(mf.Files, reader.Files())
+	}
+	if archiveErr != nil {
+		errs = append(errs, archiveErr.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	logger.Infof("Verified %d files, %d entries in archive", len(reader.Files()), entries)
+	return nil
+}
+
+// verifyArchive test decrypt and decompress the archive, returns the number of entries in tar
+func (m Model) verifyArchive(archiveName string, reader io.Reader) (int, error) {
+	logger := logger.Tag("Verify")
+
+	if strings.HasSuffix(archiveName, ".enc") {
+		archiveName = strings.TrimSuffix(archiveName, ".enc")
+		decReader, err := encryptor.DecryptStream(reader, m.Config)
+		if err != nil {
+			return 0, fmt.Errorf("decrypt: %v", err)
+		}
+		defer decReader.Close()
+
+		// Read to the end, to make sure the decryptor has finished reading the package
+		defer io.Copy(io.Discard, decReader) //nolint:errcheck
+		reader = decReader
+	}
+
+	for _, ext := range unlistableExts {
+		if strings.HasSuffix(archiveName, ext) {
+			logger.Warnf("%s is not supported to list, skip decompress", ext)
+			_, err := io.Copy(io.Discard, reader)
+			return 0, err
+		}
+	}
+
+	entries, err := compressor.List(reader)
+	if err != nil {
+		return entries, fmt.Errorf("archive: %v", err)
+	}
+
+	return entries, nil
+}
+
+// compareFiles returns the errors of files are different from the manifest
+func compareFiles(expected, actual []manifest.File) (errs []string) {
+	files := map[string]manifest.File{}
+	for _, file := range actual {
+		files[file.Name] = file
+	}
+
+	for _, want := range expected {
+		got, ok := files[want.Name]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Sprintf("%s: not found", want.Name))
+		case got.Size != want.Size:
+			errs = append(errs, fmt.Sprintf("%s: size mismatch, expected %d, got %d", want.Name, want.Size, got.Size))
+		case got.SHA256 != want.SHA256:
+			errs = append(errs, fmt.Sprintf("%s: sha256 mismatch, expected %s, got %s", want.Name, want.SHA256, got.SHA256))
+		}
+		delete(files, want.Name)
+	}
+
+	for name := range files {
+		errs = append(errs, fmt.Sprintf("%s: not in manifest", name))
+	}
+
+	return errs
+}
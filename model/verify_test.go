@@ -0,0 +1,93 @@
+package model
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/archive"
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/manifest"
+	"github.com/itgcloud/gobackup/storage"
+)
+
+// setTempHome isolate the state of gobackup (e.g.: cycler.json) into a temp HOME
+func setTempHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	oldGoBackupDir := config.GoBackupDir
+	config.GoBackupDir = filepath.Join(home, ".gobackup")
+	t.Cleanup(func() {
+		config.GoBackupDir = oldGoBackupDir
+	})
+}
+
+func TestModel_Verify(t *testing.T) {
+	setTempHome(t)
+
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "foo.txt"), bytes.Repeat([]byte("foo"), 1000), 0640))
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	assert.NoError(t, archive.Write(zw, []string{src}, nil))
+	assert.NoError(t, zw.Close())
+
+	archivePath := filepath.Join(t.TempDir(), "test_verify-2023-01-01-00-00-00.tar.gz")
+	assert.NoError(t, os.WriteFile(archivePath, buf.Bytes(), 0640))
+
+	storagePath := t.TempDir()
+	v := viper.New()
+	v.Set("path", storagePath)
+	m := Model{Config: config.ModelConfig{
+		Name:           "test_verify",
+		Viper:          viper.New(),
+		DefaultStorage: "local",
+		Storages: map[string]config.SubConfig{
+			"local": {Name: "local", Type: "local", Viper: v},
+		},
+	}}
+
+	assert.NoError(t, storage.Run(m.Config, archivePath, &manifest.Manifest{Model: m.Config.Name}))
+	assert.NoError(t, m.Verify(""))
+
+	// tampered
+	storedPath := filepath.Join(storagePath, filepath.Base(archivePath))
+	data := buf.Bytes()
+	data[len(data)-10] ^= 0xff
+	assert.NoError(t, os.WriteFile(storedPath, data, 0640))
+
+	err := m.Verify(filepath.Base(archivePath))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "sha256 mismatch")
+	assert.Contains(t, err.Error(), "archive:")
+}
+
+func TestCompareFiles(t *testing.T) {
+	expected := []manifest.File{
+		{Name: "a", Size: 1, SHA256: "aa"},
+		{Name: "b", Size: 2, SHA256: "bb"},
+		{Name: "c", Size: 3, SHA256: "cc"},
+		{Name: "d", Size: 4, SHA256: "dd"},
+	}
+	actual := []manifest.File{
+		{Name: "a", Size: 1, SHA256: "aa"},
+		{Name: "b", Size: 3, SHA256: "bb"},
+		{Name: "c", Size: 3, SHA256: "xx"},
+		{Name: "e", Size: 5, SHA256: "ee"},
+	}
+
+	assert.Equal(t, []string{
+		"b: size mismatch, expected 2, got 3",
+		"c: sha256 mismatch, expected cc, got xx",
+		"d: not found",
+		"e: not in manifest",
+	}, compareFiles(expected, actual))
+	assert.Equal(t, 0, len(compareFiles(expected, expected)))
+}
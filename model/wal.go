@@ -0,0 +1,101 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/itgcloud/gobackup/encryptor"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/storage"
+)
+
+// walDir returns the directory of WAL segments in storages for the PostgreSQL database,
+// default is `wal/<database name>`
+func (m Model) walDir(database string) (string, error) {
+	dbConfig, ok := m.Config.Databases[database]
+	if !ok {
+		return "", fmt.Errorf("database %s not found in model %s", database, m.Config.Name)
+	}
+	if dbConfig.Type != "postgresql" {
+		return "", fmt.Errorf("database %s is not postgresql", database)
+	}
+
+	dir := dbConfig.Viper.GetString("wal_path")
+	if len(dir) == 0 {
+		dir = path.Join("wal", dbConfig.Name)
+	}
+
+	return dir, nil
+}
+
+// PushWAL archive the WAL segment of PostgreSQL into all storages of the model with `encrypt_with`,
+// it works as the `archive_command`:
+//
+//	archive_command = 'gobackup wal-push -m my_model -d my_pg %p'
+func (m Model) PushWAL(database, walPath string) error {
+	logger := logger.Tag(fmt.Sprintf("Model: %s", m.Config.Name))
+
+	dir, err := m.walDir(database)
+	if err != nil {
+		return err
+	}
+
+	if err := helper.MkdirP(m.Config.TempPath); err != nil {
+		return err
+	}
+	defer os.RemoveAll(m.Config.TempPath)
+
+	// Copy into the temp path, so the encrypted file will not be written into pg_wal
+	archivePath := filepath.Join(m.Config.TempPath, filepath.Base(walPath))
+	if err := helper.CopyFile(walPath, archivePath); err != nil {
+		return fmt.Errorf("failed to read WAL %s: %v", walPath, err)
+	}
+
+	archivePath, err = encryptor.Run(archivePath, m.Config)
+	if err != nil {
+		return err
+	}
+
+	if err := storage.UploadFile(m.Config, dir, archivePath); err != nil {
+		return err
+	}
+	logger.Infof("WAL %s archived", filepath.Base(walPath))
+
+	return nil
+}
+
+// FetchWAL download the WAL segment from the default storage into `targetPath` and decrypt it,
+// it works as the `restore_command` for point-in-time recovery:
+//
+//	restore_command = 'gobackup wal-fetch -m my_model -d my_pg %f %p'
+func (m Model) FetchWAL(database, walName, targetPath string) error {
+	dir, err := m.walDir(database)
+	if err != nil {
+		return err
+	}
+
+	fileKey := path.Join(dir, walName)
+	if encryptor.Enabled(m.Config) {
+		fileKey += ".enc"
+	}
+
+	if err := helper.MkdirP(m.Config.TempPath); err != nil {
+		return err
+	}
+	defer os.RemoveAll(m.Config.TempPath)
+
+	paths, err := storage.Fetch(m.Config, &storage.Package{FileKey: fileKey}, m.Config.TempPath)
+	if err != nil {
+		return err
+	}
+
+	walPath, err := encryptor.Decrypt(paths[0], m.Config)
+	if err != nil {
+		return err
+	}
+
+	return helper.CopyFile(walPath, targetPath)
+}
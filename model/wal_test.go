@@ -0,0 +1,68 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+)
+
+func TestModel_PushWAL(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "000000010000000000000001")
+	assert.NoError(t, os.WriteFile(walPath, []byte("wal segment"), 0600))
+
+	pathA, pathB := t.TempDir(), t.TempDir()
+	va, vb := viper.New(), viper.New()
+	va.Set("path", pathA)
+	vb.Set("path", pathB)
+	dbViper := viper.New()
+	dbViper.Set("mode", "basebackup")
+
+	m := Model{Config: config.ModelConfig{
+		Name:           "test_wal",
+		TempPath:       filepath.Join(t.TempDir(), "tmp"),
+		Viper:          viper.New(),
+		DefaultStorage: "a",
+		Databases: map[string]config.SubConfig{
+			"pg1":    {Name: "pg1", Type: "postgresql", Viper: dbViper},
+			"mysql1": {Name: "mysql1", Type: "mysql", Viper: viper.New()},
+		},
+		Storages: map[string]config.SubConfig{
+			"a": {Name: "a", Type: "local", Viper: va},
+			"b": {Name: "b", Type: "local", Viper: vb},
+		},
+	}}
+
+	assert.NoError(t, m.PushWAL("pg1", walPath))
+	for _, storagePath := range []string{pathA, pathB} {
+		data, err := os.ReadFile(filepath.Join(storagePath, "wal", "pg1", "000000010000000000000001"))
+		assert.NoError(t, err)
+		assert.Equal(t, "wal segment", string(data))
+	}
+	// The storage config is not changed
+	assert.Equal(t, pathA, va.GetString("path"))
+	assert.False(t, helper.IsExistsPath(m.Config.TempPath))
+
+	targetPath := filepath.Join(t.TempDir(), "RECOVERYXLOG")
+	assert.NoError(t, m.FetchWAL("pg1", "000000010000000000000001", targetPath))
+	data, err := os.ReadFile(targetPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "wal segment", string(data))
+
+	// The missing segment must fail for restore_command
+	assert.Error(t, m.FetchWAL("pg1", "000000010000000000000002", targetPath))
+
+	// Custom wal_path
+	dbViper.Set("wal_path", "archive/pg")
+	assert.NoError(t, m.PushWAL("pg1", walPath))
+	assert.True(t, helper.IsExistsPath(filepath.Join(pathB, "archive", "pg", "000000010000000000000001")))
+
+	assert.EqualError(t, m.PushWAL("mysql1", walPath), "database mysql1 is not postgresql")
+	assert.EqualError(t, m.PushWAL("pg2", walPath), "database pg2 not found in model test_wal")
+	assert.Error(t, m.PushWAL("pg1", filepath.Join(t.TempDir(), "not-found")))
+}
@@ -1,10 +1,13 @@
 package splitter
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	"github.com/itgcloud/gobackup/config"
@@ -13,22 +16,52 @@ import (
 	"github.com/spf13/viper"
 )
 
-// Run splitter
+// ChecksumExt is the extension of the checksum file of chunks, it is written alongside the chunks
+// in the format of `sha256sum`, e.g.: 2022.12.04.07.24.08.tar.xz.sha256
+const ChecksumExt = ".sha256"
+
+type options struct {
+	chunkSize       int64
+	suffixLength    int
+	numericSuffixes bool
+}
+
+// newOptions of splitter, Stream may be invoked by multiple storages at the same time, so don't use SetDefault here.
+func newOptions(splitter *viper.Viper) (opts options, err error) {
+	opts = options{suffixLength: 3, numericSuffixes: true}
+	if splitter.IsSet("suffix_length") {
+		opts.suffixLength = splitter.GetInt("suffix_length")
+	}
+	if splitter.IsSet("numeric_suffixes") {
+		opts.numericSuffixes = splitter.GetBool("numeric_suffixes")
+	}
+
+	if len(splitter.GetString("chunk_size")) == 0 {
+		return opts, fmt.Errorf("chunk_size option is required")
+	}
+	if opts.chunkSize, err = helper.ParseSize(splitter.GetString("chunk_size")); err != nil {
+		return opts, err
+	}
+	if opts.chunkSize <= 0 {
+		return opts, fmt.Errorf("chunk_size must be greater than 0")
+	}
+
+	return opts, nil
+}
+
+// Run splitter, the archive is split into chunks in the directory, with the checksum file of chunks
 func Run(archivePath string, model config.ModelConfig) (archiveDirPath string, err error) {
 	logger := logger.Tag("Splitter")
 
-	splitter := model.Splitter
-	if splitter == nil {
+	if model.Splitter == nil {
 		archiveDirPath = archivePath
 		return
 	}
 
 	logger.Info("Split to chunks")
 
-	splitter.SetDefault("suffix_length", 3)
-	splitter.SetDefault("numeric_suffixes", true)
-	if len(splitter.GetString("chunk_size")) == 0 {
-		err = fmt.Errorf("chunk_size option is required")
+	opts, err := newOptions(model.Splitter)
+	if err != nil {
 		return
 	}
 
@@ -38,34 +71,230 @@ func Run(archivePath string, model config.ModelConfig) (archiveDirPath string, e
 	if err = helper.MkdirP(archiveDirPath); err != nil {
 		return
 	}
-	// /tmp/gobackup3755903383/1670167448676759530/2022.12.04.07.24.08/2022.12.04.07.24.08.tar.xz-
-	splitSuffix := filepath.Join(archiveDirPath, filepath.Base(archivePath)+"-")
 
-	opts := options(splitter)
-	opts = append(opts, archivePath, splitSuffix)
-	_, err = helper.Exec("split", opts...)
+	f, err := os.Open(archivePath)
 	if err != nil {
 		return
 	}
+	defer f.Close()
 
+	// /tmp/gobackup3755903383/1670167448676759530/2022.12.04.07.24.08/2022.12.04.07.24.08.tar.xz-000
+	_, err = split(filepath.Base(archivePath), f, opts, func(name string, chunk io.Reader) error {
+		return writeFile(filepath.Join(archiveDirPath, name), chunk)
+	})
+	if err != nil {
+		return
+	}
 	logger.Info("Split done")
 
+	f.Close()
 	err = os.Remove(archivePath)
+	return
+}
+
+// Stream split the reader into chunks on the fly, `upload` will be invoked for each chunk in order,
+// and the checksum file at last. Returns the directory key of chunks and the keys of chunks and checksum file, e.g.:
+//
+//	2022.12.04.07.24.08
+//	2022.12.04.07.24.08/2022.12.04.07.24.08.tar.xz-000
+//	2022.12.04.07.24.08/2022.12.04.07.24.08.tar.xz.sha256
+func Stream(archiveName string, reader io.Reader, model config.ModelConfig, upload func(chunkKey string, chunk io.Reader) error) (fileKey string, chunkKeys []string, err error) {
+	logger := logger.Tag("Splitter")
+
+	opts, err := newOptions(model.Splitter)
 	if err != nil {
 		return
 	}
 
+	fileKey = strings.TrimSuffix(archiveName, model.Viper.GetString("Ext"))
+
+	logger.Info("Split to chunks (stream)")
+	names, err := split(archiveName, reader, opts, func(name string, chunk io.Reader) error {
+		return upload(filepath.Join(fileKey, name), chunk)
+	})
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		chunkKeys = append(chunkKeys, filepath.Join(fileKey, name))
+	}
+	logger.Info("Split done")
+
 	return
 }
 
-func options(splitter *viper.Viper) (opts []string) {
-	bytes := splitter.GetString("chunk_size")
-	opts = append(opts, "-b", bytes)
-	suffixLength := splitter.GetInt("suffix_length")
-	opts = append(opts, "-a", strconv.Itoa(suffixLength))
-	if splitter.GetBool("numeric_suffixes") {
-		opts = append(opts, "--numeric-suffixes")
+// split the reader into chunks named `<archiveName>-<suffix>`, and the checksum file `<archiveName>.sha256`.
+// The chunk must be read to the end by `write`. Returns the names of chunks and checksum file.
+func split(archiveName string, reader io.Reader, opts options, write func(name string, chunk io.Reader) error) (names []string, err error) {
+	var checksums strings.Builder
+
+	bufReader := bufio.NewReader(reader)
+	for i := 0; ; i++ {
+		// Stop when there is no more data, avoid to write an empty chunk
+		if _, err = bufReader.Peek(1); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		name := archiveName + "-" + suffix(i, opts.suffixLength, opts.numericSuffixes)
+		hash := sha256.New()
+		chunk := io.LimitReader(bufReader, opts.chunkSize)
+		if err = write(name, io.TeeReader(chunk, hash)); err != nil {
+			return nil, err
+		}
+
+		// The chunk must be read to the end, otherwise the rest data will be written into the next chunk.
+		if n, _ := io.Copy(io.Discard, chunk); n > 0 {
+			return nil, fmt.Errorf("chunk %s is not fully written, %d bytes left", name, n)
+		}
+
+		fmt.Fprintf(&checksums, "%s  %s\n", hex.EncodeToString(hash.Sum(nil)), name)
+		names = append(names, name)
 	}
 
-	return
+	name := archiveName + ChecksumExt
+	if err = write(name, strings.NewReader(checksums.String())); err != nil {
+		return nil, err
+	}
+
+	return append(names, name), nil
+}
+
+func writeFile(filePath string, reader io.Reader) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, reader); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// suffix like split: 000, 001 ... or aaa, aab ...
+func suffix(i, length int, numeric bool) string {
+	if numeric {
+		return fmt.Sprintf("%0*d", length, i)
+	}
+
+	chars := make([]byte, length)
+	for j := length - 1; j >= 0; j-- {
+		chars[j] = byte('a' + i%26)
+		i /= 26
+	}
+	return string(chars)
+}
+
+// IsChecksumFile returns true if the file is the checksum file of chunks
+func IsChecksumFile(name string) bool {
+	return strings.HasSuffix(name, ChecksumExt)
+}
+
+// Checksums of chunks by the name of chunk
+type Checksums map[string]string
+
+// ParseChecksums parse the checksum file in the format of `sha256sum`
+func ParseChecksums(reader io.Reader) (Checksums, error) {
+	checksums := Checksums{}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid checksum line: %q", line)
+		}
+		// The binary mode of sha256sum is marked by `*`
+		checksums[strings.TrimPrefix(strings.TrimSpace(name), "*")] = sum
+	}
+
+	return checksums, scanner.Err()
+}
+
+// Verify the SHA-256 of the chunk
+func (c Checksums) Verify(name, sum string) error {
+	want, ok := c[name]
+	if !ok {
+		return fmt.Errorf("%s: checksum not found", name)
+	}
+	if want != sum {
+		return fmt.Errorf("%s: sha256 mismatch, expected %s, got %s", name, want, sum)
+	}
+
+	return nil
+}
+
+// Join chunks into one file in order, the chunks are verified with the checksum file if it is in `chunkPaths`
+func Join(chunkPaths []string, archivePath string) error {
+	logger := logger.Tag("Splitter")
+
+	logger.Info("Join chunks")
+
+	var checksums Checksums
+	var paths []string
+	for _, chunkPath := range chunkPaths {
+		if !IsChecksumFile(chunkPath) {
+			paths = append(paths, chunkPath)
+			continue
+		}
+
+		f, err := os.Open(chunkPath)
+		if err != nil {
+			return err
+		}
+		checksums, err = ParseChecksums(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("parse %s failed: %v", chunkPath, err)
+		}
+	}
+
+	if checksums != nil && len(checksums) != len(paths) {
+		return fmt.Errorf("%d chunks found, but %d in checksum file", len(paths), len(checksums))
+	}
+
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for _, chunkPath := range paths {
+		sum, err := appendFile(out, chunkPath)
+		if err != nil {
+			return err
+		}
+		if checksums != nil {
+			if err := checksums.Verify(filepath.Base(chunkPath), sum); err != nil {
+				return err
+			}
+		}
+	}
+	logger.Info("Join done")
+
+	return out.Close()
+}
+
+// appendFile append the chunk into out, returns the SHA-256 of chunk
+func appendFile(out io.Writer, chunkPath string) (string, error) {
+	f, err := os.Open(chunkPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(out, hash), f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
@@ -0,0 +1,121 @@
+package splitter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+)
+
+func TestStream(t *testing.T) {
+	model := config.ModelConfig{
+		Viper:    viper.New(),
+		Splitter: viper.New(),
+	}
+	model.Viper.Set("Ext", ".tar.gz")
+	model.Splitter.Set("chunk_size", "4")
+
+	chunks := map[string]string{}
+	fileKey, chunkKeys, err := Stream("foo.tar.gz", strings.NewReader("0123456789"), model, func(chunkKey string, chunk io.Reader) error {
+		data, err := io.ReadAll(chunk)
+		chunks[chunkKey] = string(data)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", fileKey)
+	assert.Equal(t, []string{"foo/foo.tar.gz-000", "foo/foo.tar.gz-001", "foo/foo.tar.gz-002", "foo/foo.tar.gz.sha256"}, chunkKeys)
+	assert.Equal(t, "0123", chunks["foo/foo.tar.gz-000"])
+	assert.Equal(t, "89", chunks["foo/foo.tar.gz-002"])
+
+	checksums, err := ParseChecksums(strings.NewReader(chunks["foo/foo.tar.gz.sha256"]))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(checksums))
+	assert.NoError(t, checksums.Verify("foo.tar.gz-000", sha256sum("0123")))
+	assert.NoError(t, checksums.Verify("foo.tar.gz-002", sha256sum("89")))
+
+	// chunk is not fully read
+	_, _, err = Stream("foo.tar.gz", strings.NewReader("0123456789"), model, func(chunkKey string, chunk io.Reader) error {
+		return nil
+	})
+	assert.Error(t, err)
+}
+
+func TestSuffix(t *testing.T) {
+	assert.Equal(t, "000", suffix(0, 3, true))
+	assert.Equal(t, "012", suffix(12, 3, true))
+	assert.Equal(t, "aaa", suffix(0, 3, false))
+	assert.Equal(t, "abb", suffix(27, 3, false))
+}
+
+func sha256sum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRun_Join(t *testing.T) {
+	dir := t.TempDir()
+	model := config.ModelConfig{
+		Viper:    viper.New(),
+		Splitter: viper.New(),
+	}
+	model.Viper.Set("Ext", ".tar.gz")
+	model.Splitter.Set("chunk_size", "4")
+	model.Splitter.Set("suffix_length", 2)
+	model.Splitter.Set("numeric_suffixes", false)
+
+	archivePath := filepath.Join(dir, "foo.tar.gz")
+	assert.NoError(t, os.WriteFile(archivePath, []byte("0123456789"), 0644))
+
+	archiveDirPath, err := Run(archivePath, model)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "foo"), archiveDirPath)
+	assert.False(t, helper.IsExistsPath(archivePath))
+
+	chunkPaths, err := filepath.Glob(filepath.Join(archiveDirPath, "*"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(archiveDirPath, "foo.tar.gz-aa"),
+		filepath.Join(archiveDirPath, "foo.tar.gz-ab"),
+		filepath.Join(archiveDirPath, "foo.tar.gz-ac"),
+		filepath.Join(archiveDirPath, "foo.tar.gz.sha256"),
+	}, chunkPaths)
+
+	joinedPath := filepath.Join(dir, "joined.tar.gz")
+	assert.NoError(t, Join(chunkPaths, joinedPath))
+	data, err := os.ReadFile(joinedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+
+	// missing chunk
+	err = Join([]string{chunkPaths[0], chunkPaths[2], chunkPaths[3]}, joinedPath)
+	assert.EqualError(t, err, "2 chunks found, but 3 in checksum file")
+
+	// corrupted chunk
+	assert.NoError(t, os.WriteFile(chunkPaths[1], []byte("4560"), 0644))
+	err = Join(chunkPaths, joinedPath)
+	assert.EqualError(t, err, "foo.tar.gz-ab: sha256 mismatch, expected "+sha256sum("4567")+", got "+sha256sum("4560"))
+
+	// without checksum file
+	assert.NoError(t, Join(chunkPaths[:3], joinedPath))
+}
+
+func TestParseChecksums(t *testing.T) {
+	checksums, err := ParseChecksums(strings.NewReader("abc  foo.tar-000\ndef *foo.tar-001\n\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, Checksums{"foo.tar-000": "abc", "foo.tar-001": "def"}, checksums)
+
+	assert.NoError(t, checksums.Verify("foo.tar-001", "def"))
+	assert.EqualError(t, checksums.Verify("foo.tar-002", "abc"), "foo.tar-002: checksum not found")
+
+	_, err = ParseChecksums(strings.NewReader("abc\n"))
+	assert.EqualError(t, err, `invalid checksum line: "abc"`)
+}
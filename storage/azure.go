@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -12,7 +13,6 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 
-	"github.com/itgcloud/gobackup/helper"
 	"github.com/itgcloud/gobackup/logger"
 )
 
@@ -113,7 +113,7 @@ func (s *Azure) upload(fileKey string) (err error) {
 		}
 		defer f.Close()
 
-		progress := helper.NewProgressBar(logger, f)
+		progress := s.newProgressBar(logger, f)
 		if _, err = s.client.UploadStream(ctx, s.container, remotePath, progress.Reader, nil); err != nil {
 			return progress.Errorf("Azure upload error: %v", err)
 		}
@@ -124,6 +124,31 @@ func (s *Azure) upload(fileKey string) (err error) {
 	return nil
 }
 
+// uploadStream with block blob, the blocks are buffered in memory
+func (s *Azure) uploadStream(fileKey string, reader io.Reader) error {
+	logger := logger.Tag("Azure")
+
+	var ctx = context.Background()
+	var cancel context.CancelFunc
+
+	if s.timeout.Seconds() > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	// Check to create Azure Storage Container, And ignore error
+	_, _ = s.client.CreateContainer(ctx, s.container, nil)
+
+	remotePath := filepath.Join(s.path, fileKey)
+	progress := s.newStreamProgressBar(logger, reader)
+	if _, err := s.client.UploadStream(ctx, s.container, remotePath, progress.Reader, nil); err != nil {
+		return progress.Errorf("Azure upload error: %v", err)
+	}
+	progress.Done(remotePath)
+
+	return nil
+}
+
 func (s *Azure) delete(fileKey string) (err error) {
 	remotePath := filepath.Join(s.path, fileKey)
 	var ctx = context.Background()
@@ -138,7 +163,7 @@ func (s *Azure) delete(fileKey string) (err error) {
 // List the objects in the bucket with the prefix = parent
 // https://pkg.go.dev/github.com/Azure/azure-sdk-for-go/sdk/storage/azblob
 func (s *Azure) list(parent string) ([]FileItem, error) {
-	remotePath := filepath.Join(s.archivePath, parent)
+	remotePath := filepath.Join(s.path, parent)
 	var ctx = context.Background()
 
 	var fileItems []FileItem
@@ -177,3 +202,42 @@ func (s *Azure) download(fileKey string) (string, error) {
 	}
 	return blobClient.GetSASURL(sas.BlobPermissions{Read: true}, time.Now(), opts)
 }
+
+func (s *Azure) read(fileKey string) (io.ReadCloser, error) {
+	remotePath := filepath.Join(s.path, fileKey)
+	resp, err := s.client.DownloadStream(context.Background(), s.container, remotePath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Azure failed to read file %q, %v", remotePath, err)
+	}
+
+	return resp.Body, nil
+}
+
+func (s *Azure) stat(fileKey string) (FileItem, error) {
+	blobClient := s.client.ServiceClient().NewContainerClient(s.container).NewBlobClient(fileKey)
+	props, err := blobClient.GetProperties(context.Background(), nil)
+	if err != nil {
+		return FileItem{}, fmt.Errorf("Azure failed to stat file %q, %v", fileKey, err)
+	}
+
+	item := FileItem{Filename: fileKey}
+	if props.ContentLength != nil {
+		item.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		item.LastModified = *props.LastModified
+	}
+
+	return item, nil
+}
+
+func (s *Azure) readRange(fileKey string, offset int64) (io.ReadCloser, error) {
+	resp, err := s.client.DownloadStream(context.Background(), s.container, fileKey, &azblob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: offset},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Azure failed to read file %q, %v", fileKey, err)
+	}
+
+	return resp.Body, nil
+}
@@ -1,14 +1,22 @@
 package storage
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"slices"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
 	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/manifest"
+	"github.com/itgcloud/gobackup/splitter"
 	"github.com/spf13/viper"
 )
 
@@ -19,14 +27,23 @@ type Base struct {
 	archivePath string
 	fileKeys    []string
 	viper       *viper.Viper
-	keep        int
-	cycler      *Cycler
+	retention   Retention
+	retryPolicy RetryPolicy
+	// bandwidth limits the uploading, nil is unlimited
+	bandwidth *helper.Bandwidth
+	// label is the name of storage (or type for `store_with`) for logs
+	label string
+	// keepMode: local (default), remote
+	keepMode string
+	cycler   *Cycler
 }
 
 type FileItem struct {
 	Filename     string    `json:"filename,omitempty"`
 	Size         int64     `json:"size,omitempty"`
 	LastModified time.Time `json:"last_modified,omitempty"`
+	// IsDir only for the storages have directories, e.g.: local, ftp, sftp, webdav
+	IsDir bool `json:"-"`
 }
 
 // Storage interface
@@ -34,9 +51,17 @@ type Storage interface {
 	open() error
 	close()
 	upload(fileKey string) error
+	// uploadStream upload the reader to fileKey (relative to the storage path), the length of reader is unknown
+	uploadStream(fileKey string, reader io.Reader) error
 	delete(fileKey string) error
 	list(parent string) ([]FileItem, error)
 	download(fileKey string) (string, error)
+	// read open the file (relative to the storage path) for reading
+	read(fileKey string) (io.ReadCloser, error)
+	// stat returns the size and modification time of the file, the fileKey is the same as `download`
+	stat(fileKey string) (FileItem, error)
+	// readRange open the file for reading from the offset, the fileKey is the same as `download`
+	readRange(fileKey string, offset int64) (io.ReadCloser, error)
 }
 
 func newBase(model config.ModelConfig, archivePath string, storageConfig config.SubConfig) (base Base, err error) {
@@ -71,19 +96,46 @@ func newBase(model config.ModelConfig, archivePath string, storageConfig config.
 		fileKeys:    keys,
 		viper:       storageConfig.Viper,
 		cycler:      &Cycler{name: cyclerName},
+		label:       storageConfig.Name,
+	}
+	if len(base.label) == 0 {
+		base.label = storageConfig.Type
 	}
 
 	if base.viper != nil {
-		base.keep = base.viper.GetInt("keep")
+		if base.retention, err = newRetention(base.viper); err != nil {
+			return
+		}
+		if base.retryPolicy, err = newRetryPolicy(base.viper); err != nil {
+			return
+		}
+		if base.bandwidth, err = helper.NewBandwidth(base.viper.GetString("bandwidth_limit"), base.viper.GetStringSlice("bandwidth_schedule")); err != nil {
+			return
+		}
+		base.keepMode = base.viper.GetString("keep_mode")
 	}
 
 	return
 }
 
-func new(model config.ModelConfig, archivePath string, storageConfig config.SubConfig) (Base, Storage) {
+// newProgressBar returns the progress bar for uploading the file, the reader is limited by `bandwidth_limit`
+func (base *Base) newProgressBar(logger logger.Logger, f *os.File) helper.ProgressBar {
+	progress := helper.NewProgressBar(logger, f, base.label)
+	progress.Reader = base.bandwidth.Reader(progress.Reader)
+	return progress
+}
+
+// newStreamProgressBar returns the progress bar for uploading the reader, the reader is limited by `bandwidth_limit`
+func (base *Base) newStreamProgressBar(logger logger.Logger, reader io.Reader) helper.ProgressBar {
+	progress := helper.NewStreamProgressBar(logger, reader, base.label)
+	progress.Reader = base.bandwidth.Reader(progress.Reader)
+	return progress
+}
+
+func new(model config.ModelConfig, archivePath string, storageConfig config.SubConfig) (Base, Storage, error) {
 	base, err := newBase(model, archivePath, storageConfig)
 	if err != nil {
-		panic(err)
+		return base, nil, fmt.Errorf("storage %s: %v", base.label, err)
 	}
 
 	var s Storage
@@ -129,49 +181,112 @@ func new(model config.ModelConfig, archivePath string, storageConfig config.SubC
 	case "azure":
 		s = &Azure{Base: base}
 	default:
-		logger.Errorf("[%s] storage type has not implement.", storageConfig.Type)
+		return base, nil, fmt.Errorf("[%s] storage type has not implement", storageConfig.Type)
+	}
+
+	if base.retryPolicy.Retries > 0 {
+		s = &retryStorage{Storage: s, policy: base.retryPolicy, label: base.label, archivePath: base.archivePath, fileKeys: base.fileKeys}
 	}
 
-	return base, s
+	return base, s, nil
 }
 
 // run storage
-func runModel(model config.ModelConfig, archivePath string, storageConfig config.SubConfig) (err error) {
+func runModel(model config.ModelConfig, archivePath string, storageConfig config.SubConfig, m *manifest.Manifest) (err error) {
 	logger := logger.Tag("Storage")
+	defer recoverPanic(storageConfig, &err)
 
 	newFileKey := filepath.Base(archivePath)
-	base, s := new(model, archivePath, storageConfig)
+	base, s, err := new(model, archivePath, storageConfig)
+	if err != nil {
+		return err
+	}
 
-	logger.Info("=> Storage | " + storageConfig.Type)
+	logger.Infof("=> Storage | %s (%s)", storageConfig.Type, base.label)
 	err = s.open()
 	if err != nil {
 		return err
 	}
 	defer s.close()
 
-	err = s.upload(newFileKey)
+	if isRepository(model) {
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		newFileKey, err = storeRepository(s, newFileKey, f)
+	} else {
+		err = s.upload(newFileKey)
+	}
 	if err != nil {
 		return err
 	}
 
-	base.cycler.run(newFileKey, base.fileKeys, base.keep, s.delete)
+	manifestKey, err := uploadManifest(s, m, newFileKey, len(base.fileKeys) > 0)
+	if err != nil {
+		return err
+	}
+
+	base.loadRemotePackages(s)
+	removed := base.cycler.run(newFileKey, base.fileKeys, manifestKey, packageBases(m), base.retention, s.delete)
+	base.gcRepository(s, removed)
 	return nil
 }
 
-// Run storage
-func Run(model config.ModelConfig, archivePath string) (err error) {
+// recoverPanic returns the panic of storage as the error, so it will not crash the other storages and models
+func recoverPanic(storageConfig config.SubConfig, err *error) {
+	if r := recover(); r != nil {
+		logger.Tag("Storage").Errorf("%s panic: %v\n%s", storageConfig.Type, r, debug.Stack())
+		*err = fmt.Errorf("storage %s (%s) panic: %v", storageConfig.Type, storageConfig.Name, r)
+	}
+}
+
+// Run storage, the manifest will be uploaded alongside the package if it is not nil
+func Run(model config.ModelConfig, archivePath string, m *manifest.Manifest) (err error) {
 	var errors []error
 
+	// Calculate the SHA-256 of files once for all storages
+	if m != nil {
+		if err := addManifestFiles(m, archivePath); err != nil {
+			return err
+		}
+		if isRepository(model) {
+			m.Package += repositoryIndexExt
+		}
+	}
+
 	n := len(model.Storages)
+	parallel := model.MaxParallelStorages
+	if parallel <= 0 || parallel > n {
+		parallel = n
+	}
+
+	var wg sync.WaitGroup
+	results := make([]error, n)
+	sem := make(chan struct{}, parallel)
+
+	i := 0
 	for _, storageConfig := range model.Storages {
-		err := runModel(model, archivePath, storageConfig)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, storageConfig config.SubConfig) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i] = runModel(model, archivePath, storageConfig, m)
+		}(i, storageConfig)
+		i++
+	}
+	wg.Wait()
+
+	for _, err := range results {
 		if err != nil {
 			if n == 1 {
 				return err
-			} else {
-				errors = append(errors, err)
-				continue
 			}
+			errors = append(errors, err)
 		}
 	}
 
@@ -182,10 +297,241 @@ func Run(model config.ModelConfig, archivePath string) (err error) {
 	return nil
 }
 
+func runModelStream(model config.ModelConfig, archiveName string, reader io.Reader, storageConfig config.SubConfig, newManifest func() *manifest.Manifest) (err error) {
+	logger := logger.Tag("Storage")
+	defer recoverPanic(storageConfig, &err)
+
+	base, s, err := new(model, "", storageConfig)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("=> Storage | %s (%s, stream)", storageConfig.Type, base.label)
+	err = s.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	// Calculate the SHA-256 of files while uploading, each storage has its own manifest
+	var files []manifest.File
+	uploadStream := func(fileKey string, reader io.Reader) error {
+		hashReader := manifest.NewHashReader(reader)
+		var err error
+		if model.Splitter != nil && base.retryPolicy.Retries > 0 {
+			// Spool the chunk to retry it
+			err = uploadSpooled(s, filepath.Join(model.TempPath, "spool", base.label), fileKey, hashReader)
+		} else {
+			err = s.uploadStream(fileKey, hashReader)
+		}
+		if err != nil {
+			return err
+		}
+		files = append(files, hashReader.File(fileKey))
+		return nil
+	}
+
+	fileKey := archiveName
+	var fileKeys []string
+	switch {
+	case isRepository(model):
+		hashReader := manifest.NewHashReader(reader)
+		if fileKey, err = storeRepository(s, archiveName, hashReader); err == nil {
+			files = append(files, hashReader.File(archiveName))
+		}
+	case model.Splitter != nil:
+		fileKey, fileKeys, err = splitter.Stream(archiveName, reader, model, uploadStream)
+	default:
+		err = uploadStream(fileKey, reader)
+	}
+	if err != nil {
+		return err
+	}
+
+	m := newManifest()
+	if m != nil {
+		m = m.Clone()
+		m.Package = fileKey
+		m.Files = files
+	}
+	manifestKey, err := uploadManifest(s, m, fileKey, len(fileKeys) > 0)
+	if err != nil {
+		return err
+	}
+
+	base.loadRemotePackages(s)
+	removed := base.cycler.run(fileKey, fileKeys, manifestKey, packageBases(m), base.retention, s.delete)
+	base.gcRepository(s, removed)
+	return nil
+}
+
+// gcRepository remove the unreferenced chunks after the packages have been removed
+func (base *Base) gcRepository(s Storage, removed PackageList) {
+	if !isRepository(base.model) || len(removed) == 0 {
+		return
+	}
+
+	if err := gcRepository(s, base.viper.GetString("path")); err != nil {
+		logger.Tag("Repository").Warnf("Remove unreferenced chunks failed, they will be removed next time: %v", err)
+	}
+}
+
+// packageBases returns the packages which the incremental or differential archive
+// and the incremental backups of databases depend on
+func packageBases(m *manifest.Manifest) (bases []string) {
+	if m == nil {
+		return nil
+	}
+
+	if m.Archive != nil && len(m.Archive.Base) > 0 {
+		bases = append(bases, m.Archive.Base)
+	}
+	for _, db := range m.Databases {
+		if db.Backup != nil && len(db.Backup.Base) > 0 && !slices.Contains(bases, db.Backup.Base) {
+			bases = append(bases, db.Backup.Base)
+		}
+	}
+
+	return bases
+}
+
+// loadRemotePackages use the packages listed from the storage as the source of truth when `keep_mode: remote`,
+// the local cycler JSON will be only a cache.
+func (base *Base) loadRemotePackages(s Storage) {
+	if base.keepMode != "remote" {
+		return
+	}
+
+	packages, err := listPackages(s, base.model.Name, base.viper.GetString("path"))
+	if err != nil {
+		logger.Tag("Storage").Warnf("List packages from storage failed, fallback to the local cycler: %v", err)
+		return
+	}
+
+	base.cycler.useRemote(packages)
+}
+
+// uploadManifest upload manifest.json alongside the package, returns the file key of manifest
+func uploadManifest(s Storage, m *manifest.Manifest, fileKey string, split bool) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+
+	// Copy to avoid changing the shared manifest
+	mf := *m
+	mf.FinishedAt = time.Now()
+	data, err := mf.JSON()
+	if err != nil {
+		return "", err
+	}
+
+	manifestKey := manifest.Key(fileKey, split)
+	if err := s.uploadStream(manifestKey, bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("upload %s failed: %v", manifestKey, err)
+	}
+
+	return manifestKey, nil
+}
+
+// addManifestFiles add the files of archivePath into manifest, archivePath may be a directory of chunks
+func addManifestFiles(m *manifest.Manifest, archivePath string) error {
+	m.Package = filepath.Base(archivePath)
+
+	fi, err := os.Stat(archivePath)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return m.AddLocalFile(archivePath, m.Package)
+	}
+
+	entries, err := os.ReadDir(archivePath)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := m.AddLocalFile(filepath.Join(archivePath, e.Name()), filepath.Join(m.Package, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunStream upload the reader into all storages at the same time, each storage reads a copy of reader by pipe.
+//
+// The manifest is created by `newManifest` after the reader has been read to the end, so the dumps streamed
+// into the archive are recorded. It can be nil to skip the manifest.
+func RunStream(model config.ModelConfig, archiveName string, reader io.Reader, newManifest func() *manifest.Manifest) error {
+	var wg sync.WaitGroup
+
+	// It is set before the pipes are closed, the storages read it after the end of their pipes
+	var m *manifest.Manifest
+	getManifest := func() *manifest.Manifest {
+		return m
+	}
+
+	errors := make([]error, len(model.Storages))
+	writers := make([]io.Writer, 0, len(model.Storages))
+	pipeWriters := make([]*io.PipeWriter, 0, len(model.Storages))
+
+	i := 0
+	for _, storageConfig := range model.Storages {
+		pr, pw := io.Pipe()
+		writers = append(writers, pw)
+		pipeWriters = append(pipeWriters, pw)
+
+		wg.Add(1)
+		go func(i int, storageConfig config.SubConfig) {
+			defer wg.Done()
+
+			errors[i] = runModelStream(model, archiveName, pr, storageConfig, getManifest)
+			// Drain the rest of failed storage, to let the others continue
+			_, _ = io.Copy(io.Discard, pr)
+		}(i, storageConfig)
+		i++
+	}
+
+	_, readErr := io.Copy(io.MultiWriter(writers...), reader)
+	if readErr == nil && newManifest != nil {
+		m = newManifest()
+	}
+	for _, pw := range pipeWriters {
+		pw.CloseWithError(readErr)
+	}
+	wg.Wait()
+
+	if readErr != nil {
+		return readErr
+	}
+
+	var errs []error
+	for _, err := range errors {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 1 && len(model.Storages) == 1 {
+		return errs[0]
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("Storage errors: %v", errs)
+	}
+
+	return nil
+}
+
 // List return file list of storage
 func List(model config.ModelConfig, parent string) (items []FileItem, err error) {
 	if storageConfig, ok := model.Storages[model.DefaultStorage]; ok {
-		_, s := new(model, "", storageConfig)
+		_, s, err := new(model, "", storageConfig)
+		if err != nil {
+			return nil, err
+		}
 		err = s.open()
 		if err != nil {
 			return nil, err
@@ -196,11 +542,18 @@ func List(model config.ModelConfig, parent string) (items []FileItem, err error)
 			parent = "/"
 		}
 
-		items, err := s.list(parent)
+		allItems, err := s.list(parent)
 		if err != nil {
 			return []FileItem{}, err
 		}
 
+		items := []FileItem{}
+		for _, item := range allItems {
+			if !item.IsDir {
+				items = append(items, item)
+			}
+		}
+
 		// Sort items by LastModified, Filename in descending
 		sort.Slice(items, func(i, j int) bool {
 			if items[i].LastModified == items[j].LastModified {
@@ -217,8 +570,11 @@ func List(model config.ModelConfig, parent string) (items []FileItem, err error)
 
 func Download(model config.ModelConfig, fileKey string) (string, error) {
 	if storageConfig, ok := model.Storages[model.DefaultStorage]; ok {
-		_, s := new(model, "", storageConfig)
-		err := s.open()
+		_, s, err := new(model, "", storageConfig)
+		if err != nil {
+			return "", err
+		}
+		err = s.open()
 		if err != nil {
 			return "", err
 		}
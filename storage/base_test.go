@@ -1,10 +1,19 @@
 package storage
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/manifest"
 	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
 )
 
 func TestBase_newBase(t *testing.T) {
@@ -15,5 +24,215 @@ func TestBase_newBase(t *testing.T) {
 	assert.Equal(t, s.archivePath, archivePath)
 	assert.Equal(t, s.model, model)
 	assert.Equal(t, s.viper, model.Viper)
-	assert.Equal(t, s.keep, 0)
+	assert.Equal(t, s.retention.Last, 0)
+}
+
+func TestNew_invalidConfig(t *testing.T) {
+	setTempHome(t)
+
+	v := viper.New()
+	v.Set("path", t.TempDir())
+	v.Set("keep_within", "foo")
+	_, _, err := new(config.ModelConfig{}, "", config.SubConfig{Name: "local", Type: "local", Viper: v})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "storage local:")
+
+	_, _, err = new(config.ModelConfig{}, "", config.SubConfig{Type: "foo", Viper: viper.New()})
+	assert.EqualError(t, err, "[foo] storage type has not implement")
+
+	// The model fails instead of panic
+	model := config.ModelConfig{
+		Name:  "test_invalid_config",
+		Viper: viper.New(),
+		Storages: map[string]config.SubConfig{
+			"local": {Name: "local", Type: "local", Viper: v},
+		},
+	}
+	err = Run(model, filepath.Join(t.TempDir(), "foo.tar"), nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "storage local:")
+}
+
+func TestRecoverPanic(t *testing.T) {
+	run := func() (err error) {
+		defer recoverPanic(config.SubConfig{Name: "foo", Type: "local"}, &err)
+		panic("boom")
+	}
+
+	assert.EqualError(t, run(), "storage local (foo) panic: boom")
+}
+
+func TestRunStream(t *testing.T) {
+	setTempHome(t)
+
+	path1, path2 := t.TempDir(), t.TempDir()
+	v1, v2 := viper.New(), viper.New()
+	v1.Set("path", path1)
+	v2.Set("path", path2)
+
+	model := config.ModelConfig{
+		Name:  "test_run_stream",
+		Viper: viper.New(),
+		Storages: map[string]config.SubConfig{
+			"local1": {Name: "local1", Type: "local", Viper: v1},
+			"local2": {Name: "local2", Type: "local", Viper: v2},
+		},
+	}
+
+	err := RunStream(model, "foo.tar.gz", strings.NewReader("hello world"), nil)
+	assert.NoError(t, err)
+
+	for _, p := range []string{path1, path2} {
+		data, err := os.ReadFile(filepath.Join(p, "foo.tar.gz"))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(data))
+	}
+
+	// The incomplete file is removed when the stream failed, e.g. a dump failed
+	reader := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(fmt.Errorf("dump failed")))
+	assert.EqualError(t, RunStream(model, "bar.tar.gz", reader, nil), "dump failed")
+	for _, p := range []string{path1, path2} {
+		assert.False(t, helper.IsExistsPath(filepath.Join(p, "bar.tar.gz")))
+	}
+}
+
+func TestRun_manifest(t *testing.T) {
+	setTempHome(t)
+
+	storagePath := t.TempDir()
+	v := viper.New()
+	v.Set("path", storagePath)
+
+	model := config.ModelConfig{
+		Name:  "test_run_manifest",
+		Viper: viper.New(),
+		Storages: map[string]config.SubConfig{
+			"local": {Name: "local", Type: "local", Viper: v},
+		},
+	}
+
+	// split package
+	archivePath := filepath.Join(t.TempDir(), "foo")
+	assert.NoError(t, os.MkdirAll(archivePath, 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(archivePath, "foo.tar-000"), []byte("hello"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(archivePath, "foo.tar-001"), []byte("world"), 0640))
+
+	err := Run(model, archivePath, &manifest.Manifest{Model: model.Name})
+	assert.NoError(t, err)
+
+	f, err := os.Open(filepath.Join(storagePath, "foo", "manifest.json"))
+	assert.NoError(t, err)
+	defer f.Close()
+	m, err := manifest.Read(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", m.Package)
+	assert.Equal(t, 2, len(m.Files))
+	assert.Equal(t, "foo/foo.tar-001", m.Files[1].Name)
+	assert.Equal(t, "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7", m.Files[1].SHA256)
+	assert.False(t, m.FinishedAt.IsZero())
+
+	// stream, the manifest is created after the stream has been read, e.g. the dumps streamed into it
+	reader := strings.NewReader("hello world")
+	err = RunStream(model, "bar.tar", reader, func() *manifest.Manifest {
+		assert.Equal(t, 0, reader.Len())
+		return &manifest.Manifest{Model: model.Name, Databases: []manifest.Database{{Name: "db1", Size: 100}}}
+	})
+	assert.NoError(t, err)
+
+	f1, err := os.Open(filepath.Join(storagePath, "bar.tar.manifest.json"))
+	assert.NoError(t, err)
+	defer f1.Close()
+	m, err = manifest.Read(f1)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar.tar", m.Package)
+	assert.Equal(t, int64(100), m.Databases[0].Size)
+	assert.Equal(t, []manifest.File{{
+		Name:   "bar.tar",
+		Size:   11,
+		SHA256: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+	}}, m.Files)
+}
+
+func TestRun_parallel(t *testing.T) {
+	setTempHome(t)
+
+	archivePath := filepath.Join(t.TempDir(), "foo.tar")
+	assert.NoError(t, os.WriteFile(archivePath, []byte("hello"), 0640))
+
+	// A file as the storage path to fail
+	brokenPath := filepath.Join(t.TempDir(), "broken")
+	assert.NoError(t, os.WriteFile(brokenPath, []byte(""), 0640))
+
+	storages := map[string]config.SubConfig{}
+	var paths []string
+	for _, name := range []string{"local1", "local2", "local3"} {
+		v := viper.New()
+		storagePath := t.TempDir()
+		v.Set("path", storagePath)
+		paths = append(paths, storagePath)
+		storages[name] = config.SubConfig{Name: name, Type: "local", Viper: v}
+	}
+
+	model := config.ModelConfig{
+		Name:                "test_run_parallel",
+		Viper:               viper.New(),
+		Storages:            storages,
+		MaxParallelStorages: 2,
+	}
+
+	err := Run(model, archivePath, nil)
+	assert.NoError(t, err)
+	for _, storagePath := range paths {
+		data, err := os.ReadFile(filepath.Join(storagePath, "foo.tar"))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+	}
+
+	v := viper.New()
+	v.Set("path", brokenPath)
+	storages["broken"] = config.SubConfig{Name: "broken", Type: "local", Viper: v}
+	model.MaxParallelStorages = 0
+
+	err = Run(model, archivePath, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Storage errors:")
+	assert.Contains(t, err.Error(), brokenPath)
+}
+
+func TestRun_bandwidth(t *testing.T) {
+	setTempHome(t)
+
+	storagePath := t.TempDir()
+	v := viper.New()
+	v.Set("path", storagePath)
+	v.Set("bandwidth_limit", "10MB/s")
+
+	model := config.ModelConfig{
+		Name:  "test_run_bandwidth",
+		Viper: viper.New(),
+		Storages: map[string]config.SubConfig{
+			"local": {Name: "local", Type: "local", Viper: v},
+		},
+	}
+
+	base, _, err := new(model, "", model.Storages["local"])
+	assert.NoError(t, err)
+	assert.NotNil(t, base.bandwidth)
+
+	// Copied by Go instead of `cp`
+	archivePath := filepath.Join(t.TempDir(), "foo")
+	assert.NoError(t, os.MkdirAll(archivePath, 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(archivePath, "foo.tar-000"), []byte("hello"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(archivePath, "foo.tar-001"), []byte("world"), 0640))
+
+	err = Run(model, archivePath, nil)
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(storagePath, "foo", "foo.tar-001"))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	v.Set("bandwidth_schedule", []string{"foo"})
+	_, err = newBase(model, "", model.Storages["local"])
+	assert.EqualError(t, err, "invalid bandwidth schedule: \"foo\", it should be like `08:00-18:00 5MB/s`")
 }
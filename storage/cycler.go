@@ -3,40 +3,84 @@ package storage
 import (
 	"encoding/json"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/itgcloud/gobackup/config"
 	"github.com/itgcloud/gobackup/helper"
 	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/manifest"
 )
 
 type PackageList []Package
 
 // When `FileKeys` is not empty, `FileKey` is the directory
 type Package struct {
-	FileKey   string    `json:"file_key"`
-	FileKeys  []string  `json:"file_keys,omitempty"`
+	FileKey  string   `json:"file_key"`
+	FileKeys []string `json:"file_keys,omitempty"`
+	// Manifest is the file key of manifest.json
+	Manifest  string    `json:"manifest,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+	// Bases are the packages which the incremental archive or database backups depend on
+	Bases []string `json:"bases,omitempty"`
 }
 
-var (
-	cyclerPath = filepath.Join(config.GoBackupDir, "cycler")
-)
+// cyclerPath returns the directory of the cycler JSON files, it follows config.GoBackupDir
+func cyclerPath() string {
+	return filepath.Join(config.GoBackupDir, "cycler")
+}
 
 type Cycler struct {
 	name     string
 	packages PackageList
 	isLoaded bool
+	// remote is true when the packages are listed from the storage, the local JSON is only a cache
+	remote bool
 }
 
-func (c *Cycler) add(fileKey string, fileKeys []string) {
-	c.packages = append(c.packages, Package{
+func (c *Cycler) add(fileKey string, fileKeys []string, manifestKey string, bases []string) {
+	pkg := Package{
 		FileKey:   fileKey,
 		FileKeys:  fileKeys,
+		Manifest:  manifestKey,
 		CreatedAt: time.Now(),
-	})
+		Bases:     bases,
+	}
+
+	// The package may have been listed from the remote
+	for i := range c.packages {
+		if c.packages[i].FileKey == fileKey {
+			pkg.CreatedAt = c.packages[i].CreatedAt
+			c.packages = append(append(c.packages[:i:i], c.packages[i+1:]...), pkg)
+			return
+		}
+	}
+
+	c.packages = append(c.packages, pkg)
+}
+
+// useRemote replace the packages with the list of remote, the base of packages can not be listed,
+// so it is kept from the local cache.
+func (c *Cycler) useRemote(packages PackageList) {
+	var cached PackageList
+	if data, err := os.ReadFile(c.fileName()); err == nil {
+		_ = json.Unmarshal(data, &cached)
+	}
+	bases := map[string][]string{}
+	for _, pkg := range cached {
+		bases[pkg.FileKey] = pkg.Bases
+	}
+	for i := range packages {
+		packages[i].Bases = bases[packages[i].FileKey]
+	}
+
+	c.packages = packages
+	c.isLoaded = true
+	c.remote = true
 }
 
 func (c *Cycler) shiftByKeep(keep int) (first *Package) {
@@ -49,30 +93,66 @@ func (c *Cycler) shiftByKeep(keep int) (first *Package) {
 	return
 }
 
-func (c *Cycler) run(fileKey string, fileKeys []string, keep int, deletePackage func(fileKey string) error) {
+// run add the package and remove the old packages by the retention, returns the removed packages
+func (c *Cycler) run(fileKey string, fileKeys []string, manifestKey string, bases []string, retention Retention, deletePackage func(fileKey string) error) (removed PackageList) {
 	logger := logger.Tag("Cycler")
 
-	cyclerFileName := filepath.Join(cyclerPath, c.name+".json")
+	cyclerFileName := c.fileName()
 
-	c.load(cyclerFileName)
-	c.add(fileKey, fileKeys)
+	if c.remote {
+		if err := helper.MkdirP(cyclerPath()); err != nil {
+			logger.Errorf("Failed to mkdir cycler path %s: %v", cyclerPath(), err)
+		}
+	} else {
+		c.load(cyclerFileName)
+	}
+	c.add(fileKey, fileKeys, manifestKey, bases)
 	defer c.save(cyclerFileName)
 
-	if keep == 0 {
-		return
+	if !retention.enabled() {
+		return nil
 	}
 
-	for {
-		pkg := c.shiftByKeep(keep)
-		if pkg == nil {
-			break
+	all := c.packages
+	var removes PackageList
+	if retention.timeBased() {
+		var reasons map[string][]string
+		c.packages, removes, reasons = retention.prune(all)
+		for _, pkg := range c.packages {
+			if retention.DryRun {
+				logger.Infof("Dry run, would keep %s (%s)", pkg.FileKey, strings.Join(reasons[pkg.FileKey], ", "))
+			}
+		}
+	} else {
+		for {
+			pkg := c.shiftByKeep(retention.Last)
+			if pkg == nil {
+				break
+			}
+			removes = append(removes, *pkg)
+		}
+	}
+	c.packages, removes = keepChains(all, removes)
+	if retention.DryRun {
+		c.packages = all
+	}
+
+	for _, pkg := range removes {
+		if retention.DryRun {
+			logger.Infof("Dry run, would remove %s (created at %s)", pkg.FileKey, pkg.CreatedAt.Format(time.RFC3339))
+			continue
 		}
 
 		fk := pkg.FileKey
 		if len(pkg.FileKeys) != 0 && !strings.HasSuffix(fk, "/") {
 			fk += "/"
 		}
-		for _, k := range append(pkg.FileKeys, fk) {
+		// The manifest.json may be in the directory, so delete it before the directory
+		keys := append([]string{}, pkg.FileKeys...)
+		if len(pkg.Manifest) > 0 {
+			keys = append(keys, pkg.Manifest)
+		}
+		for _, k := range append(keys, fk) {
 			// deletePackage() should handle directory case which has `/` suffix
 			err := deletePackage(k)
 			if err != nil {
@@ -81,14 +161,72 @@ func (c *Cycler) run(fileKey string, fileKeys []string, keep int, deletePackage
 				logger.Info("Removed", k)
 			}
 		}
+		removed = append(removed, pkg)
 	}
+
+	return removed
+}
+
+// keepChains keep the base packages of the incremental or differential archives (and the incremental
+// physical backups of databases) which are kept, so the full backup will never be removed while
+// the backups depend on it are kept.
+// The order of packages is retained.
+func keepChains(all, removes PackageList) (keeps, rest PackageList) {
+	logger := logger.Tag("Cycler")
+
+	removing := map[string]bool{}
+	for _, pkg := range removes {
+		removing[pkg.FileKey] = true
+	}
+	packages := map[string]Package{}
+	for _, pkg := range all {
+		packages[manifest.PackageID(pkg.FileKey)] = pkg
+	}
+
+	for _, pkg := range all {
+		if removing[pkg.FileKey] {
+			continue
+		}
+
+		// The kept bases have been walked by themselves
+		children := []Package{pkg}
+		for len(children) > 0 {
+			child := children[len(children)-1]
+			children = children[:len(children)-1]
+
+			for _, id := range child.Bases {
+				base, ok := packages[id]
+				if !ok || !removing[base.FileKey] {
+					continue
+				}
+
+				logger.Infof("Keep %s, %s depends on it", base.FileKey, child.FileKey)
+				delete(removing, base.FileKey)
+				children = append(children, base)
+			}
+		}
+	}
+
+	for _, pkg := range all {
+		if removing[pkg.FileKey] {
+			rest = append(rest, pkg)
+		} else {
+			keeps = append(keeps, pkg)
+		}
+	}
+
+	return keeps, rest
+}
+
+func (c *Cycler) fileName() string {
+	return filepath.Join(cyclerPath(), c.name+".json")
 }
 
 func (c *Cycler) load(cyclerFileName string) {
 	logger := logger.Tag("Cycler")
 
-	if err := helper.MkdirP(cyclerPath); err != nil {
-		logger.Errorf("Failed to mkdir cycler path %s: %v", cyclerPath, err)
+	if err := helper.MkdirP(cyclerPath()); err != nil {
+		logger.Errorf("Failed to mkdir cycler path %s: %v", cyclerPath(), err)
 		return
 	}
 
@@ -132,3 +270,118 @@ func (c *Cycler) save(cyclerFileName string) {
 		return
 	}
 }
+
+// listPackages list the packages of model from the storage, the files are grouped into packages by the naming pattern:
+//
+//	<model>-2006-01-02-15-04-05.tar.gz
+//	<model>-2006-01-02-15-04-05.tar.gz.manifest.json
+//	<model>-2006-01-02-15-04-05/<model>-2006-01-02-15-04-05.tar.gz-000
+//	<model>-2006-01-02-15-04-05/manifest.json
+//
+// Other files are ignored. Returns the packages sorted by created time, the oldest first.
+func listPackages(s Storage, modelName string, storagePath string) (PackageList, error) {
+	items, err := s.list("")
+	if err != nil {
+		return nil, err
+	}
+
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(modelName) + `-(\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2})(\..+)?$`)
+
+	// The object storages returns the keys with storage path, e.g.: backups/foo.tar.gz
+	root := storageRoot(storagePath)
+
+	packages := map[string]*Package{}
+	findPackage := func(name string, split bool) *Package {
+		matches := pattern.FindStringSubmatch(name)
+		if matches == nil || (split && len(matches[2]) > 0) {
+			return nil
+		}
+
+		id := name
+		if !split {
+			id = strings.TrimSuffix(name, matches[2])
+		}
+		pkg, ok := packages[id]
+		if !ok {
+			createdAt, _ := time.ParseInLocation("2006-01-02-15-04-05", matches[1], time.Local)
+			pkg = &Package{CreatedAt: createdAt}
+			packages[id] = pkg
+		}
+		if split {
+			pkg.FileKey = name
+		}
+		return pkg
+	}
+
+	for _, item := range items {
+		key := strings.TrimPrefix(strings.TrimPrefix(item.Filename, "/"), root+"/")
+		parts := strings.Split(key, "/")
+
+		switch {
+		case item.IsDir && len(parts) == 1:
+			// The directory of chunks in the storages have directories
+			pkg := findPackage(key, true)
+			if pkg == nil {
+				continue
+			}
+			children, err := s.list(key)
+			if err != nil {
+				return nil, err
+			}
+			for _, child := range children {
+				if !child.IsDir {
+					addPackageFile(pkg, path.Join(key, path.Base(child.Filename)))
+				}
+			}
+		case !item.IsDir && len(parts) == 1:
+			if pkg := findPackage(key, false); pkg != nil {
+				addPackageFile(pkg, key)
+			}
+		case !item.IsDir && len(parts) == 2:
+			if pkg := findPackage(parts[0], true); pkg != nil {
+				addPackageFile(pkg, key)
+			}
+		}
+	}
+
+	result := PackageList{}
+	for _, pkg := range packages {
+		if len(pkg.FileKey) == 0 {
+			// Only manifest without archive
+			continue
+		}
+		sort.Strings(pkg.FileKeys)
+		result = append(result, *pkg)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].FileKey < result[j].FileKey
+		}
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+
+	return result, nil
+}
+
+// storageRoot is the storage path without the leading and trailing `/`, for trimming the keys listed from
+// the object storages
+func storageRoot(storagePath string) string {
+	root := strings.Trim(filepath.Clean(storagePath), "/")
+	if root == "." {
+		return ""
+	}
+
+	return root
+}
+
+func addPackageFile(pkg *Package, key string) {
+	dir, name := path.Split(key)
+	switch {
+	case name == manifest.FileName && len(dir) > 0, strings.HasSuffix(name, "."+manifest.FileName):
+		pkg.Manifest = key
+	case len(dir) > 0:
+		pkg.FileKeys = append(pkg.FileKeys, key)
+	default:
+		pkg.FileKey = key
+	}
+}
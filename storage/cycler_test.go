@@ -1,16 +1,33 @@
 package storage
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/manifest"
 	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
 )
 
+// setTempHome isolate the state of gobackup (e.g.: cycler.json) into a temp HOME
+func setTempHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	oldGoBackupDir := config.GoBackupDir
+	config.GoBackupDir = filepath.Join(home, ".gobackup")
+	t.Cleanup(func() {
+		config.GoBackupDir = oldGoBackupDir
+	})
+}
+
 func TestCycler_add(t *testing.T) {
 	cycler := Cycler{}
-	cycler.add("foo", []string{})
-	cycler.add("bar", []string{})
+	cycler.add("foo", []string{}, "", nil)
+	cycler.add("bar", []string{}, "", nil)
 
 	assert.Equal(t, len(cycler.packages), 2)
 }
@@ -28,10 +45,10 @@ func TestCycler_shiftByKeep(t *testing.T) {
 			},
 		},
 	}
-	cycler.add("p3", []string{})
-	cycler.add("p4", []string{})
-	cycler.add("p5", []string{})
-	cycler.add("p6", []string{})
+	cycler.add("p3", []string{}, "", nil)
+	cycler.add("p4", []string{}, "", nil)
+	cycler.add("p5", []string{}, "", nil)
+	cycler.add("p6", []string{}, "", nil)
 
 	pkg := cycler.shiftByKeep(2)
 	assert.Equal(t, len(cycler.packages), 5)
@@ -43,3 +60,218 @@ func TestCycler_shiftByKeep(t *testing.T) {
 	assert.Equal(t, len(cycler.packages), 4)
 	assert.Nil(t, pkg)
 }
+
+func TestListPackages(t *testing.T) {
+	setTempHome(t)
+
+	storagePath := t.TempDir()
+	files := []string{
+		"demo-2023-01-01-00-00-00.tar.gz",
+		"demo-2023-01-01-00-00-00.tar.gz.manifest.json",
+		"demo-2023-01-02-00-00-00/demo-2023-01-02-00-00-00.tar.gz-001",
+		"demo-2023-01-02-00-00-00/demo-2023-01-02-00-00-00.tar.gz-000",
+		"demo-2023-01-02-00-00-00/manifest.json",
+		"demo-2022-12-31-00-00-00.tar.gz",
+		// foreign files
+		"demo-foo-2023-01-03-00-00-00.tar.gz",
+		"other-2023-01-03-00-00-00.tar.gz",
+		"demo.tar.gz",
+		"demo-2023-01-04-00-00-00.tar.gz.manifest.json",
+	}
+	for _, f := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Join(storagePath, filepath.Dir(f)), 0750))
+		assert.NoError(t, os.WriteFile(filepath.Join(storagePath, f), []byte("foo"), 0640))
+	}
+
+	v := viper.New()
+	v.Set("path", storagePath)
+	_, s, err := new(config.ModelConfig{Name: "demo"}, "", config.SubConfig{Type: "local", Viper: v})
+	assert.NoError(t, err)
+	assert.NoError(t, s.open())
+
+	packages, err := listPackages(s, "demo", storagePath)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(packages))
+
+	assert.Equal(t, "demo-2022-12-31-00-00-00.tar.gz", packages[0].FileKey)
+	assert.Equal(t, "", packages[0].Manifest)
+	assert.Equal(t, "demo-2023-01-01-00-00-00.tar.gz", packages[1].FileKey)
+	assert.Equal(t, "demo-2023-01-01-00-00-00.tar.gz.manifest.json", packages[1].Manifest)
+	assert.Equal(t, "demo-2023-01-02-00-00-00", packages[2].FileKey)
+	assert.Equal(t, "demo-2023-01-02-00-00-00/manifest.json", packages[2].Manifest)
+	assert.Equal(t, []string{
+		"demo-2023-01-02-00-00-00/demo-2023-01-02-00-00-00.tar.gz-000",
+		"demo-2023-01-02-00-00-00/demo-2023-01-02-00-00-00.tar.gz-001",
+	}, packages[2].FileKeys)
+	assert.Equal(t, time.Date(2023, 1, 2, 0, 0, 0, 0, time.Local), packages[2].CreatedAt)
+
+	// object storages return the full keys with the storage path
+	items := []FileItem{
+		{Filename: "backups/demo-2023-01-01-00-00-00.tar.gz"},
+		{Filename: "backups/demo-2023-01-02-00-00-00/demo-2023-01-02-00-00-00.tar.gz-000"},
+		{Filename: "backups/demo-2023-01-02-00-00-00/manifest.json"},
+		{Filename: "backups2/demo-2023-01-03-00-00-00.tar.gz"},
+	}
+	packages, err = listPackages(&listOnlyStorage{Storage: s, items: items}, "demo", "/backups/")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(packages))
+	assert.Equal(t, "demo-2023-01-01-00-00-00.tar.gz", packages[0].FileKey)
+	assert.Equal(t, "demo-2023-01-02-00-00-00", packages[1].FileKey)
+	assert.Equal(t, []string{"demo-2023-01-02-00-00-00/demo-2023-01-02-00-00-00.tar.gz-000"}, packages[1].FileKeys)
+	assert.Equal(t, "demo-2023-01-02-00-00-00/manifest.json", packages[1].Manifest)
+}
+
+type listOnlyStorage struct {
+	Storage
+	items []FileItem
+}
+
+func (s *listOnlyStorage) list(parent string) ([]FileItem, error) {
+	return s.items, nil
+}
+
+func TestCycler_runRemote(t *testing.T) {
+	setTempHome(t)
+
+	storagePath := t.TempDir()
+	for _, f := range []string{
+		"demo-2023-01-01-00-00-00.tar.gz",
+		"demo-2023-01-01-00-00-00.tar.gz.manifest.json",
+		"demo-2023-01-02-00-00-00/demo-2023-01-02-00-00-00.tar.gz-000",
+		"demo-2023-01-02-00-00-00/manifest.json",
+		"demo-2023-01-03-00-00-00.tar.gz",
+		"foreign.txt",
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(storagePath, filepath.Dir(f)), 0750))
+		assert.NoError(t, os.WriteFile(filepath.Join(storagePath, f), []byte("foo"), 0640))
+	}
+
+	v := viper.New()
+	v.Set("path", storagePath)
+	v.Set("keep", 1)
+	v.Set("keep_mode", "remote")
+	base, s, err := new(config.ModelConfig{Name: "demo"}, "", config.SubConfig{Name: "remote", Type: "local", Viper: v})
+	assert.NoError(t, err)
+	assert.NoError(t, s.open())
+
+	// The local JSON is stale
+	assert.NoError(t, os.MkdirAll(cyclerPath(), 0750))
+	assert.NoError(t, os.WriteFile(base.cycler.fileName(), []byte(`[{"file_key":"demo-2020-01-01-00-00-00.tar.gz"}]`), 0640))
+
+	base.loadRemotePackages(s)
+	base.cycler.run("demo-2023-01-03-00-00-00.tar.gz", nil, "", nil, base.retention, s.delete)
+
+	entries, err := os.ReadDir(storagePath)
+	assert.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"demo-2023-01-03-00-00-00.tar.gz", "foreign.txt"}, names)
+
+	// The local JSON is a cache of remote
+	base.cycler.packages = nil
+	base.cycler.load(base.cycler.fileName())
+	assert.Equal(t, 1, len(base.cycler.packages))
+	assert.Equal(t, "demo-2023-01-03-00-00-00.tar.gz", base.cycler.packages[0].FileKey)
+}
+
+func TestKeepChains(t *testing.T) {
+	all := PackageList{
+		{FileKey: "demo-1.tar.gz"},
+		{FileKey: "demo-2.tar.gz", Bases: []string{"demo-1"}},
+		{FileKey: "demo-3.tar.gz", Bases: []string{"demo-2"}},
+		{FileKey: "demo-4/", Bases: []string{"demo-3"}},
+		{FileKey: "demo-5.tar.gz"},
+		{FileKey: "demo-6.tar.gz", Bases: []string{"demo-5"}},
+	}
+
+	keeps, removes := keepChains(all, all[:5])
+	assert.Equal(t, PackageList{all[4], all[5]}, keeps)
+	assert.Equal(t, all[:4], removes)
+
+	// The incremental chain is kept back to the full archive
+	keeps, removes = keepChains(all, PackageList{all[0], all[1], all[2], all[4]})
+	assert.Equal(t, all, keeps)
+	assert.Nil(t, removes)
+
+	// The model name contains dots
+	dotted := PackageList{
+		{FileKey: "backups/demo.v2-2023-01-01-00-00-00.tar.gz"},
+		{FileKey: "backups/demo.v2-2023-01-02-00-00-00/", Bases: []string{"demo.v2-2023-01-01-00-00-00"}},
+		{FileKey: "backups/demo.v2-2023-01-03-00-00-00.tar.gz.enc", Bases: []string{"demo.v2-2023-01-02-00-00-00"}},
+	}
+	keeps, removes = keepChains(dotted, dotted[:2])
+	assert.Equal(t, dotted, keeps)
+	assert.Nil(t, removes)
+}
+
+func TestCycler_runChain(t *testing.T) {
+	setTempHome(t)
+
+	cycler := Cycler{name: "chain"}
+
+	var removed []string
+	deletePackage := func(fileKey string) error {
+		removed = append(removed, fileKey)
+		return nil
+	}
+
+	cycler.run("demo-1.tar.gz", nil, "", nil, Retention{Last: 1}, deletePackage)
+	cycler.run("demo-2.tar.gz", nil, "", []string{"demo-1"}, Retention{Last: 1}, deletePackage)
+	cycler.run("demo-3.tar.gz", nil, "", []string{"demo-1"}, Retention{Last: 1}, deletePackage)
+	assert.Equal(t, []string{"demo-2.tar.gz"}, removed)
+
+	cycler.run("demo-4.tar.gz", nil, "", nil, Retention{Last: 1}, deletePackage)
+	assert.Equal(t, []string{"demo-2.tar.gz", "demo-1.tar.gz", "demo-3.tar.gz"}, removed)
+}
+
+func TestCycler_runBackupChain(t *testing.T) {
+	setTempHome(t)
+
+	cycler := Cycler{name: "backup-chain"}
+
+	var removed []string
+	deletePackage := func(fileKey string) error {
+		removed = append(removed, fileKey)
+		return nil
+	}
+
+	backup := func(info manifest.BackupInfo) *manifest.Manifest {
+		return &manifest.Manifest{Databases: []manifest.Database{{Name: "mysql1", Type: "mysql", Backup: &info}}}
+	}
+
+	// The incremental xtrabackup is chained off the previous package, keep 1 would drop its bases
+	cycler.run("demo-1.tar.gz", nil, "", packageBases(backup(manifest.BackupInfo{Type: "full"})), Retention{Last: 1}, deletePackage)
+	cycler.run("demo-2.tar.gz", nil, "", packageBases(backup(manifest.BackupInfo{Type: "incremental", Chain: 1, Base: "demo-1"})), Retention{Last: 1}, deletePackage)
+	cycler.run("demo-3.tar.gz", nil, "", packageBases(backup(manifest.BackupInfo{Type: "incremental", Chain: 2, Base: "demo-2"})), Retention{Last: 1}, deletePackage)
+	assert.Nil(t, removed)
+
+	cycler.run("demo-4.tar.gz", nil, "", packageBases(backup(manifest.BackupInfo{Type: "full"})), Retention{Last: 1}, deletePackage)
+	assert.Equal(t, []string{"demo-1.tar.gz", "demo-2.tar.gz", "demo-3.tar.gz"}, removed)
+}
+
+func TestPackageBases(t *testing.T) {
+	assert.Nil(t, packageBases(nil))
+	assert.Nil(t, packageBases(&manifest.Manifest{Databases: []manifest.Database{{Name: "redis1"}}}))
+
+	m := &manifest.Manifest{
+		Archive: &manifest.Archive{Level: "differential", Base: "demo-1"},
+		Databases: []manifest.Database{
+			{Name: "mysql1", Backup: &manifest.BackupInfo{Type: "incremental", Base: "demo-3"}},
+			{Name: "mysql2", Backup: &manifest.BackupInfo{Type: "incremental", Base: "demo-3"}},
+		},
+	}
+	assert.Equal(t, []string{"demo-1", "demo-3"}, packageBases(m))
+
+	// Both of the differential archive and incremental backups are kept
+	all := PackageList{
+		{FileKey: "demo-1.tar.gz"},
+		{FileKey: "demo-2.tar.gz", Bases: []string{"demo-1"}},
+		{FileKey: "demo-3.tar.gz", Bases: []string{"demo-1", "demo-2"}},
+		{FileKey: "demo-4.tar.gz", Bases: []string{"demo-1", "demo-3"}},
+	}
+	keeps, removes := keepChains(all, all[:3])
+	assert.Equal(t, all, keeps)
+	assert.Nil(t, removes)
+}
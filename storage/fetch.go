@@ -0,0 +1,455 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/manifest"
+	"github.com/itgcloud/gobackup/splitter"
+)
+
+// FindPackage find a package in the default storage of the model.
+//
+// When `fileKey` is empty, the latest package recorded by the cycler will be returned.
+// Otherwise, if the `fileKey` is not recorded, the storage will be listed to check
+// whether it is a directory of chunks.
+func FindPackage(model config.ModelConfig, fileKey string) (*Package, error) {
+	storageConfig, ok := model.Storages[model.DefaultStorage]
+	if !ok {
+		return nil, fmt.Errorf("Storage %s not found", model.DefaultStorage)
+	}
+
+	base, s, err := new(model, "", storageConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	base.cycler.load(base.cycler.fileName())
+	base.loadRemotePackages(s)
+
+	if fileKey == "" {
+		if len(base.cycler.packages) == 0 {
+			return nil, fmt.Errorf("no package found in storage %s, please special the package", model.DefaultStorage)
+		}
+
+		pkg := base.cycler.packages[len(base.cycler.packages)-1]
+		return &pkg, nil
+	}
+
+	fileKey = strings.TrimSuffix(fileKey, "/")
+	for _, pkg := range base.cycler.packages {
+		if pkg.FileKey == fileKey {
+			return &pkg, nil
+		}
+	}
+
+	pkg := &Package{FileKey: fileKey}
+
+	// Ignore error here, it means the fileKey is not a directory
+	items, _ := s.list(fileKey)
+	for _, item := range items {
+		name := path.Base(item.Filename)
+		if !item.IsDir && name != path.Base(fileKey) && strings.HasPrefix(name, path.Base(fileKey)) {
+			pkg.FileKeys = append(pkg.FileKeys, path.Join(fileKey, name))
+		}
+	}
+	sort.Strings(pkg.FileKeys)
+	pkg.Manifest = manifest.Key(fileKey, len(pkg.FileKeys) > 0)
+
+	return pkg, nil
+}
+
+// Fetch download all files of the package from the default storage into `destDir`,
+// returns the local paths of the files in order.
+func Fetch(model config.ModelConfig, pkg *Package, destDir string) ([]string, error) {
+	logger := logger.Tag("Storage")
+
+	storageConfig, ok := model.Storages[model.DefaultStorage]
+	if !ok {
+		return nil, fmt.Errorf("Storage %s not found", model.DefaultStorage)
+	}
+
+	if err := helper.MkdirP(destDir); err != nil {
+		return nil, err
+	}
+
+	_, s, err := new(model, "", storageConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	if isRepositoryIndex(pkg.FileKey) {
+		localPath, err := fetchRepository(s, pkg.FileKey, destDir)
+		if err != nil {
+			return nil, fmt.Errorf("fetch %s failed: %v", pkg.FileKey, err)
+		}
+		return []string{localPath}, nil
+	}
+
+	fileKeys := pkg.FileKeys
+	if len(fileKeys) == 0 {
+		fileKeys = []string{pkg.FileKey}
+	}
+
+	var paths []string
+	for _, key := range fileKeys {
+		localPath := filepath.Join(destDir, path.Base(key))
+		logger.Info("-> Fetching", key)
+		if err := fetchFile(s, key, localPath); err != nil {
+			return nil, fmt.Errorf("fetch %s failed: %v", key, err)
+		}
+		paths = append(paths, localPath)
+	}
+
+	return paths, nil
+}
+
+func fetchFile(s Storage, fileKey, localPath string) error {
+	reader, err := s.read(fileKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	f, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, reader); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// fetchRepository join the chunks of the index into the archive in `destDir`, returns the local path of archive
+func fetchRepository(s Storage, indexKey, destDir string) (string, error) {
+	logger := logger.Tag("Storage")
+
+	index, err := readIndex(s, indexKey)
+	if err != nil {
+		return "", err
+	}
+
+	reader, err := newChunkReader(s, index)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	localPath := filepath.Join(destDir, path.Base(index.Package))
+	logger.Infof("-> Fetching %s (%d chunks)", indexKey, len(index.Chunks))
+	f, err := os.Create(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, reader); err != nil {
+		return "", err
+	}
+
+	return localPath, f.Close()
+}
+
+// ReadManifest read the manifest.json of the package from the default storage
+func ReadManifest(model config.ModelConfig, pkg *Package) (*manifest.Manifest, error) {
+	if len(pkg.Manifest) == 0 {
+		return nil, fmt.Errorf("no manifest recorded for package %s", pkg.FileKey)
+	}
+
+	s, err := openDefault(model)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	reader, err := s.read(pkg.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("read %s failed: %v", pkg.Manifest, err)
+	}
+	defer reader.Close()
+
+	m, err := manifest.Read(reader)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s failed: %v", pkg.Manifest, err)
+	}
+
+	return m, nil
+}
+
+// PackageReader read all files of the package in order as one stream from the default storage,
+// the size and SHA-256 of each file are calculated while reading.
+type PackageReader struct {
+	s        Storage
+	fileKeys []string
+	// open the file of package, the chunks of index are joined for the repository
+	open    func(fileKey string) (io.ReadCloser, error)
+	current io.ReadCloser
+	hash    *manifest.HashReader
+	files   []manifest.File
+	// checksums of chunks from the checksum file of splitter, the chunks are verified while reading
+	checksums splitter.Checksums
+}
+
+// OpenPackage open the package in the default storage for reading, the files will be opened on demand
+func OpenPackage(model config.ModelConfig, pkg *Package) (*PackageReader, error) {
+	s, err := openDefault(model)
+	if err != nil {
+		return nil, err
+	}
+
+	if isRepositoryIndex(pkg.FileKey) {
+		index, err := readIndex(s, pkg.FileKey)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+
+		open := func(string) (io.ReadCloser, error) {
+			return newChunkReader(s, index)
+		}
+		return &PackageReader{s: s, fileKeys: []string{index.Package}, open: open}, nil
+	}
+
+	fileKeys := pkg.FileKeys
+	if len(fileKeys) == 0 {
+		fileKeys = []string{pkg.FileKey}
+	}
+
+	r := &PackageReader{s: s, fileKeys: fileKeys, open: s.read}
+	if err := r.readChecksums(); err != nil {
+		s.close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// readChecksums read the checksum file of chunks before the chunks
+func (r *PackageReader) readChecksums() error {
+	for i, fileKey := range r.fileKeys {
+		if !splitter.IsChecksumFile(fileKey) {
+			continue
+		}
+
+		reader, err := r.s.read(fileKey)
+		if err != nil {
+			return fmt.Errorf("read %s failed: %v", fileKey, err)
+		}
+		defer reader.Close()
+
+		hash := manifest.NewHashReader(reader)
+		if r.checksums, err = splitter.ParseChecksums(hash); err != nil {
+			return fmt.Errorf("parse %s failed: %v", fileKey, err)
+		}
+		r.files = append(r.files, hash.File(fileKey))
+		r.fileKeys = append(r.fileKeys[:i:i], r.fileKeys[i+1:]...)
+		if len(r.checksums) != len(r.fileKeys) {
+			return fmt.Errorf("%d chunks found, but %d in %s", len(r.fileKeys), len(r.checksums), fileKey)
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+func (r *PackageReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.fileKeys) == 0 {
+				return 0, io.EOF
+			}
+
+			reader, err := r.open(r.fileKeys[0])
+			if err != nil {
+				return 0, fmt.Errorf("read %s failed: %v", r.fileKeys[0], err)
+			}
+			r.current = reader
+			r.hash = manifest.NewHashReader(reader)
+		}
+
+		n, err := r.hash.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			file := r.hash.File(r.fileKeys[0])
+			r.files = append(r.files, file)
+			r.fileKeys = r.fileKeys[1:]
+			if r.checksums != nil {
+				if err := r.checksums.Verify(path.Base(file.Name), file.SHA256); err != nil {
+					return n, err
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			err = nil
+		} else if err != nil {
+			err = fmt.Errorf("read %s failed: %v", r.fileKeys[0], err)
+		}
+
+		return n, err
+	}
+}
+
+// Files returns the files have been read completely
+func (r *PackageReader) Files() []manifest.File {
+	return r.files
+}
+
+func (r *PackageReader) Close() error {
+	if r.current != nil {
+		r.current.Close()
+	}
+	r.s.close()
+	return nil
+}
+
+// ErrNoDownloadURL is returned by Download when the storage can not provide a download URL,
+// the file should be opened by OpenDownload and proxied instead.
+var ErrNoDownloadURL = errors.New("download URL is not supported by the storage")
+
+// DownloadFile is a file in the default storage opened for downloading.
+//
+// It implements io.ReadSeeker for serving Range requests, seeking only records the offset,
+// the file will be reopened from the offset on the next read.
+type DownloadFile struct {
+	s       Storage
+	info    FileItem
+	offset  int64
+	reader  io.ReadCloser
+	readPos int64
+}
+
+// OpenDownload open the file in the default storage for downloading,
+// the fileKey is the same as Download, the storage will be closed with the file.
+func OpenDownload(model config.ModelConfig, fileKey string) (*DownloadFile, error) {
+	for _, segment := range strings.Split(fileKey, "/") {
+		if segment == ".." {
+			return nil, fmt.Errorf("invalid file key: %s", fileKey)
+		}
+	}
+
+	s, err := openDefault(model)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.stat(fileKey)
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	if info.IsDir {
+		s.close()
+		return nil, fmt.Errorf("%s is a directory", fileKey)
+	}
+
+	return &DownloadFile{s: s, info: info}, nil
+}
+
+// Name returns the base name of the file
+func (f *DownloadFile) Name() string {
+	return path.Base(f.info.Filename)
+}
+
+func (f *DownloadFile) Size() int64 {
+	return f.info.Size
+}
+
+func (f *DownloadFile) ModTime() time.Time {
+	return f.info.LastModified
+}
+
+func (f *DownloadFile) Read(p []byte) (int, error) {
+	if f.reader != nil && f.readPos != f.offset {
+		f.reader.Close()
+		f.reader = nil
+	}
+
+	if f.reader == nil {
+		if f.offset >= f.info.Size {
+			return 0, io.EOF
+		}
+
+		reader, err := f.s.readRange(f.info.Filename, f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.reader = reader
+		f.readPos = f.offset
+	}
+
+	n, err := f.reader.Read(p)
+	f.offset += int64(n)
+	f.readPos += int64(n)
+	return n, err
+}
+
+func (f *DownloadFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position: %d", offset)
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *DownloadFile) Close() error {
+	defer f.s.close()
+
+	if f.reader != nil {
+		return f.reader.Close()
+	}
+	return nil
+}
+
+// openDefault open the default storage of the model
+func openDefault(model config.ModelConfig) (Storage, error) {
+	storageConfig, ok := model.Storages[model.DefaultStorage]
+	if !ok {
+		return nil, fmt.Errorf("Storage %s not found", model.DefaultStorage)
+	}
+
+	_, s, err := new(model, "", storageConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/manifest"
+)
+
+func TestFindPackageAndFetch(t *testing.T) {
+	setTempHome(t)
+
+	storagePath := t.TempDir()
+	pkgDir := filepath.Join(storagePath, "demo-2023-01-01-00-00-00")
+	assert.NoError(t, os.MkdirAll(pkgDir, 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(pkgDir, "demo-2023-01-01-00-00-00.tar.gz-001"), []byte("bar"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(pkgDir, "demo-2023-01-01-00-00-00.tar.gz-000"), []byte("foo"), 0640))
+
+	v := viper.New()
+	v.Set("path", storagePath)
+	model := config.ModelConfig{
+		Name:           "test_find_package",
+		DefaultStorage: "local",
+		Storages: map[string]config.SubConfig{
+			"local": {Name: "local", Type: "local", Viper: v},
+		},
+	}
+
+	pkg, err := FindPackage(model, "demo-2023-01-01-00-00-00/")
+	assert.NoError(t, err)
+	assert.Equal(t, "demo-2023-01-01-00-00-00", pkg.FileKey)
+	assert.Equal(t, []string{
+		"demo-2023-01-01-00-00-00/demo-2023-01-01-00-00-00.tar.gz-000",
+		"demo-2023-01-01-00-00-00/demo-2023-01-01-00-00-00.tar.gz-001",
+	}, pkg.FileKeys)
+
+	destDir := t.TempDir()
+	paths, err := Fetch(model, pkg, destDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(paths))
+
+	data, err := os.ReadFile(paths[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", string(data))
+}
+
+func TestOpenPackageAndReadManifest(t *testing.T) {
+	setTempHome(t)
+
+	storagePath := t.TempDir()
+	pkgDir := filepath.Join(storagePath, "demo")
+	assert.NoError(t, os.MkdirAll(pkgDir, 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(pkgDir, "demo.tar-000"), []byte("hello "), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(pkgDir, "demo.tar-001"), []byte("world"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(pkgDir, "manifest.json"), []byte(`{"model":"demo","package":"demo"}`), 0640))
+
+	v := viper.New()
+	v.Set("path", storagePath)
+	model := config.ModelConfig{
+		Name:           "test_open_package",
+		DefaultStorage: "local",
+		Storages: map[string]config.SubConfig{
+			"local": {Name: "local", Type: "local", Viper: v},
+		},
+	}
+
+	pkg, err := FindPackage(model, "demo")
+	assert.NoError(t, err)
+	assert.Equal(t, "demo/manifest.json", pkg.Manifest)
+
+	m, err := ReadManifest(model, pkg)
+	assert.NoError(t, err)
+	assert.Equal(t, "demo", m.Package)
+
+	_, err = ReadManifest(model, &Package{FileKey: "demo"})
+	assert.EqualError(t, err, "no manifest recorded for package demo")
+
+	reader, err := OpenPackage(model, pkg)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	assert.Equal(t, 2, len(reader.Files()))
+	assert.Equal(t, "demo/demo.tar-000", reader.Files()[0].Name)
+	assert.Equal(t, manifest.File{
+		Name:   "demo/demo.tar-001",
+		Size:   5,
+		SHA256: "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7",
+	}, reader.Files()[1])
+}
+
+func TestOpenPackage_checksums(t *testing.T) {
+	setTempHome(t)
+
+	storagePath := t.TempDir()
+	pkgDir := filepath.Join(storagePath, "demo")
+	assert.NoError(t, os.MkdirAll(pkgDir, 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(pkgDir, "demo.tar-000"), []byte("hello "), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(pkgDir, "demo.tar-001"), []byte("world"), 0640))
+	checksums := "5e3235a8346e5a4585f8c58562f5052b8fe26a3bb122e1e96c76784964dfc461  demo.tar-000\n" +
+		"486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7  demo.tar-001\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(pkgDir, "demo.tar.sha256"), []byte(checksums), 0640))
+
+	v := viper.New()
+	v.Set("path", storagePath)
+	model := config.ModelConfig{
+		Name:           "test_open_package_checksums",
+		DefaultStorage: "local",
+		Storages: map[string]config.SubConfig{
+			"local": {Name: "local", Type: "local", Viper: v},
+		},
+	}
+
+	pkg, err := FindPackage(model, "demo")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(pkg.FileKeys))
+
+	reader, err := OpenPackage(model, pkg)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	assert.Equal(t, 3, len(reader.Files()))
+	assert.Equal(t, "demo/demo.tar.sha256", reader.Files()[0].Name)
+
+	// corrupted chunk
+	assert.NoError(t, os.WriteFile(filepath.Join(pkgDir, "demo.tar-001"), []byte("w0rld"), 0640))
+	reader, err = OpenPackage(model, pkg)
+	assert.NoError(t, err)
+	defer reader.Close()
+	_, err = io.ReadAll(reader)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "demo.tar-001: sha256 mismatch")
+}
+
+func TestOpenDownload(t *testing.T) {
+	setTempHome(t)
+
+	storagePath := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(storagePath, "demo"), 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(storagePath, "demo", "demo.tar-000"), []byte("hello world"), 0640))
+
+	v := viper.New()
+	v.Set("path", storagePath)
+	model := config.ModelConfig{
+		Name:           "test_download",
+		DefaultStorage: "local",
+		Storages: map[string]config.SubConfig{
+			"local": {Name: "local", Type: "local", Viper: v},
+		},
+	}
+
+	f, err := OpenDownload(model, "demo/demo.tar-000")
+	assert.NoError(t, err)
+	assert.Equal(t, "demo.tar-000", f.Name())
+	assert.Equal(t, int64(11), f.Size())
+	assert.False(t, f.ModTime().IsZero())
+
+	data, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	// Seek will reopen the file from the offset
+	pos, err := f.Seek(-5, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), pos)
+	data, err = io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	_, err = f.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(f, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	_, err = f.Seek(1, io.SeekCurrent)
+	assert.NoError(t, err)
+	_, err = io.ReadFull(f, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(buf))
+
+	_, err = f.Seek(-1, io.SeekStart)
+	assert.EqualError(t, err, "negative position: -1")
+	assert.NoError(t, f.Close())
+
+	_, err = OpenDownload(model, "demo/not-exist")
+	assert.Error(t, err)
+
+	_, err = OpenDownload(model, "demo")
+	assert.EqualError(t, err, "demo is a directory")
+
+	_, err = OpenDownload(model, "../etc/passwd")
+	assert.EqualError(t, err, "invalid file key: ../etc/passwd")
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net/textproto"
 	"os"
 	"path"
@@ -149,7 +150,7 @@ func (s *FTP) upload(fileKey string) error {
 		}
 		defer f.Close()
 
-		progress := helper.NewProgressBar(logger, f)
+		progress := s.newProgressBar(logger, f)
 		if err := s.client.Stor(remotePath, progress.Reader); err != nil {
 			return progress.Errorf("upload failed %v", err)
 		}
@@ -160,6 +161,23 @@ func (s *FTP) upload(fileKey string) error {
 	return nil
 }
 
+func (s *FTP) uploadStream(fileKey string, reader io.Reader) error {
+	logger := logger.Tag("FTP")
+
+	remotePath := path.Join(s.path, fileKey)
+	if err := s.mkdir(path.Dir(remotePath)); err != nil {
+		return err
+	}
+
+	progress := s.newStreamProgressBar(logger, reader)
+	if err := s.client.Stor(remotePath, progress.Reader); err != nil {
+		return progress.Errorf("upload failed %v", err)
+	}
+	progress.Done(remotePath)
+
+	return nil
+}
+
 func (s *FTP) delete(fileKey string) error {
 	logger := logger.Tag("FTP")
 	remotePath := path.Join(s.path, fileKey)
@@ -184,11 +202,12 @@ func (s *FTP) list(parent string) ([]FileItem, error) {
 
 	var items []FileItem
 	for _, entry := range entries {
-		if entry.Type == ftp.EntryTypeFile {
+		if entry.Type == ftp.EntryTypeFile || entry.Type == ftp.EntryTypeFolder {
 			items = append(items, FileItem{
 				Filename:     entry.Name,
 				Size:         int64(entry.Size),
 				LastModified: entry.Time,
+				IsDir:        entry.Type == ftp.EntryTypeFolder,
 			})
 		}
 	}
@@ -196,7 +215,31 @@ func (s *FTP) list(parent string) ([]FileItem, error) {
 	return items, nil
 }
 
-// Get FTP download URL
+// FTP has no download URL, the file will be read by `read` and proxied by the web server
 func (s *FTP) download(fileKey string) (string, error) {
-	return "", fmt.Errorf("FTP download is not supported")
+	return "", ErrNoDownloadURL
+}
+
+// read file by RETR, the response must be closed before sending other commands
+func (s *FTP) read(fileKey string) (io.ReadCloser, error) {
+	return s.client.Retr(path.Join(s.path, fileKey))
+}
+
+// stat by SIZE and MDTM, the modification time is zero when the server does not support MDTM
+func (s *FTP) stat(fileKey string) (FileItem, error) {
+	remotePath := path.Join(s.path, fileKey)
+
+	size, err := s.client.FileSize(remotePath)
+	if err != nil {
+		return FileItem{}, err
+	}
+
+	lastModified, _ := s.client.GetTime(remotePath)
+
+	return FileItem{Filename: fileKey, Size: size, LastModified: lastModified}, nil
+}
+
+// readRange by REST + RETR
+func (s *FTP) readRange(fileKey string, offset int64) (io.ReadCloser, error) {
+	return s.client.RetrFrom(path.Join(s.path, fileKey), uint64(offset))
 }
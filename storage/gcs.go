@@ -14,7 +14,6 @@ import (
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 
-	"github.com/itgcloud/gobackup/helper"
 	"github.com/itgcloud/gobackup/logger"
 )
 
@@ -111,7 +110,7 @@ func (s *GCS) upload(fileKey string) (err error) {
 		}
 		defer f.Close()
 
-		progress := helper.NewProgressBar(logger, f)
+		progress := s.newProgressBar(logger, f)
 		object := s.client.Bucket(s.bucket).Object(remotePath).If(storage.Conditions{DoesNotExist: true})
 		writer := object.NewWriter(ctx)
 
@@ -127,6 +126,35 @@ func (s *GCS) upload(fileKey string) (err error) {
 	return nil
 }
 
+// uploadStream with resumable upload, each chunk is 16MiB by default
+func (s *GCS) uploadStream(fileKey string, reader io.Reader) error {
+	logger := logger.Tag("GCS")
+
+	// Cancel the context to abort the upload when failed to read
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if s.timeout.Seconds() > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	remotePath := filepath.Join(s.path, fileKey)
+	progress := s.newStreamProgressBar(logger, reader)
+	object := s.client.Bucket(s.bucket).Object(remotePath).If(storage.Conditions{DoesNotExist: true})
+	writer := object.NewWriter(ctx)
+
+	if _, err := io.Copy(writer, progress.Reader); err != nil {
+		cancel()
+		return progress.Errorf("GCS upload error: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return progress.Errorf("GCS upload Writer.Close: %v", err)
+	}
+	progress.Done(remotePath)
+
+	return nil
+}
+
 func (s *GCS) delete(fileKey string) (err error) {
 	// No need to remove empty directory
 	if !strings.HasSuffix(fileKey, "/") {
@@ -173,3 +201,31 @@ func (s *GCS) download(fileKey string) (string, error) {
 		Expires: time.Now().Add(time.Hour * 1),
 	})
 }
+
+func (s *GCS) read(fileKey string) (io.ReadCloser, error) {
+	remotePath := filepath.Join(s.path, fileKey)
+	reader, err := s.client.Bucket(s.bucket).Object(remotePath).NewReader(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("GCS failed to read file %q, %v", remotePath, err)
+	}
+
+	return reader, nil
+}
+
+func (s *GCS) stat(fileKey string) (FileItem, error) {
+	attrs, err := s.client.Bucket(s.bucket).Object(fileKey).Attrs(context.Background())
+	if err != nil {
+		return FileItem{}, fmt.Errorf("GCS failed to stat file %q, %v", fileKey, err)
+	}
+
+	return FileItem{Filename: fileKey, Size: attrs.Size, LastModified: attrs.Updated}, nil
+}
+
+func (s *GCS) readRange(fileKey string, offset int64) (io.ReadCloser, error) {
+	reader, err := s.client.Bucket(s.bucket).Object(fileKey).NewRangeReader(context.Background(), offset, -1)
+	if err != nil {
+		return nil, fmt.Errorf("GCS failed to read file %q, %v", fileKey, err)
+	}
+
+	return reader, nil
+}
@@ -1,7 +1,7 @@
 package storage
 
 import (
-	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -22,6 +22,12 @@ type Local struct {
 
 func (s *Local) open() error {
 	s.path = s.viper.GetString("path")
+
+	// Related path
+	if !path.IsAbs(s.path) {
+		s.path = path.Join(s.model.WorkDir, s.path)
+	}
+
 	return helper.MkdirP(s.path)
 }
 
@@ -30,17 +36,17 @@ func (s *Local) close() {}
 func (s *Local) upload(fileKey string) (err error) {
 	logger := logger.Tag("Local")
 
-	// Related path
-	if !path.IsAbs(s.path) {
-		s.path = path.Join(s.model.WorkDir, s.path)
-	}
-
 	targetPath := path.Join(s.path, fileKey)
 	targetDir := path.Dir(targetPath)
 	if err := helper.MkdirP(targetDir); err != nil {
 		logger.Errorf("failed to mkdir %q, %v", targetDir, err)
 	}
 
+	// Copy by Go to limit the bandwidth
+	if s.bandwidth != nil {
+		return s.copy(fileKey)
+	}
+
 	_, err = helper.Exec("cp", "-a", s.archivePath, targetPath)
 	if err != nil {
 		return err
@@ -49,6 +55,81 @@ func (s *Local) upload(fileKey string) (err error) {
 	return nil
 }
 
+// copy the package with the limited bandwidth
+func (s *Local) copy(fileKey string) error {
+	logger := logger.Tag("Local")
+
+	fileKeys := s.fileKeys
+	if len(fileKeys) == 0 {
+		fileKeys = []string{fileKey}
+	}
+
+	for _, key := range fileKeys {
+		sourcePath := filepath.Join(filepath.Dir(s.archivePath), key)
+		targetPath := path.Join(s.path, key)
+		if err := helper.MkdirP(path.Dir(targetPath)); err != nil {
+			return err
+		}
+
+		if err := s.copyFile(sourcePath, targetPath); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Store succeeded", path.Join(s.path, fileKey))
+	return nil
+}
+
+func (s *Local) copyFile(sourcePath, targetPath string) error {
+	logger := logger.Tag("Local")
+
+	src, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	progress := s.newProgressBar(logger, src)
+	if _, err := io.Copy(dst, progress.Reader); err != nil {
+		return progress.Errorf("store %s failed: %v", targetPath, err)
+	}
+	progress.Done(targetPath)
+
+	return dst.Close()
+}
+
+func (s *Local) uploadStream(fileKey string, reader io.Reader) error {
+	logger := logger.Tag("Local")
+
+	targetPath := path.Join(s.path, fileKey)
+	if err := helper.MkdirP(path.Dir(targetPath)); err != nil {
+		return err
+	}
+
+	f, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	progress := s.newStreamProgressBar(logger, reader)
+	if _, err := io.Copy(f, progress.Reader); err != nil {
+		// The incomplete file (e.g. a dump failed in the stream) is not a package
+		f.Close()
+		os.Remove(targetPath)
+		return progress.Errorf("store %s failed: %v", targetPath, err)
+	}
+	progress.Done(targetPath)
+
+	return f.Close()
+}
+
 func (s *Local) delete(fileKey string) (err error) {
 	logger := logger.Tag("Storage")
 	targetPath := filepath.Join(s.path, fileKey)
@@ -68,18 +149,45 @@ func (s *Local) list(parent string) ([]FileItem, error) {
 	}
 
 	for _, file := range files {
-		if !file.IsDir() {
-			items = append(items, FileItem{
-				Filename:     file.Name(),
-				Size:         file.Size(),
-				LastModified: file.ModTime(),
-			})
-		}
+		items = append(items, FileItem{
+			Filename:     file.Name(),
+			Size:         file.Size(),
+			LastModified: file.ModTime(),
+			IsDir:        file.IsDir(),
+		})
 	}
 
 	return items, nil
 }
 
+// Local has no download URL, the file will be read by `readRange` and proxied by the web server
 func (s *Local) download(fileKey string) (string, error) {
-	return "", fmt.Errorf("Local is not support download")
+	return "", ErrNoDownloadURL
+}
+
+func (s *Local) read(fileKey string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.path, fileKey))
+}
+
+func (s *Local) stat(fileKey string) (FileItem, error) {
+	info, err := os.Stat(filepath.Join(s.path, fileKey))
+	if err != nil {
+		return FileItem{}, err
+	}
+
+	return FileItem{Filename: fileKey, Size: info.Size(), LastModified: info.ModTime(), IsDir: info.IsDir()}, nil
+}
+
+func (s *Local) readRange(fileKey string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.path, fileKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
 }
@@ -0,0 +1,471 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/logger"
+)
+
+// Repository is the storage layout for `compress_with.type: repository`, the tar archive is split into
+// content-defined chunks, and each unique chunk is stored once:
+//
+//	chunks/<sha256>                      the chunk compressed by zstd, named by the SHA-256 of the data
+//	<model>-2006-01-02-15-04-05.tar.index.json    the index of chunks of the package
+//
+// The chunks are shared by all packages in the same path, the unreferenced chunks are removed
+// after the packages are removed by the retention.
+//
+// The backups and the removing of chunks (gc) take a lock object in `locks/` while they are running,
+// the gc is skipped if there are other locks, and the backups wait for the running gc. Both of them write
+// the lock before listing the locks, so at least one of them will see the other.
+const (
+	repositoryChunksDir = "chunks"
+	repositoryIndexExt  = ".index.json"
+	repositoryLocksDir  = "locks"
+	repositoryLockExt   = ".lock"
+	// The chunks are not removed in the grace period, they may be uploaded by a running backup
+	repositoryGCGrace = time.Hour
+	// The locks of backups older than it are left by the killed processes, they are ignored
+	repositoryLockTTL = 24 * time.Hour
+	// The locks of gc older than repositoryGCGrace are ignored
+	gcLockPrefix = "gc-"
+)
+
+// repositoryLockPoll is the interval to check the running gc
+var repositoryLockPoll = 5 * time.Second
+
+// repositoryIndex of a package in the repository
+type repositoryIndex struct {
+	// Package is the name of archive
+	Package string            `json:"package"`
+	Size    int64             `json:"size"`
+	Chunks  []repositoryChunk `json:"chunks"`
+}
+
+type repositoryChunk struct {
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+func isRepository(model config.ModelConfig) bool {
+	return model.CompressWith.Type == "repository"
+}
+
+func isRepositoryIndex(fileKey string) bool {
+	return strings.HasSuffix(fileKey, repositoryIndexExt)
+}
+
+func chunkKey(id string) string {
+	return path.Join(repositoryChunksDir, id)
+}
+
+// storeRepository split the archive into chunks and upload the new chunks into the repository,
+// then upload the index. Returns the file key of index.
+func storeRepository(s Storage, archiveName string, reader io.Reader) (string, error) {
+	logger := logger.Tag("Repository")
+
+	others, err := lockRepository(s, archiveName)
+	if err != nil {
+		return "", err
+	}
+	defer unlockRepository(s, archiveName)
+
+	// The chunks may be removed by the running gc after they are listed
+	for {
+		gc := gcLocks(others)
+		if len(gc) == 0 {
+			break
+		}
+		logger.Infof("Waiting for removing the unreferenced chunks by %s...", strings.Join(gc, ", "))
+		sleep(repositoryLockPoll)
+		if others, err = listLocks(s, archiveName); err != nil {
+			return "", err
+		}
+	}
+
+	existing, err := listChunks(s)
+	if err != nil {
+		return "", err
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return "", err
+	}
+	defer encoder.Close()
+
+	index := repositoryIndex{Package: archiveName}
+	chunker := newChunker(reader)
+	uploaded := 0
+	var uploadedSize int64
+	for {
+		data, err := chunker.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		sum := sha256.Sum256(data)
+		id := hex.EncodeToString(sum[:])
+		index.Chunks = append(index.Chunks, repositoryChunk{ID: id, Size: int64(len(data))})
+		index.Size += int64(len(data))
+
+		if _, ok := existing[id]; ok {
+			continue
+		}
+
+		compressed := encoder.EncodeAll(data, nil)
+		if err := s.uploadStream(chunkKey(id), bytes.NewReader(compressed)); err != nil {
+			return "", fmt.Errorf("upload chunk %s failed: %v", id, err)
+		}
+		existing[id] = time.Now()
+		uploaded++
+		uploadedSize += int64(len(compressed))
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+
+	indexKey := archiveName + repositoryIndexExt
+	if err := s.uploadStream(indexKey, bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("upload %s failed: %v", indexKey, err)
+	}
+	logger.Infof("-> %s: %d chunks, %d new (%d bytes)", indexKey, len(index.Chunks), uploaded, uploadedSize)
+
+	return indexKey, nil
+}
+
+// listChunks returns the chunks in the repository with the last modified time
+func listChunks(s Storage) (map[string]time.Time, error) {
+	chunks := map[string]time.Time{}
+
+	items, err := s.list(repositoryChunksDir)
+	if err != nil {
+		// The chunks directory is not exist in the first backup
+		if exists, existsErr := hasChunksDir(s); existsErr != nil || exists {
+			return nil, fmt.Errorf("list chunks failed: %v", err)
+		}
+		return chunks, nil
+	}
+
+	for _, item := range items {
+		id := path.Base(item.Filename)
+		if item.IsDir || !isChunkID(id) {
+			continue
+		}
+		chunks[id] = item.LastModified
+	}
+
+	return chunks, nil
+}
+
+// hasChunksDir returns true if the chunks directory is in the root of storage,
+// the object storages returns the keys of chunks instead of the directory
+func hasChunksDir(s Storage) (bool, error) {
+	items, err := s.list("")
+	if err != nil {
+		return false, err
+	}
+
+	for _, item := range items {
+		name := strings.TrimSuffix(item.Filename, "/")
+		if path.Base(name) == repositoryChunksDir || strings.Contains("/"+name, "/"+repositoryChunksDir+"/") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func lockKey(name string) string {
+	return path.Join(repositoryLocksDir, name+repositoryLockExt)
+}
+
+// lockRepository upload the lock object with name, and returns the names of other locks
+func lockRepository(s Storage, name string) ([]string, error) {
+	if err := s.uploadStream(lockKey(name), strings.NewReader(time.Now().Format(time.RFC3339))); err != nil {
+		return nil, fmt.Errorf("lock repository failed: %v", err)
+	}
+
+	return listLocks(s, name)
+}
+
+func unlockRepository(s Storage, name string) {
+	if err := s.delete(lockKey(name)); err != nil {
+		logger.Tag("Repository").Warnf("Remove lock %s failed: %v", lockKey(name), err)
+	}
+}
+
+// listLocks returns the names of locks except the name, the stale locks are ignored
+func listLocks(s Storage, except string) (names []string, err error) {
+	items, err := s.list(repositoryLocksDir)
+	if err != nil {
+		return nil, fmt.Errorf("list locks failed: %v", err)
+	}
+
+	for _, item := range items {
+		name := path.Base(item.Filename)
+		if item.IsDir || !strings.HasSuffix(name, repositoryLockExt) {
+			continue
+		}
+		name = strings.TrimSuffix(name, repositoryLockExt)
+
+		ttl := repositoryLockTTL
+		if strings.HasPrefix(name, gcLockPrefix) {
+			ttl = repositoryGCGrace
+		}
+		if name == except || time.Since(item.LastModified) > ttl {
+			continue
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func gcLocks(names []string) (gc []string) {
+	for _, name := range names {
+		if strings.HasPrefix(name, gcLockPrefix) {
+			gc = append(gc, name)
+		}
+	}
+
+	return
+}
+
+func isChunkID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// readIndex read the index of package from the storage
+func readIndex(s Storage, indexKey string) (*repositoryIndex, error) {
+	reader, err := s.read(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("read %s failed: %v", indexKey, err)
+	}
+	defer reader.Close()
+
+	index := &repositoryIndex{}
+	if err := json.NewDecoder(reader).Decode(index); err != nil {
+		return nil, fmt.Errorf("parse %s failed: %v", indexKey, err)
+	}
+
+	return index, nil
+}
+
+// gcRepository remove the chunks are not referenced by any index in the repository.
+// The indexes of all models in the path are read, nothing will be removed if any of them failed.
+func gcRepository(s Storage, storagePath string) error {
+	logger := logger.Tag("Repository")
+
+	lockName := gcLockPrefix + strconv.FormatInt(time.Now().UnixNano(), 36)
+	others, err := lockRepository(s, lockName)
+	if err != nil {
+		return err
+	}
+	defer unlockRepository(s, lockName)
+	if len(others) > 0 {
+		logger.Infof("The repository is used by %s, skip removing the unreferenced chunks", strings.Join(others, ", "))
+		return nil
+	}
+
+	items, err := s.list("")
+	if err != nil {
+		return err
+	}
+
+	root := storageRoot(storagePath)
+	referenced := map[string]bool{}
+	indexes := 0
+	for _, item := range items {
+		key := strings.TrimPrefix(strings.TrimPrefix(item.Filename, "/"), root+"/")
+		if item.IsDir || strings.Contains(key, "/") || !isRepositoryIndex(key) {
+			continue
+		}
+
+		index, err := readIndex(s, key)
+		if err != nil {
+			return err
+		}
+		for _, chunk := range index.Chunks {
+			referenced[chunk.ID] = true
+		}
+		indexes++
+	}
+
+	chunks, err := listChunks(s)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for id, lastModified := range chunks {
+		if referenced[id] || time.Since(lastModified) < repositoryGCGrace {
+			continue
+		}
+
+		if err := s.delete(chunkKey(id)); err != nil {
+			logger.Warnf("Remove chunk %s failed: %v", id, err)
+			continue
+		}
+		removed++
+	}
+	logger.Infof("Removed %d unreferenced chunks, %d chunks referenced by %d indexes", removed, len(referenced), indexes)
+
+	return nil
+}
+
+// chunkReader read the archive from the chunks of index, the SHA-256 of each chunk is checked
+type chunkReader struct {
+	s       Storage
+	chunks  []repositoryChunk
+	decoder *zstd.Decoder
+	current *bytes.Reader
+}
+
+func newChunkReader(s Storage, index *repositoryIndex) (*chunkReader, error) {
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chunkReader{s: s, chunks: index.Chunks, decoder: decoder}, nil
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for r.current == nil || r.current.Len() == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+
+		chunk := r.chunks[0]
+		r.chunks = r.chunks[1:]
+		data, err := r.readChunk(chunk)
+		if err != nil {
+			return 0, err
+		}
+		r.current = bytes.NewReader(data)
+	}
+
+	return r.current.Read(p)
+}
+
+func (r *chunkReader) readChunk(chunk repositoryChunk) ([]byte, error) {
+	reader, err := r.s.read(chunkKey(chunk.ID))
+	if err != nil {
+		return nil, fmt.Errorf("read chunk %s failed: %v", chunk.ID, err)
+	}
+	defer reader.Close()
+
+	compressed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read chunk %s failed: %v", chunk.ID, err)
+	}
+
+	data, err := r.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompress chunk %s failed: %v", chunk.ID, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != chunk.ID || int64(len(data)) != chunk.Size {
+		return nil, fmt.Errorf("chunk %s is corrupted", chunk.ID)
+	}
+
+	return data, nil
+}
+
+func (r *chunkReader) Close() error {
+	r.decoder.Close()
+	return nil
+}
+
+// chunker split the stream into content-defined chunks by the gear hash. The boundary is found when the
+// high bits of hash are all zero, which depend only on the last 64 bytes, so the chunks after an insertion
+// or deletion are still the same.
+type chunker struct {
+	r       *bufio.Reader
+	buf     []byte
+	minSize int
+	maxSize int
+	mask    uint64
+}
+
+const (
+	chunkMinSize = 512 << 10
+	chunkMaxSize = 8 << 20
+	// 20 bits, about 1 MiB after the min size
+	chunkMask = uint64(1<<20-1) << 44
+)
+
+// gearTable is generated by splitmix64 with a fixed seed, it must never be changed,
+// otherwise the chunks will be different from the existing ones.
+var gearTable = func() (table [256]uint64) {
+	seed := uint64(0x676f6261636b7570)
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{
+		r:       bufio.NewReaderSize(r, 1<<20),
+		minSize: chunkMinSize,
+		maxSize: chunkMaxSize,
+		mask:    chunkMask,
+	}
+}
+
+// next returns the next chunk, the data is only valid until the next call. Returns io.EOF at the end.
+func (c *chunker) next() ([]byte, error) {
+	c.buf = c.buf[:0]
+
+	var hash uint64
+	for len(c.buf) < c.maxSize {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			if len(c.buf) == 0 {
+				return nil, io.EOF
+			}
+			return c.buf, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		c.buf = append(c.buf, b)
+		hash = (hash << 1) + gearTable[b]
+		if len(c.buf) >= c.minSize && hash&c.mask == 0 {
+			return c.buf, nil
+		}
+	}
+
+	return c.buf, nil
+}
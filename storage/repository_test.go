@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+)
+
+func testChunks(t *testing.T, data []byte) (chunks []string) {
+	c := newChunker(bytes.NewReader(data))
+	c.minSize, c.maxSize, c.mask = 64, 4096, uint64(1<<8-1)<<56
+
+	var joined []byte
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		assert.True(t, len(chunk) <= 4096)
+		joined = append(joined, chunk...)
+		chunks = append(chunks, string(chunk))
+	}
+	assert.Equal(t, data, joined)
+
+	return chunks
+}
+
+func TestChunker(t *testing.T) {
+	data := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := testChunks(t, data)
+	assert.True(t, len(chunks) > 10)
+
+	// Insert at the beginning, only the first chunk is changed
+	inserted := testChunks(t, append([]byte("hello"), data...))
+	common := map[string]bool{}
+	for _, chunk := range chunks {
+		common[chunk] = true
+	}
+	changed := 0
+	for _, chunk := range inserted {
+		if !common[chunk] {
+			changed++
+		}
+	}
+	assert.Equal(t, 1, changed)
+
+	// Empty
+	assert.Nil(t, testChunks(t, nil))
+}
+
+func TestRepository(t *testing.T) {
+	setTempHome(t)
+
+	storagePath := t.TempDir()
+	v := viper.New()
+	v.Set("path", storagePath)
+	model := config.ModelConfig{
+		Name:           "demo",
+		DefaultStorage: "local",
+		CompressWith:   config.SubConfig{Type: "repository"},
+		Storages: map[string]config.SubConfig{
+			"local": {Name: "local", Type: "local", Viper: v},
+		},
+	}
+	_, s, err := new(model, "", model.Storages["local"])
+	assert.NoError(t, err)
+	assert.NoError(t, s.open())
+
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	indexKey, err := storeRepository(s, "demo-1.tar", bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, "demo-1.tar.index.json", indexKey)
+	chunks, err := listChunks(s)
+	assert.NoError(t, err)
+	count := len(chunks)
+	assert.True(t, count > 1)
+
+	// The unchanged chunks are not uploaded again
+	changed := append(append([]byte{}, data[:1<<20]...), []byte("changed")...)
+	changed = append(changed, data[1<<20:]...)
+	_, err = storeRepository(s, "demo-2.tar", bytes.NewReader(changed))
+	assert.NoError(t, err)
+	chunks, err = listChunks(s)
+	assert.NoError(t, err)
+	assert.True(t, len(chunks) > count)
+	assert.True(t, len(chunks) < count*2)
+
+	pkg := &Package{FileKey: "demo-2.tar.index.json"}
+	paths, err := Fetch(model, pkg, t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, "demo-2.tar", filepath.Base(paths[0]))
+	fetched, err := os.ReadFile(paths[0])
+	assert.NoError(t, err)
+	assert.Equal(t, changed, fetched)
+
+	reader, err := OpenPackage(model, pkg)
+	assert.NoError(t, err)
+	_, err = io.Copy(io.Discard, reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "demo-2.tar", reader.Files()[0].Name)
+	assert.Equal(t, int64(len(changed)), reader.Files()[0].Size)
+	reader.Close()
+
+	// The chunks only referenced by demo-1 are removed, except in the grace period
+	assert.NoError(t, os.Remove(filepath.Join(storagePath, "demo-1.tar.index.json")))
+	assert.NoError(t, gcRepository(s, storagePath))
+	remains, err := listChunks(s)
+	assert.NoError(t, err)
+	assert.Equal(t, len(chunks), len(remains))
+
+	old := time.Now().Add(-2 * repositoryGCGrace)
+	for id := range chunks {
+		assert.NoError(t, os.Chtimes(filepath.Join(storagePath, chunkKey(id)), old, old))
+	}
+	assert.NoError(t, gcRepository(s, storagePath))
+	remains, err = listChunks(s)
+	assert.NoError(t, err)
+	assert.True(t, len(remains) < len(chunks))
+
+	paths, err = Fetch(model, pkg, t.TempDir())
+	assert.NoError(t, err)
+	fetched, err = os.ReadFile(paths[0])
+	assert.NoError(t, err)
+	assert.Equal(t, changed, fetched)
+
+	// The corrupted chunk is detected
+	for id := range remains {
+		assert.NoError(t, os.WriteFile(filepath.Join(storagePath, chunkKey(id)), []byte("bad"), 0640))
+		break
+	}
+	_, err = Fetch(model, pkg, t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestRepository_lock(t *testing.T) {
+	setTempHome(t)
+
+	storagePath := t.TempDir()
+	v := viper.New()
+	v.Set("path", storagePath)
+	_, s, err := new(config.ModelConfig{Name: "demo"}, "", config.SubConfig{Name: "local", Type: "local", Viper: v})
+	assert.NoError(t, err)
+	assert.NoError(t, s.open())
+
+	data := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	_, err = storeRepository(s, "demo-1.tar", bytes.NewReader(data))
+	assert.NoError(t, err)
+	chunks, err := listChunks(s)
+	assert.NoError(t, err)
+	old := time.Now().Add(-2 * repositoryGCGrace)
+	for id := range chunks {
+		assert.NoError(t, os.Chtimes(filepath.Join(storagePath, chunkKey(id)), old, old))
+	}
+	assert.NoError(t, os.Remove(filepath.Join(storagePath, "demo-1.tar.index.json")))
+
+	// The gc is skipped while a backup is running
+	others, err := lockRepository(s, "demo-2.tar")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(others))
+	assert.NoError(t, gcRepository(s, storagePath))
+	remains, err := listChunks(s)
+	assert.NoError(t, err)
+	assert.Equal(t, len(chunks), len(remains))
+
+	// The stale lock is ignored
+	assert.NoError(t, os.Chtimes(filepath.Join(storagePath, lockKey("demo-2.tar")), old.Add(-repositoryLockTTL), old.Add(-repositoryLockTTL)))
+	assert.NoError(t, gcRepository(s, storagePath))
+	remains, err = listChunks(s)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(remains))
+	unlockRepository(s, "demo-2.tar")
+
+	// The backup waits for the running gc
+	_, err = lockRepository(s, "gc-test")
+	assert.NoError(t, err)
+	waits := 0
+	sleep = func(d time.Duration) {
+		waits++
+		unlockRepository(s, "gc-test")
+	}
+	defer func() { sleep = time.Sleep }()
+	_, err = storeRepository(s, "demo-3.tar", bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, waits)
+
+	entries, err := os.ReadDir(filepath.Join(storagePath, repositoryLocksDir))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+// failingStorage fails to list the directory, and fails the first upload of each key
+type failingStorage struct {
+	Storage
+	listErr  map[string]error
+	uploaded map[string]int
+}
+
+func (s *failingStorage) list(parent string) ([]FileItem, error) {
+	if err := s.listErr[parent]; err != nil {
+		return nil, err
+	}
+	return s.Storage.list(parent)
+}
+
+func (s *failingStorage) uploadStream(fileKey string, reader io.Reader) error {
+	s.uploaded[fileKey]++
+	if s.uploaded[fileKey] == 1 {
+		_, _ = io.Copy(io.Discard, io.LimitReader(reader, 10))
+		return fmt.Errorf("connection reset")
+	}
+	return s.Storage.uploadStream(fileKey, reader)
+}
+
+func TestRepository_errors(t *testing.T) {
+	setTempHome(t)
+
+	sleep = func(d time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	storagePath := t.TempDir()
+	v := viper.New()
+	v.Set("path", storagePath)
+	_, local, err := new(config.ModelConfig{Name: "demo"}, "", config.SubConfig{Name: "local", Type: "local", Viper: v})
+	assert.NoError(t, err)
+	assert.NoError(t, local.open())
+
+	// The chunks directory is not exist
+	chunks, err := listChunks(local)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(chunks))
+
+	// The uploads of chunks are retried
+	failing := &failingStorage{Storage: local, listErr: map[string]error{}, uploaded: map[string]int{}}
+	s := &retryStorage{Storage: failing, policy: RetryPolicy{Retries: 1}, label: "local"}
+	data := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	_, err = storeRepository(s, "demo-1.tar", bytes.NewReader(data))
+	assert.NoError(t, err)
+	chunks, err = listChunks(local)
+	assert.NoError(t, err)
+	assert.True(t, len(chunks) > 1)
+	for id := range chunks {
+		assert.Equal(t, 2, failing.uploaded[chunkKey(id)])
+	}
+	index, err := readIndex(local, "demo-1.tar.index.json")
+	assert.NoError(t, err)
+	reader, err := newChunkReader(local, index)
+	assert.NoError(t, err)
+	defer reader.Close()
+	fetched, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, data, fetched)
+
+	// The error of listing the existing chunks is returned
+	failing.listErr[repositoryChunksDir] = fmt.Errorf("connection reset")
+	_, err = listChunks(failing)
+	assert.EqualError(t, err, "list chunks failed: connection reset")
+	_, err = storeRepository(s, "demo-2.tar", bytes.NewReader(data))
+	assert.EqualError(t, err, "list chunks failed: connection reset")
+}
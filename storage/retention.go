@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Retention is the policy to decide which packages to keep, a package is kept when it matches any rule.
+//
+// - keep: the last N packages
+// - keep_daily, keep_weekly, keep_monthly, keep_yearly: the last package of each day/week/month/year for the last N days/weeks/months/years which have packages
+// - keep_within: all packages created within the duration (e.g. 72h, 30d) before the latest package
+// - keep_dry_run: only log the packages would be removed
+type Retention struct {
+	Last    int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+	Within  time.Duration
+	DryRun  bool
+}
+
+func newRetention(v *viper.Viper) (r Retention, err error) {
+	r = Retention{
+		Last:    v.GetInt("keep"),
+		Daily:   v.GetInt("keep_daily"),
+		Weekly:  v.GetInt("keep_weekly"),
+		Monthly: v.GetInt("keep_monthly"),
+		Yearly:  v.GetInt("keep_yearly"),
+		DryRun:  v.GetBool("keep_dry_run"),
+	}
+
+	if within := v.GetString("keep_within"); len(within) > 0 {
+		if r.Within, err = parseDuration(within); err != nil {
+			return r, fmt.Errorf("invalid keep_within %q: %v", within, err)
+		}
+	}
+
+	return r, nil
+}
+
+// parseDuration is time.ParseDuration with the `d` unit for days, e.g. 30d
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+// enabled returns false when no rule is set, all packages will be kept
+func (r Retention) enabled() bool {
+	return r.Last > 0 || r.Daily > 0 || r.Weekly > 0 || r.Monthly > 0 || r.Yearly > 0 || r.Within > 0
+}
+
+// timeBased returns true when any rule other than `keep` is set
+func (r Retention) timeBased() bool {
+	return r.Daily > 0 || r.Weekly > 0 || r.Monthly > 0 || r.Yearly > 0 || r.Within > 0
+}
+
+// prune splits the packages into keeps and removes, with the reasons why the package is kept.
+// The order of packages is retained.
+func (r Retention) prune(packages PackageList) (keeps, removes PackageList, reasons map[string][]string) {
+	reasons = map[string][]string{}
+	if !r.enabled() || len(packages) == 0 {
+		return packages, nil, reasons
+	}
+
+	// Newest first
+	sorted := append(PackageList{}, packages...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	keep := func(pkg Package, reason string) {
+		reasons[pkg.FileKey] = append(reasons[pkg.FileKey], reason)
+	}
+
+	for i, pkg := range sorted {
+		if i < r.Last {
+			keep(pkg, "last")
+		}
+	}
+
+	if r.Within > 0 {
+		latest := sorted[0].CreatedAt
+		for _, pkg := range sorted {
+			if latest.Sub(pkg.CreatedAt) <= r.Within {
+				keep(pkg, "within")
+			}
+		}
+	}
+
+	buckets := []struct {
+		name   string
+		count  int
+		bucket func(t time.Time) string
+	}{
+		{"daily", r.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", r.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{"monthly", r.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", r.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, b := range buckets {
+		last := ""
+		count := 0
+		for _, pkg := range sorted {
+			if count >= b.count {
+				break
+			}
+			// The newest package of the bucket
+			if key := b.bucket(pkg.CreatedAt.Local()); key != last {
+				last = key
+				count++
+				keep(pkg, b.name)
+			}
+		}
+	}
+
+	for _, pkg := range packages {
+		if len(reasons[pkg.FileKey]) > 0 {
+			keeps = append(keeps, pkg)
+		} else {
+			removes = append(removes, pkg)
+		}
+	}
+
+	return keeps, removes, reasons
+}
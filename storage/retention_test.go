@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+)
+
+// dailyPackages returns a package at 00:00 and 12:00 of each day, from oldest to newest
+func dailyPackages(start time.Time, days int) (packages PackageList) {
+	for i := 0; i < days; i++ {
+		for _, hour := range []int{0, 12} {
+			t := start.AddDate(0, 0, i).Add(time.Duration(hour) * time.Hour)
+			packages = append(packages, Package{FileKey: t.Format("2006-01-02-15"), CreatedAt: t})
+		}
+	}
+	return
+}
+
+func fileKeys(packages PackageList) (keys []string) {
+	for _, pkg := range packages {
+		keys = append(keys, pkg.FileKey)
+	}
+	return
+}
+
+func TestNewRetention(t *testing.T) {
+	v := viper.New()
+	v.Set("keep", 3)
+	v.Set("keep_daily", 7)
+	v.Set("keep_weekly", 4)
+	v.Set("keep_monthly", 12)
+	v.Set("keep_yearly", 2)
+	v.Set("keep_within", "72h")
+	v.Set("keep_dry_run", true)
+
+	r, err := newRetention(v)
+	assert.NoError(t, err)
+	assert.Equal(t, Retention{Last: 3, Daily: 7, Weekly: 4, Monthly: 12, Yearly: 2, Within: 72 * time.Hour, DryRun: true}, r)
+	assert.True(t, r.enabled())
+	assert.True(t, r.timeBased())
+
+	v.Set("keep_within", "30d")
+	r, err = newRetention(v)
+	assert.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, r.Within)
+
+	v.Set("keep_within", "foo")
+	_, err = newRetention(v)
+	assert.EqualError(t, err, `invalid keep_within "foo": time: invalid duration "foo"`)
+
+	r, err = newRetention(viper.New())
+	assert.NoError(t, err)
+	assert.False(t, r.enabled())
+	assert.False(t, r.timeBased())
+
+	v = viper.New()
+	v.Set("keep", 3)
+	r, err = newRetention(v)
+	assert.NoError(t, err)
+	assert.True(t, r.enabled())
+	assert.False(t, r.timeBased())
+}
+
+func TestRetention_prune(t *testing.T) {
+	// 2023-01-01 is Sunday
+	packages := dailyPackages(time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local), 40)
+
+	keeps, removes, _ := Retention{}.prune(packages)
+	assert.Equal(t, packages, keeps)
+	assert.Equal(t, 0, len(removes))
+
+	keeps, removes, reasons := Retention{Daily: 3}.prune(packages)
+	assert.Equal(t, []string{"2023-02-07-12", "2023-02-08-12", "2023-02-09-12"}, fileKeys(keeps))
+	assert.Equal(t, len(packages)-3, len(removes))
+	assert.Equal(t, []string{"daily"}, reasons["2023-02-09-12"])
+
+	keeps, _, _ = Retention{Weekly: 3}.prune(packages)
+	// The last of the ISO weeks, Sunday is the end of week
+	assert.Equal(t, []string{"2023-01-29-12", "2023-02-05-12", "2023-02-09-12"}, fileKeys(keeps))
+
+	keeps, _, _ = Retention{Monthly: 12}.prune(packages)
+	assert.Equal(t, []string{"2023-01-31-12", "2023-02-09-12"}, fileKeys(keeps))
+
+	keeps, _, _ = Retention{Yearly: 1}.prune(packages)
+	assert.Equal(t, []string{"2023-02-09-12"}, fileKeys(keeps))
+
+	keeps, _, _ = Retention{Within: 24 * time.Hour}.prune(packages)
+	assert.Equal(t, []string{"2023-02-08-12", "2023-02-09-00", "2023-02-09-12"}, fileKeys(keeps))
+
+	keeps, removes, reasons = Retention{Last: 2, Daily: 2, Weekly: 2, Monthly: 2}.prune(packages)
+	assert.Equal(t, []string{"2023-01-31-12", "2023-02-05-12", "2023-02-08-12", "2023-02-09-00", "2023-02-09-12"}, fileKeys(keeps))
+	assert.Equal(t, len(packages)-5, len(removes))
+	assert.Equal(t, []string{"last", "daily", "weekly", "monthly"}, reasons["2023-02-09-12"])
+	assert.Equal(t, []string{"last"}, reasons["2023-02-09-00"])
+	assert.Equal(t, []string{"daily"}, reasons["2023-02-08-12"])
+	assert.Equal(t, []string{"weekly"}, reasons["2023-02-05-12"])
+	assert.Equal(t, []string{"monthly"}, reasons["2023-01-31-12"])
+
+	// The order of list is not the order of created
+	shuffled := PackageList{packages[3], packages[0], packages[2], packages[1]}
+	keeps, _, _ = Retention{Daily: 1}.prune(shuffled)
+	assert.Equal(t, []string{"2023-01-02-12"}, fileKeys(keeps))
+}
+
+func TestCycler_runRetention(t *testing.T) {
+	setTempHome(t)
+
+	packages := dailyPackages(time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local), 10)
+
+	var deleted []string
+	deletePackage := func(fileKey string) error {
+		deleted = append(deleted, fileKey)
+		return nil
+	}
+
+	cycler := &Cycler{name: "test-retention"}
+	cycler.useRemote(append(PackageList{}, packages...))
+
+	// Dry run
+	cycler.run("new", nil, "", nil, Retention{Daily: 2, DryRun: true}, deletePackage)
+	assert.Equal(t, 0, len(deleted))
+	assert.Equal(t, len(packages)+1, len(cycler.packages))
+
+	cycler.run("new", nil, "", nil, Retention{Daily: 2}, deletePackage)
+	assert.Equal(t, []string{"2023-01-10-12", "new"}, fileKeys(cycler.packages))
+	assert.Equal(t, len(packages)-1, len(deleted))
+	assert.Equal(t, "2023-01-01-00", deleted[0])
+}
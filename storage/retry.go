@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/logger"
+)
+
+// RetryPolicy retry the failed open, upload and delete of storages with exponential backoff
+//
+// - retries: the max number of retries, 0 (default) to disable
+// - retry_backoff: the delay of the first retry, doubled for each retry, default 1s
+// - retry_max_delay: the max delay between retries, default 1m
+type RetryPolicy struct {
+	Retries  int
+	Backoff  time.Duration
+	MaxDelay time.Duration
+}
+
+func newRetryPolicy(v *viper.Viper) (p RetryPolicy, err error) {
+	p = RetryPolicy{
+		Retries:  v.GetInt("retries"),
+		Backoff:  time.Second,
+		MaxDelay: time.Minute,
+	}
+
+	if backoff := v.GetString("retry_backoff"); len(backoff) > 0 {
+		if p.Backoff, err = parseDuration(backoff); err != nil {
+			return p, fmt.Errorf("invalid retry_backoff %q: %v", backoff, err)
+		}
+	}
+	if maxDelay := v.GetString("retry_max_delay"); len(maxDelay) > 0 {
+		if p.MaxDelay, err = parseDuration(maxDelay); err != nil {
+			return p, fmt.Errorf("invalid retry_max_delay %q: %v", maxDelay, err)
+		}
+	}
+
+	return p, nil
+}
+
+// delay returns the delay before the nth retry, start from 1
+func (p RetryPolicy) delay(n int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < n && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay)
+}
+
+// do run fn until it succeeds or the retries are exhausted, beforeRetry will be called before each retry
+func (p RetryPolicy) do(label, action string, fn func() error, beforeRetry func() error) (err error) {
+	logger := logger.Tag("Storage")
+
+	for attempt := 0; ; attempt++ {
+		err = nil
+		if attempt > 0 && beforeRetry != nil {
+			if err = beforeRetry(); err != nil {
+				err = fmt.Errorf("re-open failed: %v", err)
+			}
+		}
+		if err == nil {
+			if err = fn(); err == nil {
+				return nil
+			}
+		}
+
+		if attempt >= p.Retries {
+			return err
+		}
+
+		delay := p.delay(attempt + 1)
+		logger.Warnf("[%s] %s failed (attempt %d/%d): %v, retry in %s", label, action, attempt+1, p.Retries+1, err, delay)
+		sleep(delay)
+	}
+}
+
+var sleep = time.Sleep
+
+// retryStorage wraps the open, upload and delete of Storage with RetryPolicy,
+// the connection will be re-opened before retrying upload and delete.
+//
+// The chunks of split package are retried one by one, so the uploaded chunks will not be uploaded again.
+// The storages implement fileUploader upload the chunk files in their own way (e.g.: the resumable multipart upload of S3).
+// uploadStream is only retried when the reader can be read again from the start (io.Seeker),
+// e.g.: the manifest and the spooled chunks.
+type retryStorage struct {
+	Storage
+	policy RetryPolicy
+	label  string
+	// archivePath and fileKeys of the split package, the same as Base
+	archivePath string
+	fileKeys    []string
+}
+
+func (s *retryStorage) open() error {
+	return s.policy.do(s.label, "open", s.Storage.open, nil)
+}
+
+func (s *retryStorage) reopen() error {
+	s.Storage.close()
+	return s.Storage.open()
+}
+
+func (s *retryStorage) upload(fileKey string) error {
+	if len(s.fileKeys) == 0 {
+		return s.policy.do(s.label, "upload "+fileKey, func() error {
+			return s.Storage.upload(fileKey)
+		}, s.reopen)
+	}
+
+	for _, key := range s.fileKeys {
+		if err := s.uploadChunk(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fileUploader is implemented by the storages which upload a file in their own way instead of the stream,
+// e.g.: the resumable multipart upload of S3
+type fileUploader interface {
+	// uploadFile upload the file of sourcePath to fileKey (relative to the storage path)
+	uploadFile(fileKey, sourcePath string) error
+}
+
+// uploadChunk upload a chunk of split package by uploadFile if the storage supports, otherwise by uploadStream,
+// the chunk file is opened again for each retry
+func (s *retryStorage) uploadChunk(key string) error {
+	chunkPath := filepath.Join(filepath.Dir(s.archivePath), key)
+
+	if uploader, ok := s.Storage.(fileUploader); ok {
+		return s.policy.do(s.label, "upload "+key, func() error {
+			return uploader.uploadFile(key, chunkPath)
+		}, s.reopen)
+	}
+
+	return s.policy.do(s.label, "upload "+key, func() error {
+		f, err := os.Open(chunkPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return s.Storage.uploadStream(key, f)
+	}, s.reopen)
+}
+
+func (s *retryStorage) uploadStream(fileKey string, reader io.Reader) error {
+	seeker, ok := reader.(io.Seeker)
+	if !ok {
+		return s.Storage.uploadStream(fileKey, reader)
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return s.Storage.uploadStream(fileKey, reader)
+	}
+
+	return s.policy.do(s.label, "upload "+fileKey, func() error {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		return s.Storage.uploadStream(fileKey, reader)
+	}, s.reopen)
+}
+
+// uploadSpooled write the reader into a temp file in dir, and then upload it by uploadStream,
+// so it can be retried. It is used for the chunks in `streaming` mode, which have limited size.
+func uploadSpooled(s Storage, dir, fileKey string, reader io.Reader) error {
+	if err := helper.MkdirP(dir); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, "spool-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := io.Copy(f, reader); err != nil {
+		return fmt.Errorf("spool %s failed: %v", fileKey, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return s.uploadStream(fileKey, f)
+}
+
+func (s *retryStorage) delete(fileKey string) error {
+	return s.policy.do(s.label, "delete "+fileKey, func() error {
+		return s.Storage.delete(fileKey)
+	}, s.reopen)
+}
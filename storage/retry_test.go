@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+)
+
+// flakyStorage fails the first `failures` calls of each action
+type flakyStorage struct {
+	Storage
+	failures int
+	calls    map[string]int
+	uploaded map[string]string
+}
+
+func (s *flakyStorage) call(action string) error {
+	s.calls[action]++
+	if s.calls[action] <= s.failures {
+		return fmt.Errorf("%s: connection reset", action)
+	}
+	return nil
+}
+
+func (s *flakyStorage) uploadStream(fileKey string, reader io.Reader) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if err := s.call("uploadStream " + fileKey); err != nil {
+		return err
+	}
+	if s.uploaded == nil {
+		s.uploaded = map[string]string{}
+	}
+	s.uploaded[fileKey] = string(data)
+	return nil
+}
+
+func (s *flakyStorage) open() error                 { return s.call("open") }
+func (s *flakyStorage) close()                      { s.calls["close"]++ }
+func (s *flakyStorage) upload(fileKey string) error { return s.call("upload") }
+func (s *flakyStorage) delete(fileKey string) error { return s.call("delete") }
+
+func TestRetryPolicy(t *testing.T) {
+	v := viper.New()
+	p, err := newRetryPolicy(v)
+	assert.NoError(t, err)
+	assert.Equal(t, RetryPolicy{Retries: 0, Backoff: time.Second, MaxDelay: time.Minute}, p)
+
+	v.Set("retries", 5)
+	v.Set("retry_backoff", "2s")
+	v.Set("retry_max_delay", "10s")
+	p, err = newRetryPolicy(v)
+	assert.NoError(t, err)
+	assert.Equal(t, RetryPolicy{Retries: 5, Backoff: 2 * time.Second, MaxDelay: 10 * time.Second}, p)
+	assert.Equal(t, 2*time.Second, p.delay(1))
+	assert.Equal(t, 4*time.Second, p.delay(2))
+	assert.Equal(t, 8*time.Second, p.delay(3))
+	assert.Equal(t, 10*time.Second, p.delay(4))
+	assert.Equal(t, 10*time.Second, p.delay(100))
+
+	v.Set("retry_backoff", "foo")
+	_, err = newRetryPolicy(v)
+	assert.EqualError(t, err, `invalid retry_backoff "foo": time: invalid duration "foo"`)
+}
+
+func TestRetryStorage(t *testing.T) {
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { sleep = time.Sleep }()
+
+	flaky := &flakyStorage{failures: 2, calls: map[string]int{}}
+	s := &retryStorage{Storage: flaky, policy: RetryPolicy{Retries: 3, Backoff: time.Second, MaxDelay: time.Minute}, label: "test"}
+
+	assert.NoError(t, s.open())
+	assert.Equal(t, 3, flaky.calls["open"])
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, delays)
+
+	// Re-open before each retry
+	assert.NoError(t, s.upload("foo"))
+	assert.Equal(t, 3, flaky.calls["upload"])
+	assert.Equal(t, 2, flaky.calls["close"])
+	assert.Equal(t, 5, flaky.calls["open"])
+
+	// Exhausted
+	flaky = &flakyStorage{failures: 10, calls: map[string]int{}}
+	s.Storage = flaky
+	delays = nil
+	err := s.delete("foo")
+	assert.EqualError(t, err, "re-open failed: open: connection reset")
+	assert.Equal(t, 1, flaky.calls["delete"])
+	assert.Equal(t, 3, flaky.calls["open"])
+	assert.Equal(t, 3, len(delays))
+}
+
+func TestRetryStorage_uploadStream(t *testing.T) {
+	sleep = func(d time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	flaky := &flakyStorage{failures: 2, calls: map[string]int{}}
+	s := &retryStorage{Storage: flaky, policy: RetryPolicy{Retries: 5}, label: "test"}
+
+	// Read again from the start offset
+	reader := strings.NewReader("skip:hello")
+	_, err := reader.Seek(5, io.SeekStart)
+	assert.NoError(t, err)
+	assert.NoError(t, s.uploadStream("foo", reader))
+	assert.Equal(t, 3, flaky.calls["uploadStream foo"])
+	assert.Equal(t, "hello", flaky.uploaded["foo"])
+
+	// The stream can not be read again
+	err = s.uploadStream("bar", io.MultiReader(strings.NewReader("hello")))
+	assert.EqualError(t, err, "uploadStream bar: connection reset")
+	assert.Equal(t, 1, flaky.calls["uploadStream bar"])
+
+	// Spool to retry
+	dir := t.TempDir()
+	assert.NoError(t, uploadSpooled(s, dir, "baz", io.MultiReader(strings.NewReader("world"))))
+	assert.Equal(t, 3, flaky.calls["uploadStream baz"])
+	assert.Equal(t, "world", flaky.uploaded["baz"])
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestRetryStorage_uploadChunks(t *testing.T) {
+	sleep = func(d time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	archivePath := filepath.Join(t.TempDir(), "foo")
+	assert.NoError(t, os.MkdirAll(archivePath, 0750))
+	fileKeys := []string{"foo/foo.tar-aa", "foo/foo.tar-ab", "foo/foo.tar.sha256"}
+	for _, key := range fileKeys {
+		assert.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(archivePath), key), []byte(key), 0640))
+	}
+
+	// Each chunk fails once, and it is retried alone
+	flaky := &flakyStorage{failures: 1, calls: map[string]int{}}
+	s := &retryStorage{Storage: flaky, policy: RetryPolicy{Retries: 3}, label: "test", archivePath: archivePath, fileKeys: fileKeys}
+	assert.NoError(t, s.upload("foo"))
+	for _, key := range fileKeys {
+		assert.Equal(t, 2, flaky.calls["uploadStream "+key])
+		assert.Equal(t, key, flaky.uploaded[key])
+	}
+	assert.Equal(t, 0, flaky.calls["upload"])
+	// The first re-open failed
+	assert.Equal(t, 4, flaky.calls["open"])
+}
+
+func TestNew_retry(t *testing.T) {
+	v := viper.New()
+	v.Set("path", t.TempDir())
+	_, s, err := new(config.ModelConfig{}, "", config.SubConfig{Type: "local", Viper: v})
+	assert.NoError(t, err)
+	_, ok := s.(*Local)
+	assert.True(t, ok)
+
+	v.Set("retries", 3)
+	_, s, err = new(config.ModelConfig{}, "", config.SubConfig{Type: "local", Viper: v})
+	assert.NoError(t, err)
+	rs, ok := s.(*retryStorage)
+	assert.True(t, ok)
+	assert.Equal(t, "local", rs.label)
+	_, ok = rs.Storage.(*Local)
+	assert.True(t, ok)
+}
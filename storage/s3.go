@@ -2,7 +2,7 @@ package storage
 
 import (
 	"fmt"
-	"math"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -30,6 +30,10 @@ import (
 // storage_class:
 // timeout: 300
 // force_path_style:
+// concurrency: 1
+// part_size: 64M
+// resume: true
+// resume_ttl: 24h
 type S3 struct {
 	Base
 	Service      string
@@ -38,6 +42,13 @@ type S3 struct {
 	client       *s3manager.Uploader
 	storageClass string
 	awsCfg       *aws.Config
+	concurrency  int
+	partSize     int64
+	// resume the failed multipart upload when the same content is uploaded again
+	resume    bool
+	resumeTTL time.Duration
+	// cleanedUp is true when the stale uploads have been aborted, it is done once for each backup
+	cleanedUp bool
 }
 
 func (s S3) providerName() string {
@@ -202,6 +213,10 @@ func (s *S3) init() {
 	s.viper.SetDefault("max_retries", 3)
 	s.viper.SetDefault("timeout", "300")
 	s.viper.SetDefault("storage_class", s.defaultStorageClass())
+	s.viper.SetDefault("concurrency", 1)
+	s.viper.SetDefault("part_size", "64M")
+	s.viper.SetDefault("resume", true)
+	s.viper.SetDefault("resume_ttl", "24h")
 }
 
 func (s *S3) open() (err error) {
@@ -244,6 +259,21 @@ func (s *S3) open() (err error) {
 	s.path = s.viper.GetString("path")
 	s.storageClass = s.viper.GetString("storage_class")
 
+	s.concurrency = s.viper.GetInt("concurrency")
+	if s.concurrency < 1 {
+		s.concurrency = 1
+	}
+	if s.partSize, err = helper.ParseSize(s.viper.GetString("part_size")); err != nil {
+		return fmt.Errorf("invalid part_size: %v", err)
+	}
+	if s.partSize < s3MinPartSize {
+		return fmt.Errorf("part_size must be at least 5MiB")
+	}
+	s.resume = s.viper.GetBool("resume")
+	if s.resumeTTL, err = parseDuration(s.viper.GetString("resume_ttl")); err != nil {
+		return fmt.Errorf("invalid resume_ttl: %v", err)
+	}
+
 	timeout := s.viper.GetInt("timeout")
 	uploadTimeoutDuration := time.Duration(timeout) * time.Second
 
@@ -261,8 +291,6 @@ func (s *S3) close() {
 }
 
 func (s *S3) upload(fileKey string) (err error) {
-	logger := logger.Tag(s.providerName())
-
 	var fileKeys []string
 	if len(s.fileKeys) != 0 {
 		// directory
@@ -275,58 +303,108 @@ func (s *S3) upload(fileKey string) (err error) {
 	}
 
 	for _, key := range fileKeys {
-		sourcePath := filepath.Join(filepath.Dir(s.archivePath), key)
-		remotePath := filepath.Join(s.path, key)
-
-		f, err := os.Open(sourcePath)
-		if err != nil {
-			return fmt.Errorf("failed to open file %q, %v", sourcePath, err)
+		if err := s.uploadFile(key, filepath.Join(filepath.Dir(s.archivePath), key)); err != nil {
+			return err
 		}
-		defer f.Close()
+	}
 
-		progress := helper.NewProgressBar(logger, f)
+	return nil
+}
 
-		input := &s3manager.UploadInput{
-			Bucket: aws.String(s.bucket),
-			Key:    aws.String(remotePath),
-			Body:   progress.Reader,
-		}
+// uploadFile upload the file to the key, the large file is uploaded by the resumable multipart upload when `resume`.
+// It is also used to retry the chunks of split package one by one.
+func (s *S3) uploadFile(fileKey, sourcePath string) error {
+	logger := logger.Tag(s.providerName())
 
-		// Only present storage_class when it is set.
-		// Some storage backend may not support storage_class.
-		// https://github.com/itgcloud/gobackup/issues/183
-		if len(s.storageClass) > 0 {
-			input.StorageClass = aws.String(s.storageClass)
-		}
+	if s.resume && !s.cleanedUp {
+		s.cleanupUploads()
+		s.cleanedUp = true
+	}
 
-		result, err := s.client.Upload(input, func(uploader *s3manager.Uploader) {
-			// set the part size as low as possible to avoid timeouts and aborts
-			// also set concurrency to 1 for the same reason
-			var partSize int64 = 64 * 1024 * 1024 // 64MiB
-			maxParts := progress.FileLength / partSize
+	remotePath := filepath.Join(s.path, fileKey)
 
-			// 10000 parts is the limit for AWS S3. If the resulting number of parts would exceed that limit, increase the
-			// part size as much as needed but as little possible
-			if maxParts > 10000 {
-				partSize = int64(math.Ceil(float64(progress.FileLength) / 10000))
-			}
+	f, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q, %v", sourcePath, err)
+	}
+	defer f.Close()
 
-			uploader.Concurrency = 1
-			uploader.LeavePartsOnError = false
-			uploader.PartSize = partSize
-		})
+	progress := s.newProgressBar(logger, f)
 
-		if err != nil {
-			return progress.Errorf("%v", err)
-		}
+	var location string
+	if s.resume && progress.FileLength > s.partSize {
+		location, err = s.multipartUpload(f, remotePath, progress)
+	} else {
+		location, err = s.managedUpload(remotePath, progress)
+	}
+	if err != nil {
+		return progress.Errorf("%v", err)
+	}
 
-		progress.Done(result.Location)
+	progress.Done(location)
 
-		if s.Service == "s3" {
-			logger.Info("=>", fmt.Sprintf("s3://%s/%s", s.bucket, remotePath))
-		}
+	if s.Service == "s3" {
+		logger.Info("=>", fmt.Sprintf("s3://%s/%s", s.bucket, remotePath))
+	}
+
+	return nil
+}
+
+// managedUpload upload the file by s3manager, the failed parts will be removed
+func (s *S3) managedUpload(remotePath string, progress helper.ProgressBar) (string, error) {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(remotePath),
+		Body:   progress.Reader,
+	}
+
+	// Only present storage_class when it is set.
+	// Some storage backend may not support storage_class.
+	// https://github.com/itgcloud/gobackup/issues/183
+	if len(s.storageClass) > 0 {
+		input.StorageClass = aws.String(s.storageClass)
+	}
+
+	result, err := s.client.Upload(input, func(uploader *s3manager.Uploader) {
+		uploader.Concurrency = s.concurrency
+		uploader.LeavePartsOnError = false
+		uploader.PartSize = s.partSizeFor(progress.FileLength)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return result.Location, nil
+}
+
+// uploadStream with multipart upload, the parts are buffered in memory
+func (s *S3) uploadStream(fileKey string, reader io.Reader) error {
+	logger := logger.Tag(s.providerName())
+
+	remotePath := filepath.Join(s.path, fileKey)
+	progress := s.newStreamProgressBar(logger, reader)
+
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(remotePath),
+		Body:   progress.Reader,
+	}
+	if len(s.storageClass) > 0 {
+		input.StorageClass = aws.String(s.storageClass)
 	}
 
+	result, err := s.client.Upload(input, func(uploader *s3manager.Uploader) {
+		// The length of stream is unknown, the parts are limited to 10000, e.g.: 64MiB parts allow upload up to 640GiB.
+		// Each worker buffers a part in memory.
+		uploader.PartSize = s.partSize
+		uploader.Concurrency = s.concurrency
+		uploader.LeavePartsOnError = false
+	})
+	if err != nil {
+		return progress.Errorf("%v", err)
+	}
+
+	progress.Done(result.Location)
 	return nil
 }
 
@@ -397,3 +475,51 @@ func (s *S3) download(fileKey string) (string, error) {
 
 	return url, nil
 }
+
+func (s *S3) read(fileKey string) (io.ReadCloser, error) {
+	remotePath := filepath.Join(s.path, fileKey)
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(remotePath),
+	}
+
+	result, err := s.client.S3.GetObject(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %q, %v", remotePath, err)
+	}
+
+	return result.Body, nil
+}
+
+func (s *S3) stat(fileKey string) (FileItem, error) {
+	result, err := s.client.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fileKey),
+	})
+	if err != nil {
+		return FileItem{}, fmt.Errorf("failed to head object %q, %v", fileKey, err)
+	}
+
+	return FileItem{
+		Filename:     fileKey,
+		Size:         aws.Int64Value(result.ContentLength),
+		LastModified: aws.TimeValue(result.LastModified),
+	}, nil
+}
+
+func (s *S3) readRange(fileKey string, offset int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fileKey),
+	}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	result, err := s.client.S3.GetObject(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %q, %v", fileKey, err)
+	}
+
+	return result.Body, nil
+}
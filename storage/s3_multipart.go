@@ -0,0 +1,536 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/logger"
+	"github.com/itgcloud/gobackup/manifest"
+)
+
+const (
+	s3MinPartSize = 5 * 1024 * 1024
+	s3MaxParts    = 10000
+)
+
+var (
+	// The objects larger than it are copied in parts, replaceable for testing
+	s3MaxCopySize int64 = 5 * 1024 * 1024 * 1024
+)
+
+// s3UploadsPath returns the directory of the states of the resumable multipart uploads
+func s3UploadsPath() string {
+	return filepath.Join(config.GoBackupDir, "s3_uploads")
+}
+
+// s3Upload is the state of a multipart upload, to resume it when the same content is uploaded again,
+// even by another backup (e.g. the process was killed), whose package has another file key
+type s3Upload struct {
+	Bucket    string         `json:"bucket"`
+	Key       string         `json:"key"`
+	UploadID  string         `json:"upload_id"`
+	Size      int64          `json:"size"`
+	PartSize  int64          `json:"part_size"`
+	Parts     []s3UploadPart `json:"parts"`
+	CreatedAt time.Time      `json:"created_at"`
+
+	fileName string
+}
+
+type s3UploadPart struct {
+	Number int64  `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+	// SHA256 of the part, to check the part of the file is the same when resuming
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// uploadFileName returns the state file of the upload. It is identified by the model, storage and
+// the file name without package ID (e.g. `.tar.gz-001`), which are stable across the backups.
+func (s *S3) uploadFileName(remotePath string) string {
+	name := path.Base(filepath.ToSlash(remotePath))
+	suffix := strings.TrimPrefix(name, manifest.PackageID(name))
+	id := fmt.Sprintf("%s/%s/%s/%s/%s", s.bucket, s.path, s.model.Name, s.label, suffix)
+
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(s3UploadsPath(), hex.EncodeToString(sum[:16])+".json")
+}
+
+func loadS3Upload(fileName string) (*s3Upload, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &s3Upload{}
+	if err := json.Unmarshal(data, upload); err != nil {
+		return nil, err
+	}
+	upload.fileName = fileName
+
+	return upload, nil
+}
+
+// save the state atomically, it may be interrupted at any time
+func (u *s3Upload) save() error {
+	if err := helper.MkdirP(s3UploadsPath()); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	tmpName := u.fileName + ".tmp"
+	if err := os.WriteFile(tmpName, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, u.fileName)
+}
+
+func (u *s3Upload) remove() {
+	if len(u.fileName) == 0 {
+		return
+	}
+	if err := os.Remove(u.fileName); err != nil && !os.IsNotExist(err) {
+		logger.Tag("S3").Warnf("Remove upload state %s failed: %v", u.fileName, err)
+	}
+}
+
+// partRange returns the offset and size of the part in the file
+func (u *s3Upload) partRange(number int64) (int64, int64) {
+	offset := (number - 1) * u.PartSize
+	return offset, min(u.PartSize, u.Size-offset)
+}
+
+// partSHA256 returns the SHA256 of the part in the file
+func (u *s3Upload) partSHA256(f *os.File, number int64) (string, error) {
+	offset, size := u.partRange(number)
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, offset, size)); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// partSizeFor returns the part size of the file, it will be increased to fit in the 10000 parts limit
+func (s *S3) partSizeFor(size int64) int64 {
+	partSize := s.partSize
+	if size/partSize >= s3MaxParts {
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+	}
+
+	return partSize
+}
+
+// multipartUpload upload the file in parts with `concurrency` workers.
+// The completed parts are recorded until the upload is completed, so a failed upload can be resumed
+// when the same content is uploaded again, e.g. by the retries or the next backup.
+func (s *S3) multipartUpload(f *os.File, remotePath string, progress helper.ProgressBar) (string, error) {
+	logger := logger.Tag(s.providerName())
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	partSize := s.partSizeFor(fi.Size())
+	fileName := s.uploadFileName(remotePath)
+
+	upload, completed := s.resumeUpload(fileName, f, fi.Size(), partSize)
+	if upload == nil {
+		input := &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(remotePath),
+		}
+		if len(s.storageClass) > 0 {
+			input.StorageClass = aws.String(s.storageClass)
+		}
+		result, err := s.client.S3.CreateMultipartUpload(input)
+		if err != nil {
+			return "", fmt.Errorf("create multipart upload failed: %v", err)
+		}
+
+		upload = &s3Upload{
+			Bucket:    s.bucket,
+			Key:       remotePath,
+			UploadID:  *result.UploadId,
+			Size:      fi.Size(),
+			PartSize:  partSize,
+			CreatedAt: time.Now(),
+			fileName:  fileName,
+		}
+	}
+	upload.Parts = nil
+	for _, part := range completed {
+		upload.Parts = append(upload.Parts, part)
+	}
+	if err := upload.save(); err != nil {
+		logger.Warnf("Save upload state failed, the upload will not be resumable: %v", err)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	jobs := make(chan int64)
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range jobs {
+				mu.Lock()
+				failed := firstErr != nil
+				mu.Unlock()
+				if failed {
+					continue
+				}
+
+				part, err := s.uploadPart(upload, f, number)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					upload.Parts = append(upload.Parts, part)
+					if err := upload.save(); err != nil {
+						logger.Warnf("Save upload state failed: %v", err)
+					}
+					progress.Add(part.Size)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	numParts := (fi.Size() + partSize - 1) / partSize
+	for number := int64(1); number <= numParts; number++ {
+		if part, ok := completed[number]; ok {
+			progress.Add(part.Size)
+			continue
+		}
+
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		jobs <- number
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		logger.Warnf("Upload %s failed, %d/%d parts completed, it will be resumed when the same content is uploaded again", remotePath, len(upload.Parts), numParts)
+		return "", firstErr
+	}
+
+	sort.Slice(upload.Parts, func(i, j int) bool {
+		return upload.Parts[i].Number < upload.Parts[j].Number
+	})
+	parts := make([]*s3.CompletedPart, 0, len(upload.Parts))
+	for _, part := range upload.Parts {
+		parts = append(parts, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.Number),
+		})
+	}
+
+	result, err := s.client.S3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(upload.Key),
+		UploadId:        aws.String(upload.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return "", fmt.Errorf("complete multipart upload failed: %v", err)
+	}
+	upload.remove()
+
+	if upload.Key != remotePath {
+		// The upload was created by a previous backup with its file key
+		if err := s.moveObject(upload.Key, remotePath, upload.Size, partSize); err != nil {
+			return "", err
+		}
+		return remotePath, nil
+	}
+
+	return aws.StringValue(result.Location), nil
+}
+
+// resumeUpload returns the upload to resume and its completed parts. The recorded parts are reusable
+// when they are listed from the storage and the same as the file, otherwise the upload will be aborted.
+func (s *S3) resumeUpload(fileName string, f *os.File, size, partSize int64) (*s3Upload, map[int64]s3UploadPart) {
+	logger := logger.Tag(s.providerName())
+
+	upload, err := loadS3Upload(fileName)
+	if err != nil {
+		return nil, nil
+	}
+
+	if upload.Bucket != s.bucket || upload.Size != size || upload.PartSize != partSize {
+		logger.Infof("The size of upload %s has been changed, abort it", upload.Key)
+		s.abortUpload(upload)
+		return nil, nil
+	}
+
+	parts, err := s.listParts(upload)
+	if err != nil {
+		logger.Warnf("List parts of upload %s failed, restart it: %v", upload.Key, err)
+		s.abortUpload(upload)
+		return nil, nil
+	}
+	uploaded := map[int64]s3UploadPart{}
+	for _, part := range parts {
+		uploaded[part.Number] = part
+	}
+
+	completed := map[int64]s3UploadPart{}
+	for _, part := range upload.Parts {
+		if p, ok := uploaded[part.Number]; !ok || p.ETag != part.ETag || p.Size != part.Size {
+			continue
+		}
+		if sum, err := upload.partSHA256(f, part.Number); err != nil || sum != part.SHA256 {
+			continue
+		}
+		completed[part.Number] = part
+	}
+
+	if len(completed) == 0 {
+		logger.Infof("The file of upload %s has been changed, abort it", upload.Key)
+		s.abortUpload(upload)
+		return nil, nil
+	}
+
+	logger.Infof("Resume upload %s, %d parts completed", upload.Key, len(completed))
+	return upload, completed
+}
+
+func (s *S3) uploadPart(upload *s3Upload, f *os.File, number int64) (s3UploadPart, error) {
+	offset, size := upload.partRange(number)
+	sum, err := upload.partSHA256(f, number)
+	if err != nil {
+		return s3UploadPart{}, fmt.Errorf("read part %d failed: %v", number, err)
+	}
+
+	result, err := s.client.S3.UploadPartWithContext(aws.BackgroundContext(), &s3.UploadPartInput{
+		Bucket:        aws.String(upload.Bucket),
+		Key:           aws.String(upload.Key),
+		UploadId:      aws.String(upload.UploadID),
+		PartNumber:    aws.Int64(number),
+		ContentLength: aws.Int64(size),
+		Body:          io.NewSectionReader(f, offset, size),
+	}, s.limitSend)
+	if err != nil {
+		return s3UploadPart{}, fmt.Errorf("upload part %d failed: %v", number, err)
+	}
+
+	return s3UploadPart{Number: number, ETag: aws.StringValue(result.ETag), Size: size, SHA256: sum}, nil
+}
+
+// moveObject move the object of a resumed upload to the key. The source object is removed even if
+// the copy failed, it is not a backup by itself.
+func (s *S3) moveObject(srcKey, dstKey string, size, partSize int64) error {
+	defer func() {
+		_, err := s.client.S3.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(srcKey),
+		})
+		if err != nil {
+			logger.Tag(s.providerName()).Warnf("Delete %s failed: %v", srcKey, err)
+		}
+	}()
+
+	copySource := (&url.URL{Path: s.bucket + "/" + srcKey}).EscapedPath()
+	if size <= s3MaxCopySize {
+		input := &s3.CopyObjectInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(dstKey),
+			CopySource: aws.String(copySource),
+		}
+		if len(s.storageClass) > 0 {
+			input.StorageClass = aws.String(s.storageClass)
+		}
+		if _, err := s.client.S3.CopyObject(input); err != nil {
+			return fmt.Errorf("copy %s to %s failed: %v", srcKey, dstKey, err)
+		}
+		return nil
+	}
+
+	// CopyObject is limited to 5GB, copy it in parts
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(dstKey),
+	}
+	if len(s.storageClass) > 0 {
+		input.StorageClass = aws.String(s.storageClass)
+	}
+	result, err := s.client.S3.CreateMultipartUpload(input)
+	if err != nil {
+		return fmt.Errorf("copy %s to %s failed: %v", srcKey, dstKey, err)
+	}
+	upload := &s3Upload{Bucket: s.bucket, Key: dstKey, UploadID: aws.StringValue(result.UploadId), Size: size, PartSize: partSize}
+
+	var parts []*s3.CompletedPart
+	for number := int64(1); (number-1)*partSize < size; number++ {
+		offset, n := upload.partRange(number)
+		part, err := s.client.S3.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             aws.String(dstKey),
+			UploadId:        aws.String(upload.UploadID),
+			PartNumber:      aws.Int64(number),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+n-1)),
+		})
+		if err != nil {
+			s.abortUpload(upload)
+			return fmt.Errorf("copy part %d of %s failed: %v", number, srcKey, err)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int64(number)})
+	}
+
+	_, err = s.client.S3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(dstKey),
+		UploadId:        aws.String(upload.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		s.abortUpload(upload)
+		return fmt.Errorf("copy %s to %s failed: %v", srcKey, dstKey, err)
+	}
+
+	return nil
+}
+
+// limitSend limit the bandwidth of sending the request body. The body is read by the SDK to compute the
+// hashes before sending, so it is limited in the send handler instead of the body itself.
+func (s *S3) limitSend(r *request.Request) {
+	if s.bandwidth == nil {
+		return
+	}
+
+	r.Handlers.Send.PushFront(func(r *request.Request) {
+		body := r.HTTPRequest.Body
+		if body == nil || body == http.NoBody {
+			return
+		}
+		r.HTTPRequest.Body = struct {
+			io.Reader
+			io.Closer
+		}{s.bandwidth.Reader(body), body}
+	})
+}
+
+func (s *S3) listParts(upload *s3Upload) (parts []s3UploadPart, err error) {
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(upload.Bucket),
+		Key:      aws.String(upload.Key),
+		UploadId: aws.String(upload.UploadID),
+	}
+
+	err = s.client.S3.ListPartsPages(input, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			parts = append(parts, s3UploadPart{
+				Number: aws.Int64Value(part.PartNumber),
+				ETag:   aws.StringValue(part.ETag),
+				Size:   aws.Int64Value(part.Size),
+			})
+		}
+		return true
+	})
+
+	return parts, err
+}
+
+func (s *S3) abortUpload(upload *s3Upload) {
+	_, err := s.client.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(upload.Bucket),
+		Key:      aws.String(upload.Key),
+		UploadId: aws.String(upload.UploadID),
+	})
+
+	var aerr awserr.Error
+	if err != nil && !(errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchUpload) {
+		logger.Tag(s.providerName()).Warnf("Abort upload %s failed: %v", upload.Key, err)
+	}
+
+	upload.remove()
+}
+
+// cleanupUploads abort the stale multipart uploads of the bucket:
+//
+// - the recorded uploads which are older than `resume_ttl`, they are kept until then even if the file has been
+// removed, so the next backup can resume it with the same content
+// - the unfinished uploads under the `path` which are initiated before `resume_ttl`, e.g.: the process was killed
+func (s *S3) cleanupUploads() {
+	logger := logger.Tag(s.providerName())
+
+	entries, _ := os.ReadDir(s3UploadsPath())
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+
+		upload, err := loadS3Upload(filepath.Join(s3UploadsPath(), e.Name()))
+		if err != nil || upload.Bucket != s.bucket {
+			continue
+		}
+
+		if time.Since(upload.CreatedAt) > s.resumeTTL {
+			logger.Infof("Abort stale upload %s", upload.Key)
+			s.abortUpload(upload)
+		}
+	}
+
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(strings.TrimPrefix(s.path, "/")),
+	}
+	err := s.client.S3.ListMultipartUploadsPages(input, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, u := range page.Uploads {
+			if u.Initiated == nil || time.Since(*u.Initiated) <= s.resumeTTL {
+				continue
+			}
+
+			logger.Infof("Abort stale upload %s", aws.StringValue(u.Key))
+			s.abortUpload(&s3Upload{
+				Bucket:   s.bucket,
+				Key:      aws.StringValue(u.Key),
+				UploadID: aws.StringValue(u.UploadId),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		// Some S3 compatible storages do not support to list multipart uploads
+		logger.Debugf("List multipart uploads failed: %v", err)
+	}
+}
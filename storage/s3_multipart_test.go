@@ -0,0 +1,549 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/helper"
+	"github.com/itgcloud/gobackup/logger"
+)
+
+// fakeS3 implements the multipart upload API in memory
+type fakeS3 struct {
+	s3iface.S3API
+
+	mu       sync.Mutex
+	uploads  map[string]*fakeUpload
+	objects  map[string][]byte
+	nextID   int
+	failPart int64
+	aborted  []string
+	uploaded []int64
+	copied   []string
+}
+
+type fakeUpload struct {
+	key       string
+	initiated time.Time
+	parts     map[int64][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{uploads: map[string]*fakeUpload{}, objects: map[string][]byte{}}
+}
+
+func (f *fakeS3) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	id := fmt.Sprintf("upload-%d", f.nextID)
+	f.uploads[id] = &fakeUpload{key: aws.StringValue(input.Key), initiated: time.Now(), parts: map[int64][]byte{}}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (f *fakeS3) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	number := aws.Int64Value(input.PartNumber)
+	if number == f.failPart {
+		return nil, fmt.Errorf("connection reset")
+	}
+	upload, ok := f.uploads[aws.StringValue(input.UploadId)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "no such upload", nil)
+	}
+	upload.parts[number] = data
+	f.uploaded = append(f.uploaded, number)
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", number))}, nil
+}
+
+func (f *fakeS3) UploadPartWithContext(_ aws.Context, input *s3.UploadPartInput, _ ...request.Option) (*s3.UploadPartOutput, error) {
+	return f.UploadPart(input)
+}
+
+func (f *fakeS3) ListPartsPages(input *s3.ListPartsInput, fn func(*s3.ListPartsOutput, bool) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	upload, ok := f.uploads[aws.StringValue(input.UploadId)]
+	if !ok {
+		return awserr.New(s3.ErrCodeNoSuchUpload, "no such upload", nil)
+	}
+	output := &s3.ListPartsOutput{}
+	for number, data := range upload.parts {
+		output.Parts = append(output.Parts, &s3.Part{
+			PartNumber: aws.Int64(number),
+			ETag:       aws.String(fmt.Sprintf("etag-%d", number)),
+			Size:       aws.Int64(int64(len(data))),
+		})
+	}
+	fn(output, true)
+	return nil
+}
+
+func (f *fakeS3) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	upload := f.uploads[aws.StringValue(input.UploadId)]
+	if upload.key != aws.StringValue(input.Key) {
+		return nil, fmt.Errorf("invalid key")
+	}
+	var buf bytes.Buffer
+	for i, part := range input.MultipartUpload.Parts {
+		if aws.Int64Value(part.PartNumber) != int64(i+1) {
+			return nil, fmt.Errorf("invalid part order")
+		}
+		buf.Write(upload.parts[aws.Int64Value(part.PartNumber)])
+	}
+	f.objects[aws.StringValue(input.Key)] = buf.Bytes()
+	delete(f.uploads, aws.StringValue(input.UploadId))
+	return &s3.CompleteMultipartUploadOutput{Location: aws.String("s3://" + aws.StringValue(input.Key))}, nil
+}
+
+func (f *fakeS3) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.aborted = append(f.aborted, aws.StringValue(input.UploadId))
+	delete(f.uploads, aws.StringValue(input.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) ListMultipartUploadsPages(input *s3.ListMultipartUploadsInput, fn func(*s3.ListMultipartUploadsOutput, bool) bool) error {
+	f.mu.Lock()
+	output := &s3.ListMultipartUploadsOutput{}
+	for id, upload := range f.uploads {
+		if !strings.HasPrefix(upload.key, aws.StringValue(input.Prefix)) {
+			continue
+		}
+		output.Uploads = append(output.Uploads, &s3.MultipartUpload{
+			Key:       aws.String(upload.key),
+			UploadId:  aws.String(id),
+			Initiated: aws.Time(upload.initiated),
+		})
+	}
+	f.mu.Unlock()
+
+	fn(output, true)
+	return nil
+}
+
+// sourceKey returns the key of the CopySource in bucket/key
+func sourceKey(source string) (string, error) {
+	source, err := url.PathUnescape(source)
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(source, "/", 2)[1], nil
+}
+
+func (f *fakeS3) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key, err := sourceKey(aws.StringValue(input.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	f.objects[aws.StringValue(input.Key)] = f.objects[key]
+	f.copied = append(f.copied, key)
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) UploadPartCopy(input *s3.UploadPartCopyInput) (*s3.UploadPartCopyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key, err := sourceKey(aws.StringValue(input.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	var start, end int
+	if _, err := fmt.Sscanf(aws.StringValue(input.CopySourceRange), "bytes=%d-%d", &start, &end); err != nil {
+		return nil, err
+	}
+	number := aws.Int64Value(input.PartNumber)
+	f.uploads[aws.StringValue(input.UploadId)].parts[number] = f.objects[key][start : end+1]
+	f.copied = append(f.copied, fmt.Sprintf("%s:%d", key, number))
+	return &s3.UploadPartCopyOutput{CopyPartResult: &s3.CopyPartResult{ETag: aws.String(fmt.Sprintf("etag-%d", number))}}, nil
+}
+
+func (f *fakeS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.objects, aws.StringValue(input.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func TestS3_multipartUpload(t *testing.T) {
+	setTempHome(t)
+
+	data := bytes.Repeat([]byte("0123456789"), 1024*1024)
+	sourcePath := filepath.Join(t.TempDir(), "foo.tar")
+	assert.NoError(t, os.WriteFile(sourcePath, data, 0640))
+
+	fake := newFakeS3()
+	fake.failPart = 3
+	s := &S3{
+		bucket:      "test-bucket",
+		client:      &s3manager.Uploader{S3: fake},
+		concurrency: 1,
+		partSize:    s3MinPartSize,
+		resumeTTL:   24 * time.Hour,
+	}
+
+	upload := func() (string, error) {
+		f, err := os.Open(sourcePath)
+		assert.NoError(t, err)
+		defer f.Close()
+
+		progress := helper.NewProgressBar(logger.Tag("Test"), f, "test")
+		return s.multipartUpload(f, "backups/foo.tar", progress)
+	}
+
+	// 10MiB in 5MiB parts is 2 parts
+	_, err := upload()
+	assert.NoError(t, err)
+	assert.Equal(t, data, fake.objects["backups/foo.tar"])
+	entries, _ := os.ReadDir(s3UploadsPath())
+	assert.Equal(t, 0, len(entries))
+
+	// Fail at the 3rd part, the first 2 parts are recorded
+	data = bytes.Repeat([]byte("0123456789"), 2*1024*1024)
+	assert.NoError(t, os.WriteFile(sourcePath, data, 0640))
+	fake.uploaded = nil
+	_, err = upload()
+	assert.EqualError(t, err, "upload part 3 failed: connection reset")
+	assert.Equal(t, []int64{1, 2}, fake.uploaded)
+
+	state, err := loadS3Upload(s.uploadFileName("backups/foo.tar"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(state.Parts))
+
+	// Resume from the 3rd part
+	fake.failPart = 0
+	fake.uploaded = nil
+	location, err := upload()
+	assert.NoError(t, err)
+	assert.Equal(t, "s3://backups/foo.tar", location)
+	assert.Equal(t, []int64{3, 4}, fake.uploaded)
+	assert.Equal(t, data, fake.objects["backups/foo.tar"])
+	assert.Equal(t, 0, len(fake.aborted))
+
+	// The file has been changed, restart
+	fake.failPart = 2
+	_, err = upload()
+	assert.Error(t, err)
+	assert.NoError(t, os.WriteFile(sourcePath, data[:len(data)-1], 0640))
+	fake.failPart = 0
+	fake.uploaded = nil
+	_, err = upload()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(fake.aborted))
+	uploaded := append([]int64{}, fake.uploaded...)
+	sort.Slice(uploaded, func(i, j int) bool { return uploaded[i] < uploaded[j] })
+	assert.Equal(t, []int64{1, 2, 3, 4}, uploaded)
+	assert.Equal(t, data[:len(data)-1], fake.objects["backups/foo.tar"])
+}
+
+func TestS3_upload_resumeNextBackup(t *testing.T) {
+	setTempHome(t)
+
+	fake := newFakeS3()
+	newS3 := func() *S3 {
+		return &S3{
+			Base:        Base{model: config.ModelConfig{Name: "demo"}, label: "s3"},
+			bucket:      "test-bucket",
+			path:        "backups",
+			client:      &s3manager.Uploader{S3: fake},
+			concurrency: 1,
+			partSize:    s3MinPartSize,
+			resume:      true,
+			resumeTTL:   24 * time.Hour,
+		}
+	}
+	// Each backup has its own temp path and package name
+	backup := func(fileKey string, data []byte) error {
+		archivePath := filepath.Join(t.TempDir(), fileKey)
+		assert.NoError(t, os.WriteFile(archivePath, data, 0640))
+		s := newS3()
+		s.archivePath = archivePath
+		return s.upload(fileKey)
+	}
+
+	// The process is killed at the 3rd part
+	data := bytes.Repeat([]byte("0123456789"), 2*1024*1024)
+	fake.failPart = 3
+	assert.Error(t, backup("demo-2026-01-01-00-00-00.tar.gz", data))
+	assert.Equal(t, []int64{1, 2}, fake.uploaded)
+
+	// The next backup with the same content resumes from the 3rd part, and moves the object to its name
+	fake.failPart = 0
+	fake.uploaded = nil
+	assert.NoError(t, backup("demo-2026-01-02-00-00-00.tar.gz", data))
+	assert.Equal(t, []int64{3, 4}, fake.uploaded)
+	assert.Equal(t, 0, len(fake.aborted))
+	assert.Equal(t, []string{"backups/demo-2026-01-01-00-00-00.tar.gz"}, fake.copied)
+	assert.Equal(t, 1, len(fake.objects))
+	assert.Equal(t, data, fake.objects["backups/demo-2026-01-02-00-00-00.tar.gz"])
+	entries, _ := os.ReadDir(s3UploadsPath())
+	assert.Equal(t, 0, len(entries))
+
+	// The parts which have been changed are uploaded again, the object larger than 5GB is copied in parts
+	defer func(size int64) { s3MaxCopySize = size }(s3MaxCopySize)
+	s3MaxCopySize = s3MinPartSize
+	fake.failPart = 3
+	assert.Error(t, backup("demo-2026-01-03-00-00-00.tar.gz", data))
+	changed := append([]byte("changed"), data[7:]...)
+	fake.failPart = 0
+	fake.uploaded = nil
+	fake.copied = nil
+	assert.NoError(t, backup("demo-2026-01-04-00-00-00.tar.gz", changed))
+	assert.Equal(t, []int64{1, 3, 4}, fake.uploaded)
+	assert.Equal(t, []string{
+		"backups/demo-2026-01-03-00-00-00.tar.gz:1", "backups/demo-2026-01-03-00-00-00.tar.gz:2",
+		"backups/demo-2026-01-03-00-00-00.tar.gz:3", "backups/demo-2026-01-03-00-00-00.tar.gz:4",
+	}, fake.copied)
+	assert.Equal(t, changed, fake.objects["backups/demo-2026-01-04-00-00-00.tar.gz"])
+	_, ok := fake.objects["backups/demo-2026-01-03-00-00-00.tar.gz"]
+	assert.False(t, ok)
+
+	// The content has been changed, the upload is aborted and started over
+	fake.failPart = 3
+	assert.Error(t, backup("demo-2026-01-05-00-00-00.tar.gz", data))
+	fake.failPart = 0
+	fake.uploaded = nil
+	fake.aborted = nil
+	other := bytes.Repeat([]byte("abcdefghij"), 2*1024*1024)
+	assert.NoError(t, backup("demo-2026-01-06-00-00-00.tar.gz", other))
+	assert.Equal(t, 1, len(fake.aborted))
+	assert.Equal(t, []int64{1, 2, 3, 4}, fake.uploaded)
+	assert.Equal(t, other, fake.objects["backups/demo-2026-01-06-00-00-00.tar.gz"])
+	assert.Equal(t, 0, len(fake.uploads))
+}
+
+// reopenS3 keeps the fake client when it is re-opened for retries, and the failure is recovered
+type reopenS3 struct {
+	*S3
+	fake *fakeS3
+}
+
+func (s reopenS3) open() error {
+	s.fake.failPart = 0
+	return nil
+}
+
+func (s reopenS3) close() {}
+
+func TestS3_uploadChunks_retry(t *testing.T) {
+	setTempHome(t)
+	sleep = func(d time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	archivePath := filepath.Join(t.TempDir(), "foo")
+	assert.NoError(t, os.MkdirAll(archivePath, 0750))
+	fileKeys := []string{"foo/foo.tar-aa", "foo/foo.tar-ab"}
+	chunks := map[string][]byte{}
+	for i, key := range fileKeys {
+		chunks[key] = bytes.Repeat([]byte{byte('a' + i)}, 12*1024*1024)
+		assert.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(archivePath), key), chunks[key], 0640))
+	}
+
+	fake := newFakeS3()
+	fake.failPart = 2
+	s3 := &S3{
+		Base:        Base{model: config.ModelConfig{Name: "foo"}, label: "s3", archivePath: archivePath, fileKeys: fileKeys},
+		bucket:      "test-bucket",
+		path:        "backups",
+		client:      &s3manager.Uploader{S3: fake},
+		concurrency: 1,
+		partSize:    s3MinPartSize,
+		resume:      true,
+		resumeTTL:   24 * time.Hour,
+	}
+	s := &retryStorage{Storage: reopenS3{s3, fake}, policy: RetryPolicy{Retries: 3}, label: "s3", archivePath: archivePath, fileKeys: fileKeys}
+	assert.NoError(t, s.upload("foo"))
+
+	// The chunks are uploaded in parts, and the retry of first chunk is resumed from the failed part
+	assert.Equal(t, []int64{1, 2, 3, 1, 2, 3}, fake.uploaded)
+	assert.Equal(t, 2, fake.nextID)
+	assert.Equal(t, 0, len(fake.aborted))
+	for _, key := range fileKeys {
+		assert.Equal(t, chunks[key], fake.objects["backups/"+key])
+	}
+}
+
+func TestS3_multipartUpload_concurrency(t *testing.T) {
+	setTempHome(t)
+
+	data := bytes.Repeat([]byte("0123456789"), 3*1024*1024)
+	sourcePath := filepath.Join(t.TempDir(), "foo.tar")
+	assert.NoError(t, os.WriteFile(sourcePath, data, 0640))
+
+	fake := newFakeS3()
+	s := &S3{
+		bucket:      "test-bucket",
+		client:      &s3manager.Uploader{S3: fake},
+		concurrency: 4,
+		partSize:    s3MinPartSize,
+	}
+
+	f, err := os.Open(sourcePath)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	_, err = s.multipartUpload(f, "foo.tar", helper.NewProgressBar(logger.Tag("Test"), f, "test"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(fake.uploaded))
+	assert.Equal(t, data, fake.objects["foo.tar"])
+}
+
+func TestS3_cleanupUploads(t *testing.T) {
+	setTempHome(t)
+
+	fake := newFakeS3()
+	s := &S3{
+		bucket:    "test-bucket",
+		path:      "backups",
+		client:    &s3manager.Uploader{S3: fake},
+		resumeTTL: 24 * time.Hour,
+	}
+
+	newState := func(bucket, key string, createdAt time.Time) *s3Upload {
+		upload := &s3Upload{Bucket: bucket, Key: key, UploadID: path.Base(key), CreatedAt: createdAt, fileName: filepath.Join(s3UploadsPath(), path.Base(key)+".json")}
+		assert.NoError(t, upload.save())
+		fake.uploads[upload.UploadID] = &fakeUpload{key: key, initiated: createdAt, parts: map[int64][]byte{}}
+		return upload
+	}
+
+	// Kept for the next backup, even the file has been removed
+	recent := newState("test-bucket", "backups/recent", time.Now())
+	expired := newState("test-bucket", "backups/expired", time.Now().Add(-48*time.Hour))
+	// Other bucket
+	other := newState("other-bucket", "backups/other", time.Now().Add(-48*time.Hour))
+	delete(fake.uploads, "other")
+	// Initiated 2 days ago without state
+	fake.uploads["stale"] = &fakeUpload{key: "backups/stale", initiated: time.Now().Add(-48 * time.Hour), parts: map[int64][]byte{}}
+	// Not under the path
+	fake.uploads["outside"] = &fakeUpload{key: "others/outside", initiated: time.Now().Add(-48 * time.Hour), parts: map[int64][]byte{}}
+
+	s.cleanupUploads()
+
+	sort.Strings(fake.aborted)
+	assert.Equal(t, []string{"expired", "stale"}, fake.aborted)
+	_, err := os.Stat(expired.fileName)
+	assert.True(t, os.IsNotExist(err))
+	for _, upload := range []*s3Upload{recent, other} {
+		_, err = os.Stat(upload.fileName)
+		assert.NoError(t, err)
+	}
+}
+
+func TestS3_open_multipart(t *testing.T) {
+	v := viper.New()
+	v.Set("bucket", "test-bucket")
+	v.Set("concurrency", 8)
+	v.Set("part_size", "128M")
+	v.Set("resume_ttl", "7d")
+
+	base, err := newBase(config.ModelConfig{}, "foo/bar", config.SubConfig{Type: "s3", Name: "s3", Viper: v})
+	assert.NoError(t, err)
+	s := &S3{Base: base}
+	assert.NoError(t, s.open())
+	assert.Equal(t, 8, s.concurrency)
+	assert.Equal(t, int64(128*1024*1024), s.partSize)
+	assert.True(t, s.resume)
+	assert.Equal(t, 7*24*time.Hour, s.resumeTTL)
+
+	// 10000 parts limit
+	assert.Equal(t, int64(128*1024*1024), s.partSizeFor(100*1024*1024*1024))
+	assert.Equal(t, int64(219902326), s.partSizeFor(2*1024*1024*1024*1024))
+
+	v.Set("part_size", "1M")
+	assert.EqualError(t, s.open(), "part_size must be at least 5MiB")
+}
+
+func TestS3_uploadPart_bandwidth(t *testing.T) {
+	var firstRead, lastRead time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := r.Body.Read(buf)
+			if n > 0 {
+				if firstRead.IsZero() {
+					firstRead = time.Now()
+				}
+				lastRead = time.Now()
+			}
+			if err != nil {
+				break
+			}
+		}
+		w.Header().Set("ETag", `"etag-1"`)
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("foo", "bar", ""),
+	})
+	assert.NoError(t, err)
+
+	bandwidth, err := helper.NewBandwidth("100KB/s", nil)
+	assert.NoError(t, err)
+	s := &S3{Base: Base{bandwidth: bandwidth}, client: s3manager.NewUploader(sess)}
+
+	size := int64(250 * 1000)
+	sourcePath := filepath.Join(t.TempDir(), "foo.tar")
+	assert.NoError(t, os.WriteFile(sourcePath, bytes.Repeat([]byte("0"), int(size)), 0640))
+	f, err := os.Open(sourcePath)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	start := time.Now()
+	upload := &s3Upload{Bucket: "test-bucket", Key: "foo.tar", UploadID: "upload-1", Size: size, PartSize: size}
+	part, err := s.uploadPart(upload, f, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, `"etag-1"`, part.ETag)
+
+	// The body is limited while sending (100KB burst, then 150KB in 1.5s), but not while computing the hashes
+	sending := lastRead.Sub(firstRead)
+	assert.True(t, sending >= time.Second, sending)
+	elapsed := time.Since(start)
+	assert.True(t, elapsed < 2500*time.Millisecond, elapsed)
+}
@@ -3,15 +3,18 @@ package storage
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bramvdbogaerde/go-scp"
 	"github.com/bramvdbogaerde/go-scp/auth"
+	"github.com/spf13/viper"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/itgcloud/gobackup/helper"
@@ -47,20 +50,35 @@ type SCP struct {
 }
 
 func (s *SCP) open() (err error) {
-	s.viper.SetDefault("port", "22")
-	s.viper.SetDefault("timeout", 300)
-	s.viper.SetDefault("private_key", "~/.ssh/id_rsa")
-
-	s.host = s.viper.GetString("host")
-	s.port = s.viper.GetString("port")
 	s.path = s.viper.GetString("path")
-	s.username = s.viper.GetString("username")
-	s.password = s.viper.GetString("password")
-	s.privateKey = helper.ExplandHome(s.viper.GetString("private_key"))
-	s.passpharase = s.viper.GetString("passpharase")
+
+	if s.client, err = s.dial(s.viper); err != nil {
+		return err
+	}
+
+	// mkdir
+	if err := s.run(fmt.Sprintf("mkdir -p %s", helper.ShellQuote(s.path))); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// dial the SSH server with the SSH options in viper
+func (s *SSH) dial(viper *viper.Viper) (*ssh.Client, error) {
+	viper.SetDefault("port", "22")
+	viper.SetDefault("timeout", 300)
+	viper.SetDefault("private_key", "~/.ssh/id_rsa")
+
+	s.host = viper.GetString("host")
+	s.port = viper.GetString("port")
+	s.username = viper.GetString("username")
+	s.password = viper.GetString("password")
+	s.privateKey = helper.ExplandHome(viper.GetString("private_key"))
+	s.passpharase = viper.GetString("passpharase")
 
 	if len(s.host) == 0 {
-		return fmt.Errorf("host is required")
+		return nil, fmt.Errorf("host is required")
 	}
 
 	if len(s.username) == 0 {
@@ -68,7 +86,7 @@ func (s *SCP) open() (err error) {
 		if err == nil {
 			s.username = user.Username
 		} else {
-			return fmt.Errorf("username is required and it is not able to get current user: %v", err)
+			return nil, fmt.Errorf("username is required and it is not able to get current user: %v", err)
 		}
 	}
 
@@ -80,21 +98,19 @@ func (s *SCP) open() (err error) {
 	}
 
 	clientConfig := newSSHClientConfig(sc)
-	clientConfig.Timeout = s.viper.GetDuration("timeout") * time.Second
+	clientConfig.Timeout = viper.GetDuration("timeout") * time.Second
 
-	sshClient, err := ssh.Dial("tcp", s.host+":"+s.port, &clientConfig)
+	client, err := ssh.Dial("tcp", s.host+":"+s.port, &clientConfig)
 	if err != nil {
-		return fmt.Errorf("failed to ssh %s@%s -p %s: %v", s.username, s.host, s.port, err)
+		return nil, fmt.Errorf("failed to ssh %s@%s -p %s: %v", s.username, s.host, s.port, err)
 	}
 
-	s.client = sshClient
-
-	// mkdir
-	if err := s.run(fmt.Sprintf("mkdir -p %s", s.path)); err != nil {
-		return err
-	}
+	return client, nil
+}
 
-	return nil
+// DialSSH dial the SSH server with the same options as the SCP and SFTP storages, e.g.: `archive.remote`
+func DialSSH(viper *viper.Viper) (*ssh.Client, error) {
+	return (&SSH{}).dial(viper)
 }
 
 func (s *SCP) run(cmd string) error {
@@ -134,7 +150,7 @@ func (s *SCP) upload(fileKey string) error {
 		remotePath := filepath.Join(s.path, key)
 
 		// mkdir
-		if err := s.run(fmt.Sprintf("mkdir -p %s", filepath.Dir(remotePath))); err != nil {
+		if err := s.run(fmt.Sprintf("mkdir -p %s", helper.ShellQuote(filepath.Dir(remotePath)))); err != nil {
 			return err
 		}
 
@@ -166,7 +182,7 @@ func (s *SCP) up(localPath, remotePath string) error {
 	}
 	defer file.Close()
 
-	progress := helper.NewProgressBar(logger, file)
+	progress := s.newProgressBar(logger, file)
 	if err := client.CopyFile(context.Background(), progress.Reader, remotePath, "0644"); err != nil {
 		return progress.Errorf("store %s failed: %v", remotePath, err)
 	}
@@ -175,6 +191,31 @@ func (s *SCP) up(localPath, remotePath string) error {
 	return nil
 }
 
+// uploadStream by `cat` over SSH session, because scp protocol requires the file size before send
+func (s *SCP) uploadStream(fileKey string, reader io.Reader) error {
+	logger := logger.Tag("SCP")
+
+	remotePath := path.Join(s.path, fileKey)
+	if err := s.run(fmt.Sprintf("mkdir -p %s", helper.ShellQuote(path.Dir(remotePath)))); err != nil {
+		return err
+	}
+
+	session, err := s.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	progress := s.newStreamProgressBar(logger, reader)
+	session.Stdin = progress.Reader
+	if err := session.Run(fmt.Sprintf("cat > %s", helper.ShellQuote(remotePath))); err != nil {
+		return progress.Errorf("store %s failed: %v", remotePath, err)
+	}
+	progress.Done(remotePath)
+
+	return nil
+}
+
 func (s *SCP) delete(fileKey string) (err error) {
 	logger := logger.Tag("SCP")
 
@@ -184,7 +225,7 @@ func (s *SCP) delete(fileKey string) (err error) {
 	if strings.HasSuffix(fileKey, "/") {
 		rmCmd = "rmdir"
 	}
-	if err := s.run(fmt.Sprintf("%s %s", rmCmd, remotePath)); err != nil {
+	if err := s.run(fmt.Sprintf("%s %s", rmCmd, helper.ShellQuote(remotePath))); err != nil {
 		return err
 	}
 
@@ -269,10 +310,162 @@ func newSSHClientConfig(c sshConfig) ssh.ClientConfig {
 	}
 }
 
+// list the files by `find` over a new SSH session, fallback to `ls` when `find -printf` is not supported (e.g. BusyBox)
 func (s *SCP) list(parent string) ([]FileItem, error) {
-	return nil, fmt.Errorf("SCP not support list")
+	remotePath := path.Join(s.path, parent)
+
+	out, err := s.output(fmt.Sprintf("find %s -mindepth 1 -maxdepth 1 -printf '%%y\\t%%s\\t%%T@\\t%%f\\n'", helper.ShellQuote(remotePath)))
+	if err != nil {
+		logger.Tag("SCP").Debugf("find failed, fallback to ls: %v", err)
+		if out, err = s.output(fmt.Sprintf("ls -1Ap %s", helper.ShellQuote(remotePath))); err != nil {
+			return nil, err
+		}
+		return parseLsOutput(out), nil
+	}
+
+	return parseFindOutput(out), nil
 }
 
+func (s *SCP) output(cmd string) (string, error) {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	out, err := session.Output(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s: %v", cmd, err)
+	}
+
+	return string(out), nil
+}
+
+// parseFindOutput parse the lines of `find -printf '%y\t%s\t%T@\t%f\n'`, only files and directories are returned
+func parseFindOutput(out string) (items []FileItem) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 || (fields[0] != "f" && fields[0] != "d") {
+			continue
+		}
+
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		item := FileItem{
+			Filename: fields[3],
+			Size:     size,
+			IsDir:    fields[0] == "d",
+		}
+		// %T@ is the seconds with fraction, e.g. 1672531200.1234567890
+		if mtime, err := strconv.ParseFloat(fields[2], 64); err == nil {
+			item.LastModified = time.Unix(int64(mtime), 0)
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// parseLsOutput parse the lines of `ls -1Ap`, the directories have the `/` suffix
+func parseLsOutput(out string) (items []FileItem) {
+	for _, line := range strings.Split(out, "\n") {
+		if len(line) == 0 {
+			continue
+		}
+
+		items = append(items, FileItem{
+			Filename: strings.TrimSuffix(line, "/"),
+			IsDir:    strings.HasSuffix(line, "/"),
+		})
+	}
+
+	return items
+}
+
+// SCP has no download URL, the file will be read by `read` and proxied by the web server
 func (s *SCP) download(fileKey string) (string, error) {
-	return "", fmt.Errorf("SCP not support download")
+	return "", ErrNoDownloadURL
+}
+
+// read file by `cat` over a new SSH session, the session is closed with the reader
+func (s *SCP) read(fileKey string) (io.ReadCloser, error) {
+	remotePath := path.Join(s.path, fileKey)
+	return s.stream(fmt.Sprintf("cat %s", helper.ShellQuote(remotePath)))
+}
+
+// stat file by `stat -c '%s %Y'`, the size and modification time in seconds
+func (s *SCP) stat(fileKey string) (FileItem, error) {
+	remotePath := path.Join(s.path, fileKey)
+
+	out, err := s.output(fmt.Sprintf("stat -c '%%s %%Y' %s", helper.ShellQuote(remotePath)))
+	if err != nil {
+		return FileItem{}, err
+	}
+
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return FileItem{}, fmt.Errorf("invalid stat output of %s: %q", remotePath, out)
+	}
+
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return FileItem{}, fmt.Errorf("invalid stat output of %s: %q", remotePath, out)
+	}
+	mtime, _ := strconv.ParseInt(fields[1], 10, 64)
+
+	return FileItem{Filename: fileKey, Size: size, LastModified: time.Unix(mtime, 0)}, nil
+}
+
+// readRange by `tail -c +N`, which is 1-based
+func (s *SCP) readRange(fileKey string, offset int64) (io.ReadCloser, error) {
+	remotePath := path.Join(s.path, fileKey)
+	return s.stream(fmt.Sprintf("tail -c +%d %s", offset+1, helper.ShellQuote(remotePath)))
+}
+
+// stream start the command over a new SSH session and returns its stdout,
+// the session is closed with the reader
+func (s *SCP) stream(cmd string) (io.ReadCloser, error) {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	if err := session.Start(cmd); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to run %s: %v", cmd, err)
+	}
+
+	return &sshReader{Reader: stdout, session: session}, nil
+}
+
+type sshReader struct {
+	io.Reader
+	session *ssh.Session
+	eof     bool
+}
+
+func (r *sshReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// Close wait the remote command exit status when the output has been fully read,
+// otherwise just abort the session.
+func (r *sshReader) Close() error {
+	defer r.session.Close()
+
+	if !r.eof {
+		return nil
+	}
+
+	return r.session.Wait()
 }
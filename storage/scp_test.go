@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestParseFindOutput(t *testing.T) {
+	out := "f\t1024\t1672531200.1234567890\tfoo.tar.gz\n" +
+		"d\t4096\t1672531260.0000000000\tfoo-2023-01-01\n" +
+		"l\t7\t1672531200.0000000000\tlink\n" +
+		"f\t10\t1672531200.0000000000\tfoo\tbar.txt\n" +
+		"\n"
+
+	assert.Equal(t, []FileItem{
+		{Filename: "foo.tar.gz", Size: 1024, LastModified: time.Unix(1672531200, 0)},
+		{Filename: "foo-2023-01-01", Size: 4096, LastModified: time.Unix(1672531260, 0), IsDir: true},
+		{Filename: "foo\tbar.txt", Size: 10, LastModified: time.Unix(1672531200, 0)},
+	}, parseFindOutput(out))
+}
+
+func TestParseLsOutput(t *testing.T) {
+	assert.Equal(t, []FileItem{
+		{Filename: "foo.tar.gz"},
+		{Filename: "foo-2023-01-01", IsDir: true},
+	}, parseLsOutput("foo.tar.gz\nfoo-2023-01-01/\n"))
+}
@@ -1,18 +1,13 @@
 package storage
 
 import (
-	"fmt"
 	"io"
 	"os"
-	"os/user"
 	"path"
 	"path/filepath"
-	"time"
 
 	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
 
-	"github.com/itgcloud/gobackup/helper"
 	"github.com/itgcloud/gobackup/logger"
 )
 
@@ -27,44 +22,11 @@ type SFTP struct {
 }
 
 func (s *SFTP) open() error {
-	s.viper.SetDefault("port", "22")
-	s.viper.SetDefault("timeout", 300)
-	s.viper.SetDefault("private_key", "~/.ssh/id_rsa")
-
-	s.host = s.viper.GetString("host")
-	s.port = s.viper.GetString("port")
 	s.path = s.viper.GetString("path")
-	s.username = s.viper.GetString("username")
-	s.password = s.viper.GetString("password")
-	s.privateKey = helper.ExplandHome(s.viper.GetString("private_key"))
-	s.passpharase = s.viper.GetString("passpharase")
-
-	if len(s.host) == 0 {
-		return fmt.Errorf("host is required")
-	}
-
-	if len(s.username) == 0 {
-		user, err := user.Current()
-		if err == nil {
-			s.username = user.Username
-		} else {
-			return fmt.Errorf("username is required and it is not able to get current user: %v", err)
-		}
-	}
 
-	sc := sshConfig{
-		username:    s.username,
-		password:    s.password,
-		privateKey:  s.privateKey,
-		passpharase: s.passpharase,
-	}
-
-	clientConfig := newSSHClientConfig(sc)
-	clientConfig.Timeout = s.viper.GetDuration("timeout") * time.Second
-
-	sshClient, err := ssh.Dial("tcp", s.host+":"+s.port, &clientConfig)
+	sshClient, err := s.dial(s.viper)
 	if err != nil {
-		return fmt.Errorf("Failed to ssh %s@%s -p %s: %v", s.username, s.host, s.port, err)
+		return err
 	}
 
 	client, err := sftp.NewClient(sshClient)
@@ -138,11 +100,35 @@ func (s *SFTP) up(localPath, remotePath string) error {
 	}
 	defer remoteFile.Close()
 
-	if _, err := io.Copy(remoteFile, file); err != nil {
-		logger.Errorf("Unable to upload local file %s: %v", localPath, err)
+	progress := s.newProgressBar(logger, file)
+	if _, err := remoteFile.ReadFrom(progress.Reader); err != nil {
+		return progress.Errorf("Unable to upload local file %s: %v", localPath, err)
+	}
+	progress.Done(remotePath)
+
+	return nil
+}
+
+func (s *SFTP) uploadStream(fileKey string, reader io.Reader) error {
+	logger := logger.Tag("SFTP")
+
+	remotePath := path.Join(s.path, fileKey)
+	if err := s.client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return err
+	}
+
+	remoteFile, err := s.client.OpenFile(remotePath, (os.O_WRONLY | os.O_CREATE | os.O_TRUNC))
+	if err != nil {
+		logger.Errorf("Unable to open remote file %s: %v", remotePath, err)
 		return err
 	}
-	logger.Infof("Store %s succeeded", remotePath)
+	defer remoteFile.Close()
+
+	progress := s.newStreamProgressBar(logger, reader)
+	if _, err := remoteFile.ReadFrom(progress.Reader); err != nil {
+		return progress.Errorf("Unable to upload to %s: %v", remotePath, err)
+	}
+	progress.Done(remotePath)
 
 	return nil
 }
@@ -168,18 +154,45 @@ func (s *SFTP) list(parent string) ([]FileItem, error) {
 		return nil, err
 	}
 	for _, fileInfo := range fileInfos {
-		if !fileInfo.IsDir() {
-			items = append(items, FileItem{
-				Filename:     fileInfo.Name(),
-				Size:         fileInfo.Size(),
-				LastModified: fileInfo.ModTime(),
-			})
-		}
+		items = append(items, FileItem{
+			Filename:     fileInfo.Name(),
+			Size:         fileInfo.Size(),
+			LastModified: fileInfo.ModTime(),
+			IsDir:        fileInfo.IsDir(),
+		})
 	}
 
 	return items, nil
 }
 
+// SFTP has no download URL, the file will be read by `read` and proxied by the web server
 func (s *SFTP) download(fileKey string) (string, error) {
-	return "", fmt.Errorf("SFTP not support download")
+	return "", ErrNoDownloadURL
+}
+
+func (s *SFTP) read(fileKey string) (io.ReadCloser, error) {
+	return s.client.Open(path.Join(s.path, fileKey))
+}
+
+func (s *SFTP) stat(fileKey string) (FileItem, error) {
+	info, err := s.client.Stat(path.Join(s.path, fileKey))
+	if err != nil {
+		return FileItem{}, err
+	}
+
+	return FileItem{Filename: fileKey, Size: info.Size(), LastModified: info.ModTime(), IsDir: info.IsDir()}, nil
+}
+
+func (s *SFTP) readRange(fileKey string, offset int64) (io.ReadCloser, error) {
+	f, err := s.client.Open(path.Join(s.path, fileKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
 }
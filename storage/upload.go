@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+	"github.com/itgcloud/gobackup/logger"
+)
+
+// UploadFile upload a single file into `dir` under the path of all storages of the model,
+// the cycler is not involved. It is used to archive the WAL segments of PostgreSQL,
+// so the error is returned when any of the storages failed.
+func UploadFile(model config.ModelConfig, dir string, filePath string) error {
+	logger := logger.Tag("Storage")
+
+	names := make([]string, 0, len(model.Storages))
+	for name := range model.Storages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errors []error
+	for _, name := range names {
+		storageConfig := subPathConfig(model.Storages[name], dir)
+
+		logger.Infof("=> Storage | %s (%s)", storageConfig.Type, name)
+		if err := uploadFile(model, filePath, storageConfig); err != nil {
+			if len(names) == 1 {
+				return err
+			}
+			errors = append(errors, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+
+	if len(errors) != 0 {
+		return fmt.Errorf("Storage errors: %v", errors)
+	}
+
+	return nil
+}
+
+func uploadFile(model config.ModelConfig, filePath string, storageConfig config.SubConfig) error {
+	_, s, err := new(model, filePath, storageConfig)
+	if err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	defer s.close()
+
+	return s.upload(filepath.Base(filePath))
+}
+
+// subPathConfig returns a copy of the storage config with `dir` joined to the `path`
+func subPathConfig(storageConfig config.SubConfig, dir string) config.SubConfig {
+	v := viper.New()
+	if storageConfig.Viper != nil {
+		for _, key := range storageConfig.Viper.AllKeys() {
+			v.Set(key, storageConfig.Viper.Get(key))
+		}
+	}
+	v.Set("path", path.Join(v.GetString("path"), dir))
+
+	storageConfig.Viper = v
+	return storageConfig
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+
+	"github.com/itgcloud/gobackup/config"
+)
+
+func TestSubPathConfig(t *testing.T) {
+	v := viper.New()
+	v.Set("path", "backups")
+	v.Set("bucket", "gobackup")
+	v.Set("retries", 3)
+
+	storageConfig := subPathConfig(config.SubConfig{Name: "s3", Type: "s3", Viper: v}, "wal/pg1")
+	assert.Equal(t, "s3", storageConfig.Name)
+	assert.Equal(t, "backups/wal/pg1", storageConfig.Viper.GetString("path"))
+	assert.Equal(t, "gobackup", storageConfig.Viper.GetString("bucket"))
+	assert.Equal(t, 3, storageConfig.Viper.GetInt("retries"))
+	assert.Equal(t, "backups", v.GetString("path"))
+
+	// No path
+	storageConfig = subPathConfig(config.SubConfig{Name: "gcs", Type: "gcs", Viper: viper.New()}, "wal/pg1")
+	assert.Equal(t, "wal/pg1", storageConfig.Viper.GetString("path"))
+}
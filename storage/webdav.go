@@ -2,13 +2,13 @@ package storage
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
 
 	"github.com/studio-b12/gowebdav"
 
-	"github.com/itgcloud/gobackup/helper"
 	"github.com/itgcloud/gobackup/logger"
 )
 
@@ -89,7 +89,7 @@ func (s *WebDAV) upload(fileKey string) error {
 		}
 		defer f.Close()
 
-		progress := helper.NewProgressBar(logger, f)
+		progress := s.newProgressBar(logger, f)
 		if err := s.client.WriteStream(remotePath, progress.Reader, 0644); err != nil {
 			return progress.Errorf("upload failed %v", err)
 		}
@@ -100,6 +100,23 @@ func (s *WebDAV) upload(fileKey string) error {
 	return nil
 }
 
+func (s *WebDAV) uploadStream(fileKey string, reader io.Reader) error {
+	logger := logger.Tag("WebDAV")
+
+	remotePath := path.Join(s.path, fileKey)
+	if err := s.client.MkdirAll(path.Dir(remotePath), 0644); err != nil {
+		return err
+	}
+
+	progress := s.newStreamProgressBar(logger, reader)
+	if err := s.client.WriteStream(remotePath, progress.Reader, 0644); err != nil {
+		return progress.Errorf("upload failed %v", err)
+	}
+	progress.Done(remotePath)
+
+	return nil
+}
+
 func (s *WebDAV) delete(fileKey string) error {
 	logger := logger.Tag("WebDAV")
 	remotePath := path.Join(s.path, fileKey)
@@ -118,18 +135,47 @@ func (s *WebDAV) list(parent string) ([]FileItem, error) {
 
 	var items []FileItem
 	for _, entry := range entries {
-		if !entry.IsDir() {
-			items = append(items, FileItem{
-				Filename:     entry.Name(),
-				Size:         entry.Size(),
-				LastModified: entry.ModTime(),
-			})
-		}
+		items = append(items, FileItem{
+			Filename:     entry.Name(),
+			Size:         entry.Size(),
+			LastModified: entry.ModTime(),
+			IsDir:        entry.IsDir(),
+		})
 	}
 
 	return items, nil
 }
 
+// WebDAV has no download URL, the file will be read by `readRange` and proxied by the web server
 func (s *WebDAV) download(fileKey string) (string, error) {
-	return "", fmt.Errorf("WebDAV not support download")
+	return "", ErrNoDownloadURL
+}
+
+func (s *WebDAV) read(fileKey string) (io.ReadCloser, error) {
+	return s.client.ReadStream(path.Join(s.path, fileKey))
+}
+
+func (s *WebDAV) stat(fileKey string) (FileItem, error) {
+	info, err := s.client.Stat(path.Join(s.path, fileKey))
+	if err != nil {
+		return FileItem{}, err
+	}
+
+	return FileItem{Filename: fileKey, Size: info.Size(), LastModified: info.ModTime(), IsDir: info.IsDir()}, nil
+}
+
+// readRange with HTTP Range request, gowebdav will skip the bytes when the server does not support it.
+// The length is required by the skipping, otherwise it returns nothing, so it is calculated by the size.
+func (s *WebDAV) readRange(fileKey string, offset int64) (io.ReadCloser, error) {
+	remotePath := path.Join(s.path, fileKey)
+	if offset == 0 {
+		return s.client.ReadStream(remotePath)
+	}
+
+	info, err := s.client.Stat(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.client.ReadStreamRange(remotePath, offset, info.Size()-offset)
 }
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/spf13/viper"
+	"golang.org/x/net/webdav"
+
+	"github.com/itgcloud/gobackup/config"
+)
+
+func TestWebDAV_readRange(t *testing.T) {
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+
+	for _, supportRange := range []bool{true, false} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !supportRange {
+				r.Header.Del("Range")
+			}
+			handler.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+
+		v := viper.New()
+		v.Set("root", server.URL)
+		v.Set("path", "backups")
+		_, s, err := new(config.ModelConfig{}, "", config.SubConfig{Type: "webdav", Viper: v})
+		assert.NoError(t, err)
+		assert.NoError(t, s.open())
+		assert.NoError(t, s.uploadStream("foo.tar", strings.NewReader("hello world")))
+
+		for offset, expected := range map[int64]string{0: "hello world", 6: "world", 10: "d"} {
+			r, err := s.readRange("foo.tar", offset)
+			assert.NoError(t, err)
+			data, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			r.Close()
+			assert.Equal(t, expected, string(data))
+		}
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
+	"mime"
 	"net/http"
 	"os"
 	"strings"
@@ -250,15 +252,44 @@ func download(c *gin.Context) {
 		return
 	}
 
+	if config.Web.DownloadMode == "proxy" {
+		proxyDownload(c, m, file)
+		return
+	}
+
 	downloadURL, err := storage.Download(m.Config, file)
-	if err != nil || len(downloadURL) == 0 {
+	if errors.Is(err, storage.ErrNoDownloadURL) {
+		proxyDownload(c, m, file)
+		return
+	}
+	if err != nil {
 		c.AbortWithError(500, err)
 		return
 	}
+	if len(downloadURL) == 0 {
+		c.AbortWithError(500, fmt.Errorf("Download URL of %s is empty", file))
+		return
+	}
 
 	c.Redirect(302, downloadURL)
 }
 
+// proxyDownload stream the file from the storage through gobackup,
+// when `web.download_mode` is `proxy` or the storage has no download URL (e.g. Local, WebDAV, SFTP).
+// Range requests are supported by http.ServeContent.
+func proxyDownload(c *gin.Context, m *model.Model, file string) {
+	f, err := storage.OpenDownload(m.Config, file)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": f.Name()}))
+	c.Header("Content-Type", "application/octet-stream")
+	http.ServeContent(c.Writer, c.Request, f.Name(), f.ModTime(), f)
+}
+
 // GET /api/log
 func log(c *gin.Context) {
 	// https://github.com/gin-gonic/examples/blob/master/realtime-chat/main.go#L27
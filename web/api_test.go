@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -68,3 +70,36 @@ func TestAPIPostPeform(t *testing.T) {
 	assert.Equal(t, 200, code)
 	assertMatchJSON(t, gin.H{"message": "Backup: test_model performed in background."}, body)
 }
+
+func TestAPIDownloadProxy(t *testing.T) {
+	storagePath := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(storagePath, "demo.tar"), []byte("hello world"), 0640))
+
+	v := config.GetModelConfigByName("base_test").Storages["local"].Viper
+	oldPath := v.GetString("path")
+	v.Set("path", storagePath)
+	t.Cleanup(func() { v.Set("path", oldPath) })
+
+	// Local has no download URL, it always be proxied
+	code, body := invokeHttp("GET", "/api/download?model=base_test&path=demo.tar", nil, nil)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "hello world", body)
+
+	config.Web.DownloadMode = "proxy"
+	t.Cleanup(func() { config.Web.DownloadMode = "redirect" })
+
+	r := setupRouter("master")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/download?model=base_test&path=demo.tar", nil)
+	req.Header.Add("Range", "bytes=6-")
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 206, w.Code)
+	assert.Equal(t, "world", w.Body.String())
+	assert.Equal(t, "bytes 6-10/11", w.Header().Get("Content-Range"))
+	assert.Equal(t, `attachment; filename=demo.tar`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+
+	code, _ = invokeHttp("GET", "/api/download?model=base_test&path=../demo.tar", nil, nil)
+	assert.Equal(t, 500, code)
+}